@@ -0,0 +1,246 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+/*
+ErrNotAdmitted is returned by TryEnqueue when the Admission of the queue
+rejects an item.
+*/
+var ErrNotAdmitted = errors.New("conq: item not admitted")
+
+/*
+Admission decides whether items are enqueued, so how a queue behaves under
+overload is a policy of its own. Admit is called with the queue and the item
+before every enqueue, without the queue locked. It accepts the item by
+returning 0 and nil, and it rejects the item by returning an error, which
+TryEnqueue returns. If it returns a delay greater than 0, Enqueue waits for the
+delay on the Clock of the queue and calls Admit again. Poison items are always
+admitted. Admit must be safe to call from several goroutines.
+
+An Admission that keeps state for admitted items can also have a method
+Cancel(q *Queue, item interface{}), which is called when an admitted item is
+then rejected by the queue, like with ErrClosed or ErrMemoryLimit, so it can
+undo the admission. Cancel is also called when an item leaves the queue other
+than by Dequeue and its variants, like with Remove, Take, Handle.Cancel,
+eviction by a Group, or Handoff. It is then called with the queue locked, so it
+must not call the methods of the queue.
+*/
+type Admission interface {
+	Admit(q *Queue, item interface{}) (time.Duration, error)
+}
+
+type admissionCanceler interface {
+	Cancel(q *Queue, item interface{})
+}
+
+/*
+TokenBucket admits Rate items per second with bursts of up to Burst items.
+The bucket starts full, and an item that finds no token defers its enqueue
+until the next token is added, so producers are slowed down to the rate
+instead of having their items rejected.
+*/
+type TokenBucket struct {
+	Burst  int     // most tokens in the bucket, defaults to 1
+	Rate   float64 // tokens added per second
+	last   time.Time
+	mut    sync.Mutex
+	tokens float64
+}
+
+/*
+ConcurrencyLimit admits up to Max items that are in flight at once, and it
+rejects items with ErrNotAdmitted while Max items are in flight. An item is in
+flight from when it is admitted until Release is called for it, which
+consumers do once they have handled the item. Items that the queue rejects
+after they are admitted, like with ErrClosed, are released by Cancel.
+*/
+type ConcurrencyLimit struct {
+	Max      int // most items in flight
+	inflight int
+	mut      sync.Mutex
+}
+
+/*
+CoDel rejects items while the queue is persistently slow, after the CoDel
+algorithm for controlled delay. It watches how long the item at the head of the
+queue has been waiting. If that delay stayed above Target for a whole Interval,
+the queue is overloaded, and items are rejected with ErrNotAdmitted until an
+Interval in which the delay went below Target. Short bursts that the consumers
+catch up with are admitted. The queue must have TrackAge set, as CoDel admits
+every item of queues that do not record when items are enqueued.
+*/
+type CoDel struct {
+	Interval   time.Duration // how long the delay must stay above Target, defaults to 100ms
+	Target     time.Duration // acceptable delay of the item at the head, defaults to 5ms
+	end        time.Time
+	min        time.Duration
+	mut        sync.Mutex
+	overloaded bool
+}
+
+/*
+Admit takes a token from the bucket, or it returns how long until the next
+token is added.
+*/
+func (b *TokenBucket) Admit(q *Queue, _ interface{}) (time.Duration, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	burst := float64(b.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	now := clockOr(q.Clock).Now()
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*b.Rate)
+	}
+
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+
+		return 0, nil
+	}
+
+	if b.Rate <= 0 {
+		return 0, ErrNotAdmitted
+	}
+
+	return time.Duration(math.Ceil((1 - b.tokens) / b.Rate * float64(time.Second))), nil
+}
+
+/*
+Cancel puts back the token that was taken for an item the queue rejected.
+*/
+func (b *TokenBucket) Cancel(_ *Queue, _ interface{}) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	burst := float64(b.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	b.tokens = math.Min(burst, b.tokens+1)
+}
+
+/*
+Admit admits the item if fewer than Max items are in flight.
+*/
+func (l *ConcurrencyLimit) Admit(_ *Queue, _ interface{}) (time.Duration, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.inflight >= l.Max {
+		return 0, ErrNotAdmitted
+	}
+
+	l.inflight += 1
+
+	return 0, nil
+}
+
+/*
+Release ends the flight of an admitted item, so another item can be admitted.
+*/
+func (l *ConcurrencyLimit) Release() {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.inflight > 0 {
+		l.inflight -= 1
+	}
+}
+
+/*
+Cancel ends the flight of an item the queue rejected.
+*/
+func (l *ConcurrencyLimit) Cancel(_ *Queue, _ interface{}) {
+	l.Release()
+}
+
+/*
+Admit admits the item unless the queue is overloaded.
+*/
+func (c *CoDel) Admit(q *Queue, _ interface{}) (time.Duration, error) {
+	now := clockOr(q.Clock).Now()
+
+	var delay time.Duration
+	if t, _, ok := q.headTime(); ok && !t.IsZero() {
+		delay = now.Sub(t)
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	interval := c.Interval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+
+	target := c.Target
+	if target <= 0 {
+		target = 5 * time.Millisecond
+	}
+
+	if c.end.IsZero() {
+		c.end = now.Add(interval)
+		c.min = delay
+	} else if delay < c.min {
+		c.min = delay
+	}
+
+	if !now.Before(c.end) {
+		c.overloaded = c.min > target
+		c.end = now.Add(interval)
+		c.min = delay
+	}
+
+	if c.overloaded {
+		return 0, ErrNotAdmitted
+	}
+
+	return 0, nil
+}
+
+func (q *Queue) admit(item interface{}) error {
+	if q.Admission == nil || item == ErrClosed {
+		return nil
+	}
+
+	for {
+		delay, err := q.Admission.Admit(q, item)
+		if err != nil {
+			q.lock()
+			q.dropped += 1
+			q.unlock()
+
+			return err
+		}
+
+		if delay <= 0 {
+			return nil
+		}
+
+		clockOr(q.Clock).Sleep(delay)
+	}
+}
+
+func (q *Queue) unadmit(item interface{}) {
+	if c, ok := q.Admission.(admissionCanceler); ok && item != ErrClosed {
+		c.Cancel(q, item)
+	}
+}
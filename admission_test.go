@@ -0,0 +1,321 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+type admitFunc func(q *conq.Queue, item interface{}) (time.Duration, error)
+
+func (f admitFunc) Admit(q *conq.Queue, item interface{}) (time.Duration, error) {
+	return f(q, item)
+}
+
+func TestQueue_Admission(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should return rejection":     shouldReturnAdmissionRejection,
+		"should defer until admitted": shouldDeferUntilAdmitted,
+		"should always admit poison":  shouldAlwaysAdmitPoison,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_AdmissionCancel(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should put back token of rejected item": shouldPutBackToken,
+		"should release slot of rejected item":   shouldReleaseRejectedSlot,
+		"should release slot of removed item":    shouldReleaseRemovedSlot,
+		"should release slot of cancelled item":  shouldReleaseCancelledSlot,
+		"should release slot of taken item":      shouldReleaseTakenSlot,
+		"should release slot of evicted item":    shouldReleaseEvictedSlot,
+		"should release slot of handed off item": shouldReleaseHandedOffSlot,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestTokenBucket_Admit(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should admit bursts":           shouldAdmitTokenBurst,
+		"should slow producers to rate": shouldSlowToTokenRate,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestConcurrencyLimit_Admit(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should limit items in flight": shouldLimitItemsInFlight,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestCoDel_Admit(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should admit short bursts":         shouldAdmitShortBursts,
+		"should reject while slow":          shouldRejectWhileSlow,
+		"should admit again once caught up": shouldAdmitOnceCaughtUp,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldReturnAdmissionRejection(t *testing.T, name string) {
+	errFull := errors.New("full")
+	queue := &conq.Queue{Admission: admitFunc(func(_ *conq.Queue, item interface{}) (time.Duration, error) {
+		if item == 2 {
+			return 0, errFull
+		}
+
+		return 0, nil
+	})}
+
+	queue.Enqueue(1)
+	err := queue.TryEnqueue(2)
+
+	if err != errFull || queue.Len() != 1 || queue.Stats().Dropped != 1 {
+		t.Fail()
+		t.Logf("%s: did not reject item: %v", name, err)
+	}
+}
+
+func shouldDeferUntilAdmitted(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	asked := 0
+	queue := &conq.Queue{Clock: sim, Admission: admitFunc(func(_ *conq.Queue, _ interface{}) (time.Duration, error) {
+		asked += 1
+		if asked < 3 {
+			return time.Second, nil
+		}
+
+		return 0, nil
+	})}
+	start := sim.Now()
+
+	if err := queue.TryEnqueue(1); err != nil || asked != 3 || sim.Now().Sub(start) != 2*time.Second || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: asked %d times over %v: %v", name, asked, sim.Now().Sub(start), err)
+	}
+}
+
+func shouldAlwaysAdmitPoison(t *testing.T, name string) {
+	queue := &conq.Queue{Admission: &conq.ConcurrencyLimit{}}
+	queue.EnqueuePoison(1)
+
+	if queue.Len() != 1 || queue.TryEnqueue(1) != conq.ErrNotAdmitted {
+		t.Fail()
+		t.Logf("%s: did not admit poison", name)
+	}
+}
+
+func shouldAdmitTokenBurst(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, Admission: &conq.TokenBucket{Burst: 3, Rate: 1}}
+	start := sim.Now()
+
+	for i := 0; i < 3; i++ {
+		queue.Enqueue(i)
+	}
+
+	if waited := sim.Now().Sub(start); waited != 0 || queue.Len() != 3 {
+		t.Fail()
+		t.Logf("%s: waited %v for a burst", name, waited)
+	}
+}
+
+func shouldSlowToTokenRate(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, Admission: &conq.TokenBucket{Rate: 10}}
+	start := sim.Now()
+
+	for i := 0; i < 11; i++ {
+		queue.Enqueue(i)
+	}
+
+	if waited := sim.Now().Sub(start); waited != time.Second || queue.Len() != 11 {
+		t.Fail()
+		t.Logf("%s: waited %v instead of 1s", name, waited)
+	}
+}
+
+func shouldLimitItemsInFlight(t *testing.T, name string) {
+	limit := &conq.ConcurrencyLimit{Max: 2}
+	queue := &conq.Queue{Admission: limit}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	rejected := queue.TryEnqueue(3)
+
+	queue.Dequeue()
+	limit.Release()
+
+	if rejected != conq.ErrNotAdmitted || queue.TryEnqueue(3) != nil || queue.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: did not limit items in flight: %v", name, rejected)
+	}
+}
+
+func shouldAdmitShortBursts(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, TrackAge: true, Admission: &conq.CoDel{}}
+
+	for i := 0; i < 20; i++ {
+		if err := queue.TryEnqueue(i); err != nil {
+			t.Fail()
+			t.Logf("%s: rejected item %d: %v", name, i, err)
+		}
+
+		sim.Sleep(10 * time.Millisecond)
+		queue.Dequeue()
+	}
+}
+
+func shouldRejectWhileSlow(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, TrackAge: true, Admission: &conq.CoDel{}}
+
+	var err error
+	for i := 0; i < 30 && err == nil; i++ {
+		err = queue.TryEnqueue(i)
+		sim.Sleep(10 * time.Millisecond)
+	}
+
+	if err != conq.ErrNotAdmitted {
+		t.Fail()
+		t.Logf("%s: admitted items of a slow queue", name)
+	}
+}
+
+func shouldAdmitOnceCaughtUp(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, TrackAge: true, Admission: &conq.CoDel{}}
+
+	for i := 0; i < 30; i++ {
+		queue.Enqueue(i)
+		sim.Sleep(10 * time.Millisecond)
+	}
+
+	for queue.Dequeue() != nil {
+	}
+
+	queue.Enqueue(0)
+	sim.Sleep(150 * time.Millisecond)
+	queue.Dequeue()
+
+	if err := queue.TryEnqueue(1); err != nil {
+		t.Fail()
+		t.Logf("%s: rejected items after catching up: %v", name, err)
+	}
+}
+
+func shouldPutBackToken(t *testing.T, name string) {
+	queue := &conq.Queue{
+		Admission: &conq.TokenBucket{Burst: 1},
+		Cost:      func(item interface{}) int { return item.(int) },
+		MaxCost:   1,
+	}
+
+	err := queue.TryEnqueue(2)
+
+	if err != conq.ErrCostExceeded || queue.TryEnqueue(1) != nil || queue.TryEnqueue(1) != conq.ErrNotAdmitted {
+		t.Fail()
+		t.Logf("%s: did not put back token of rejected item: %v", name, err)
+	}
+}
+
+func shouldReleaseRejectedSlot(t *testing.T, name string) {
+	limit := &conq.ConcurrencyLimit{Max: 1}
+	closed := &conq.Queue{Admission: limit}
+	closed.Close()
+	group := &conq.Group{MaxLen: 1}
+	full := &conq.Queue{}
+	group.Add(full)
+	full.Enqueue(0)
+	full.Admission = limit
+	queue := &conq.Queue{Admission: limit}
+
+	if closed.TryEnqueue(1) != conq.ErrClosed || full.TryEnqueue(1) != conq.ErrBudgetExceeded || queue.TryEnqueue(1) != nil {
+		t.Fail()
+		t.Logf("%s: did not release slot of rejected item", name)
+	}
+}
+
+func shouldReleaseRemovedSlot(t *testing.T, name string) {
+	queue := &conq.Queue{Admission: &conq.ConcurrencyLimit{Max: 1}}
+	queue.Enqueue("a")
+	queue.Remove("a")
+
+	if err := queue.TryEnqueue("b"); err != nil {
+		t.Fail()
+		t.Logf("%s: did not release slot of removed item: %v", name, err)
+	}
+}
+
+func shouldReleaseCancelledSlot(t *testing.T, name string) {
+	queue := &conq.Queue{Admission: &conq.ConcurrencyLimit{Max: 1}}
+	h, _ := queue.EnqueueHandle("a")
+	h.Cancel()
+
+	if err := queue.TryEnqueue("b"); err != nil {
+		t.Fail()
+		t.Logf("%s: did not release slot of cancelled item: %v", name, err)
+	}
+}
+
+func shouldReleaseTakenSlot(t *testing.T, name string) {
+	queue := &conq.Queue{Admission: &conq.ConcurrencyLimit{Max: 1}}
+	h, _ := queue.EnqueueHandle("a")
+	queue.Take(h)
+
+	if err := queue.TryEnqueue("b"); err != nil {
+		t.Fail()
+		t.Logf("%s: did not release slot of taken item: %v", name, err)
+	}
+}
+
+func shouldReleaseEvictedSlot(t *testing.T, name string) {
+	group := &conq.Group{Evict: conq.EvictOwn, MaxLen: 1}
+	queue := &conq.Queue{Admission: &conq.ConcurrencyLimit{Max: 2}}
+	group.Add(queue)
+	queue.Enqueue("a")
+	queue.Enqueue("b")
+
+	if err := queue.TryEnqueue("c"); err != nil || queue.Dequeue() != "c" {
+		t.Fail()
+		t.Logf("%s: did not release slot of evicted item: %v", name, err)
+	}
+}
+
+func shouldReleaseHandedOffSlot(t *testing.T, name string) {
+	queue := &conq.Queue{Admission: &conq.ConcurrencyLimit{Max: 1}}
+	queue.Enqueue("a")
+
+	if _, err := queue.Handoff(&bytes.Buffer{}); err != nil {
+		t.Fail()
+		t.Logf("%s: did not hand off: %v", name, err)
+	}
+
+	if err := queue.TryEnqueue("b"); err != nil {
+		t.Fail()
+		t.Logf("%s: did not release slot of handed off item: %v", name, err)
+	}
+}
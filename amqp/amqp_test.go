@@ -0,0 +1,220 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package amqp_test
+
+import (
+	"context"
+	"errors"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sebuckler/conq"
+	conqamqp "github.com/sebuckler/conq/amqp"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeChannel struct {
+	deliveries chan amqp091.Delivery
+	fails      int
+	mut        sync.Mutex
+	prefetch   int
+	published  []string
+}
+
+type fakeAcknowledger struct {
+	acked  []uint64
+	nacked []uint64
+}
+
+func (c *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.prefetch = prefetchCount
+
+	return nil
+}
+
+func (c *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp091.Table) (<-chan amqp091.Delivery, error) {
+	return c.deliveries, nil
+}
+
+func (c *fakeChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp091.Publishing) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.fails > 0 {
+		c.fails -= 1
+
+		return errors.New("broker unavailable")
+	}
+
+	c.published = append(c.published, exchange+"/"+key+":"+string(msg.Body))
+
+	return nil
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = append(a.acked, tag)
+
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked = append(a.nacked, tag)
+
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestSource_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should enqueue and ack messages":   shouldEnqueueAckMessages,
+		"should nack messages not enqueued": shouldNackNotEnqueued,
+		"should enqueue decoded messages":   shouldEnqueueDecoded,
+		"should back off before nack":       shouldBackOffBeforeNack,
+		"should stop when queue is closed":  shouldStopWhenClosed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestSink_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should publish items":              shouldPublishItems,
+		"should publish failed item again":  shouldPublishAgain,
+		"should fail to encode other items": shouldFailEncode,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func deliver(acknowledger *fakeAcknowledger, bodies ...string) chan amqp091.Delivery {
+	deliveries := make(chan amqp091.Delivery, len(bodies))
+
+	for i, body := range bodies {
+		deliveries <- amqp091.Delivery{Acknowledger: acknowledger, DeliveryTag: uint64(i + 1), Body: []byte(body)}
+	}
+
+	close(deliveries)
+
+	return deliveries
+}
+
+func shouldEnqueueAckMessages(t *testing.T, name string) {
+	acknowledger := &fakeAcknowledger{}
+	channel := &fakeChannel{deliveries: deliver(acknowledger, "a", "b")}
+	source := &conqamqp.Source{Channel: channel, Queue: "jobs", Prefetch: 10}
+	queue := &conq.Queue{}
+
+	if err := source.Run(context.Background(), queue); err != nil || channel.prefetch != 10 ||
+		!reflect.DeepEqual(queue.Snapshot(), []interface{}{[]byte("a"), []byte("b")}) ||
+		!reflect.DeepEqual(acknowledger.acked, []uint64{1, 2}) {
+		t.Fail()
+		t.Logf("%s: did not enqueue and ack messages: %v", name, err)
+	}
+}
+
+func shouldNackNotEnqueued(t *testing.T, name string) {
+	acknowledger := &fakeAcknowledger{}
+	source := &conqamqp.Source{Backoff: time.Millisecond, Channel: &fakeChannel{deliveries: deliver(acknowledger, "a")}}
+	group := &conq.Group{MaxLen: 1}
+	queue := &conq.Queue{}
+	group.Add(queue)
+	queue.Enqueue("full")
+
+	if err := source.Run(context.Background(), queue); err != nil || queue.Len() != 1 || !reflect.DeepEqual(acknowledger.nacked, []uint64{1}) {
+		t.Fail()
+		t.Logf("%s: did not nack message: %v", name, err)
+	}
+}
+
+func shouldBackOffBeforeNack(t *testing.T, name string) {
+	acknowledger := &fakeAcknowledger{}
+	source := &conqamqp.Source{Backoff: 20 * time.Millisecond, Channel: &fakeChannel{deliveries: deliver(acknowledger, "a", "b")}}
+	queue := &conq.Queue{Cost: func(interface{}) int { return 1 }, MaxCost: 1}
+	queue.Enqueue("full")
+	start := time.Now()
+
+	if err := source.Run(context.Background(), queue); err != nil || time.Since(start) < 40*time.Millisecond ||
+		!reflect.DeepEqual(acknowledger.nacked, []uint64{1, 2}) {
+		t.Fail()
+		t.Logf("%s: did not back off before nacks after %v: %v", name, time.Since(start), err)
+	}
+}
+
+func shouldStopWhenClosed(t *testing.T, name string) {
+	acknowledger := &fakeAcknowledger{}
+	source := &conqamqp.Source{Channel: &fakeChannel{deliveries: deliver(acknowledger, "a", "b")}}
+	queue := &conq.Queue{}
+	queue.Close()
+	start := time.Now()
+
+	if err := source.Run(context.Background(), queue); err != conq.ErrClosed || time.Since(start) > 500*time.Millisecond ||
+		!reflect.DeepEqual(acknowledger.nacked, []uint64{1}) {
+		t.Fail()
+		t.Logf("%s: did not stop on closed queue: %v %v", name, acknowledger.nacked, err)
+	}
+}
+
+func shouldEnqueueDecoded(t *testing.T, name string) {
+	acknowledger := &fakeAcknowledger{}
+	source := &conqamqp.Source{
+		Channel: &fakeChannel{deliveries: deliver(acknowledger, "a")},
+		Decode:  func(delivery amqp091.Delivery) interface{} { return string(delivery.Body) },
+	}
+	queue := &conq.Queue{}
+
+	if source.Run(context.Background(), queue); queue.Dequeue() != "a" {
+		t.Fail()
+		t.Logf("%s: did not enqueue decoded message", name)
+	}
+}
+
+func shouldPublishItems(t *testing.T, name string) {
+	channel := &fakeChannel{}
+	sink := &conqamqp.Sink{Channel: channel, Exchange: "work", Key: "jobs"}
+	queue := &conq.Queue{}
+
+	queue.Enqueue("a")
+	queue.Enqueue([]byte("b"))
+	queue.EnqueuePoison(1)
+
+	if err := sink.Run(context.Background(), queue); err != conq.ErrClosed || !reflect.DeepEqual(channel.published, []string{"work/jobs:a", "work/jobs:b"}) {
+		t.Fail()
+		t.Logf("%s: did not publish items %v: %v", name, channel.published, err)
+	}
+}
+
+func shouldPublishAgain(t *testing.T, name string) {
+	channel := &fakeChannel{fails: 2}
+	sink := &conqamqp.Sink{Backoff: time.Millisecond, Channel: channel}
+	queue := &conq.Queue{}
+
+	queue.Enqueue("a")
+	queue.EnqueuePoison(1)
+
+	if err := sink.Run(context.Background(), queue); err != conq.ErrClosed || !reflect.DeepEqual(channel.published, []string{"/:a"}) {
+		t.Fail()
+		t.Logf("%s: did not publish again %v: %v", name, channel.published, err)
+	}
+}
+
+func shouldFailEncode(t *testing.T, name string) {
+	sink := &conqamqp.Sink{Channel: &fakeChannel{}}
+	queue := &conq.Queue{}
+
+	queue.Enqueue(1)
+
+	if err := sink.Run(context.Background(), queue); err != conqamqp.ErrUnencodable {
+		t.Fail()
+		t.Logf("%s: did not fail to encode: %v", name, err)
+	}
+}
@@ -0,0 +1,9 @@
+module github.com/sebuckler/conq/amqp
+
+go 1.25.0
+
+require github.com/sebuckler/conq v0.0.0
+
+require github.com/rabbitmq/amqp091-go v1.15.0
+
+replace github.com/sebuckler/conq => ../
@@ -0,0 +1,25 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+type byteArena struct {
+	chunk []byte
+}
+
+func (a *byteArena) copy(item interface{}, size int) interface{} {
+	b, ok := item.([]byte)
+	if !ok || len(b) == 0 || len(b) > size/4 {
+		return item
+	}
+
+	if len(a.chunk)+len(b) > cap(a.chunk) {
+		a.chunk = make([]byte, 0, size)
+	}
+
+	start := len(a.chunk)
+	a.chunk = append(a.chunk, b...)
+
+	return a.chunk[start:len(a.chunk):len(a.chunk)]
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"runtime"
+	"testing"
+)
+
+func TestQueue_ByteArena(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should copy small byte items":     shouldCopyArenaItems,
+		"should not copy large byte items": shouldNotCopyLargeItems,
+		"should not copy other items":      shouldNotCopyOtherItems,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func BenchmarkQueue_ByteArena(b *testing.B) {
+	benchmarks := map[string]int{
+		"heap":  0,
+		"arena": 1 << 20,
+	}
+
+	for name, size := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			queue := &conq.Queue{Capacity: 1024, ByteArena: size}
+
+			for i := 0; i < 1000000; i++ {
+				queue.Enqueue(make([]byte, 32))
+			}
+
+			runtime.GC()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+
+			runtime.KeepAlive(queue)
+		})
+	}
+}
+
+func shouldCopyArenaItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, ByteArena: 64}
+	buf := []byte("abc")
+
+	queue.Enqueue(buf)
+	copy(buf, "xyz")
+	queue.Enqueue(buf)
+
+	first := queue.Dequeue().([]byte)
+	second := queue.Dequeue().([]byte)
+	first = append(first, 'd')
+
+	if string(first) != "abcd" || string(second) != "xyz" {
+		t.Fail()
+		t.Logf("%s: did not copy items %q and %q", name, first, second)
+	}
+}
+
+func shouldNotCopyLargeItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, ByteArena: 64}
+	buf := make([]byte, 17)
+
+	queue.Enqueue(buf)
+	buf[0] = 1
+
+	if actual := queue.Dequeue().([]byte); actual[0] != 1 {
+		t.Fail()
+		t.Logf("%s: copied large item", name)
+	}
+}
+
+func shouldNotCopyOtherItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, ByteArena: 64}
+
+	queue.Enqueue("abc")
+
+	if actual := queue.Dequeue(); actual != "abc" {
+		t.Fail()
+		t.Logf("%s: changed item %v", name, actual)
+	}
+}
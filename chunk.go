@@ -0,0 +1,260 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+type chunk struct {
+	items  []interface{}
+	next   *chunk
+	shared bool
+}
+
+func (q *Queue) enqueue(item interface{}) {
+	q.push(item)
+	q.len += 1
+	q.enqueued += 1
+
+	if q.Size != nil {
+		q.bytes += q.sizeOf(untrace(item))
+	}
+
+	if q.Cost != nil {
+		q.cost += q.costOf(untrace(item))
+	}
+
+	if q.mirror != nil {
+		q.mirror.record(mirrorOp{item: untrace(item)})
+	}
+
+	if q.Classify != nil {
+		q.classify(untrace(item), 1)
+	}
+}
+
+func (q *Queue) dequeue() (interface{}, bool) {
+	if q.len == 0 {
+		return nil, false
+	}
+
+	c := q.head
+	val := c.items[q.rx]
+
+	if !c.shared {
+		c.items[q.rx] = nil
+	}
+
+	q.rx += 1
+	q.len -= 1
+	q.dequeued += 1
+	settle(val)
+
+	if smp := sampleOf(val); smp != nil {
+		smp.left(clockOr(q.Clock).Now(), "dequeued")
+	}
+
+	q.unbudget(untrace(val))
+
+	if q.Size != nil {
+		q.bytes -= q.sizeOf(untrace(val))
+	}
+
+	if q.Cost != nil {
+		q.cost -= q.costOf(untrace(val))
+	}
+
+	if q.mirror != nil {
+		q.mirror.record(mirrorOp{dequeue: true})
+	}
+
+	if q.Classify != nil {
+		q.classify(untrace(val), -1)
+	}
+
+	if q.len == 0 {
+		q.head, q.tail = nil, nil
+		q.rx, q.wx = 0, 0
+		q.recycle(c)
+	} else if q.rx == len(c.items) {
+		q.head = c.next
+		q.rx = 0
+		q.recycle(c)
+	}
+
+	return untrace(val), true
+}
+
+func (q *Queue) push(val interface{}) {
+	if q.tail == nil {
+		q.head = q.newChunk()
+		q.tail = q.head
+		q.rx, q.wx = 0, 0
+	} else if q.wx == len(q.tail.items) {
+		if q.tail.next == nil {
+			q.tail.next = q.newChunk()
+		}
+
+		q.tail = q.tail.next
+		q.wx = 0
+	}
+
+	q.tail.items[q.wx] = val
+	q.wx += 1
+}
+
+func (q *Queue) index(pred func(interface{}) bool) int {
+	i := 0
+
+	for c, start := q.head, q.rx; c != nil; c, start = c.next, 0 {
+		for _, val := range c.items[start:q.end(c)] {
+			if pred(untrace(val)) {
+				return i
+			}
+
+			i += 1
+		}
+	}
+
+	return -1
+}
+
+func (q *Queue) filter(keep func(interface{}) bool) int {
+	if q.len == 0 {
+		return 0
+	}
+
+	head, rx, tail, wx := q.head, q.rx, q.tail, q.wx
+	removed, i := 0, 0
+	var indexes []int
+
+	if q.isShared() {
+		q.head, q.tail = nil, nil
+	} else {
+		q.tail, q.wx = head, rx
+	}
+
+	for c, start := head, rx; c != nil; c, start = c.next, 0 {
+		end := len(c.items)
+		if c == tail {
+			end = wx
+		}
+
+		for _, val := range c.items[start:end] {
+			if keep(val) {
+				q.push(val)
+			} else {
+				q.drop(val)
+				removed += 1
+
+				if q.mirror != nil {
+					indexes = append(indexes, i)
+				}
+			}
+
+			i += 1
+		}
+
+		if c == tail {
+			break
+		}
+	}
+
+	q.len -= removed
+	q.removed += uint64(removed)
+
+	if indexes != nil {
+		q.mirror.record(mirrorOp{removed: indexes})
+	}
+
+	if q.tail != nil {
+		for i := q.wx; i < len(q.tail.items); i++ {
+			q.tail.items[i] = nil
+		}
+
+		q.tail.next = nil
+	}
+
+	if q.len == 0 {
+		c := q.head
+		q.head, q.tail = nil, nil
+		q.rx, q.wx = 0, 0
+		q.recycle(c)
+	}
+
+	return removed
+}
+
+func (q *Queue) share() [][]interface{} {
+	q.lock()
+	defer q.unlock()
+
+	var segments [][]interface{}
+
+	for c, start := q.head, q.rx; c != nil; c, start = c.next, 0 {
+		end := q.end(c)
+		segments = append(segments, c.items[start:end:end])
+		c.shared = true
+	}
+
+	return segments
+}
+
+func (q *Queue) isShared() bool {
+	for c := q.head; c != nil; c = c.next {
+		if c.shared {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (q *Queue) end(c *chunk) int {
+	if c == q.tail {
+		return q.wx
+	}
+
+	return len(c.items)
+}
+
+func (q *Queue) chunkSize() int {
+	size := q.Growth.Step
+	if size <= 0 {
+		size = q.len
+	}
+
+	if size < q.Capacity {
+		size = q.Capacity
+	}
+
+	if q.Growth.Max > 0 && size > q.Growth.Max {
+		size = q.Growth.Max
+	}
+
+	if size < 1 {
+		size = 1
+	}
+
+	return size
+}
+
+func (q *Queue) newChunk() *chunk {
+	size := q.chunkSize()
+
+	if c := q.spare; c != nil && len(c.items) == size {
+		q.spare = nil
+
+		return c
+	}
+
+	return &chunk{items: make([]interface{}, size)}
+}
+
+func (q *Queue) recycle(c *chunk) {
+	if c == nil || c.shared || len(c.items) > q.Capacity && len(c.items) > 1 {
+		return
+	}
+
+	c.next = nil
+	q.spare = c
+}
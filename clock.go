@@ -0,0 +1,263 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+ErrSimLimit is returned by Sim.Run when the simulation would advance past its
+Limit.
+*/
+var ErrSimLimit = errors.New("conq: simulation limit exceeded")
+
+/*
+Clock tells the time and sleeps for the queues. Queues use the system clock by
+default, and a Sim can be their Clock to control time in tests.
+*/
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+/*
+Sim is a deterministic simulation of time and goroutines, for reproducible
+tests of blocking behavior. Tasks started with Go run one at a time, and a task
+runs until it sleeps or returns. Run then picks the next task to run at random
+from the tasks that are ready, with a source seeded by Seed, so the same seed
+always gives the same interleaving. When every task is sleeping, the virtual
+time of the Sim jumps to the earliest wake time, so timeouts of hours take no
+real time.
+
+Sim is a Clock, so it can be the Clock of the queues under test. The polls of
+DequeueBlocking sleep on the Clock, and sleeps shorter than Tick are rounded up
+to Tick, so polls without an interval still let other tasks run and advance the
+virtual time. A poll of a Queue, DelayQueue, DeadlineQueue, WeightedQueue, or
+Merge also wakes as soon as another task enqueues an item or interrupts the
+queue, like it does with the system clock. A Processor and a Window wait for
+items and measure time on the Clock of the queue they dequeue from, and Replay
+waits on the Clock of the queue it enqueues into. Tasks must not block in other
+ways, like on channels or the waits of WaitLen, as Run cannot switch tasks while
+one is blocked. Sleeping outside of a task advances the virtual time without
+switching tasks.
+*/
+type Sim struct {
+	Limit    time.Duration // most virtual time Run advances, unlimited when 0
+	Seed     int64         // seed of the choices of which task runs next
+	Start    time.Time     // virtual time when the simulation starts, defaults to the Unix epoch
+	Tick     time.Duration // shortest sleep of a task, defaults to 1ms
+	current  *simTask
+	mut      sync.Mutex
+	now      time.Time
+	rand     *rand.Rand
+	runnable []*simTask
+	sleeping []*simTask
+	started  bool
+	yield    chan struct{}
+}
+
+type simTask struct {
+	resume  chan struct{}
+	signals []<-chan struct{}
+	wake    time.Time
+}
+
+type systemClock struct{}
+
+/*
+Go starts a task that runs the func once Run picks it. Go can be called before
+Run or by a running task.
+*/
+func (s *Sim) Go(f func()) {
+	s.mut.Lock()
+	s.init()
+
+	t := &simTask{resume: make(chan struct{})}
+	s.runnable = append(s.runnable, t)
+	s.mut.Unlock()
+
+	go func() {
+		<-t.resume
+		f()
+
+		s.mut.Lock()
+		s.current = nil
+		s.mut.Unlock()
+
+		s.yield <- struct{}{}
+	}()
+}
+
+/*
+Run runs the tasks until every task has returned, and returns nil. If the
+virtual time would advance more than Limit past the time when Run was called,
+Run returns ErrSimLimit and the tasks that are left never run again.
+*/
+func (s *Sim) Run() error {
+	s.mut.Lock()
+	s.init()
+	start := s.now
+
+	for {
+		s.wake()
+
+		if len(s.runnable) == 0 {
+			if len(s.sleeping) == 0 {
+				s.mut.Unlock()
+
+				return nil
+			}
+
+			next := s.sleeping[0].wake
+			for _, t := range s.sleeping[1:] {
+				if t.wake.Before(next) {
+					next = t.wake
+				}
+			}
+
+			if s.Limit > 0 && next.Sub(start) > s.Limit {
+				s.mut.Unlock()
+
+				return ErrSimLimit
+			}
+
+			s.now = next
+
+			continue
+		}
+
+		i := s.rand.Intn(len(s.runnable))
+		t := s.runnable[i]
+		s.runnable = append(s.runnable[:i], s.runnable[i+1:]...)
+		s.current = t
+		s.mut.Unlock()
+
+		t.resume <- struct{}{}
+		<-s.yield
+
+		s.mut.Lock()
+	}
+}
+
+/*
+Now returns the virtual time of the simulation.
+*/
+func (s *Sim) Now() time.Time {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.init()
+
+	return s.now
+}
+
+/*
+Sleep pauses the running task until the virtual time has advanced by the
+duration, and lets other tasks run. Sleeps shorter than Tick last one Tick.
+Outside of a task, Sleep advances the virtual time by the duration and returns.
+*/
+func (s *Sim) Sleep(d time.Duration) {
+	s.sleepOn(d)
+}
+
+func (s *Sim) sleepOn(d time.Duration, signals ...<-chan struct{}) {
+	s.mut.Lock()
+	s.init()
+
+	t := s.current
+	if t == nil {
+		if d > 0 {
+			s.now = s.now.Add(d)
+		}
+
+		s.mut.Unlock()
+
+		return
+	}
+
+	if tick := s.tick(); d < tick {
+		d = tick
+	}
+
+	t.wake = s.now.Add(d)
+	t.signals = signals
+	s.sleeping = append(s.sleeping, t)
+	s.current = nil
+	s.mut.Unlock()
+
+	s.yield <- struct{}{}
+	<-t.resume
+}
+
+func (s *Sim) init() {
+	if s.started {
+		return
+	}
+
+	s.now = s.Start
+	if s.now.IsZero() {
+		s.now = time.Unix(0, 0)
+	}
+
+	s.rand = rand.New(rand.NewSource(s.Seed))
+	s.yield = make(chan struct{})
+	s.started = true
+}
+
+func (s *Sim) tick() time.Duration {
+	if s.Tick <= 0 {
+		return time.Millisecond
+	}
+
+	return s.Tick
+}
+
+func (s *Sim) wake() {
+	sleeping := s.sleeping[:0]
+
+	for _, t := range s.sleeping {
+		if t.wake.After(s.now) && !signaled(t.signals) {
+			sleeping = append(sleeping, t)
+		} else {
+			t.signals = nil
+			s.runnable = append(s.runnable, t)
+		}
+	}
+
+	s.sleeping = sleeping
+}
+
+func signaled(signals []<-chan struct{}) bool {
+	for _, signal := range signals {
+		select {
+		case <-signal:
+			return true
+		default:
+			break
+		}
+	}
+
+	return false
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return systemClock{}
+	}
+
+	return c
+}
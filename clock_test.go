@@ -0,0 +1,209 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSim_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should repeat interleaving of seed": shouldRepeatInterleaving,
+		"should skip virtual time":           shouldSkipVirtualTime,
+		"should stop at limit":               shouldStopAtLimit,
+		"should run nested tasks":            shouldRunNestedTasks,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestSim_Sleep(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should advance outside task": shouldAdvanceOutsideTask,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestSim_Queue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should dequeue blocking in sim":   shouldDequeueBlockingInSim,
+		"should time out in sim":           shouldTimeOutInSim,
+		"should release delayed item":      shouldReleaseDelayedInSim,
+		"should track age in virtual time": shouldTrackAgeInSim,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func interleave(seed int64) []int {
+	sim := &conq.Sim{Seed: seed}
+
+	var order []int
+	for i := 0; i < 3; i++ {
+		i := i
+		sim.Go(func() {
+			for j := 0; j < 5; j++ {
+				order = append(order, i)
+				sim.Sleep(0)
+			}
+		})
+	}
+
+	sim.Run()
+
+	return order
+}
+
+func shouldRepeatInterleaving(t *testing.T, name string) {
+	first, again, other := interleave(1), interleave(1), interleave(2)
+
+	if len(first) != 15 || !reflect.DeepEqual(first, again) || reflect.DeepEqual(first, other) {
+		t.Fail()
+		t.Logf("%s: did not repeat interleaving %v %v %v", name, first, again, other)
+	}
+}
+
+func shouldSkipVirtualTime(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	start := sim.Now()
+
+	var woke []time.Duration
+	for _, d := range []time.Duration{time.Hour, time.Minute} {
+		d := d
+		sim.Go(func() {
+			sim.Sleep(d)
+			woke = append(woke, sim.Now().Sub(start))
+		})
+	}
+
+	began := time.Now()
+	err := sim.Run()
+
+	if err != nil || !reflect.DeepEqual(woke, []time.Duration{time.Minute, time.Hour}) || time.Since(began) > time.Second {
+		t.Fail()
+		t.Logf("%s: did not skip virtual time %v: %v", name, woke, err)
+	}
+}
+
+func shouldStopAtLimit(t *testing.T, name string) {
+	sim := &conq.Sim{Limit: 10 * time.Second}
+	start := sim.Now()
+
+	sim.Go(func() {
+		for {
+			sim.Sleep(time.Second)
+		}
+	})
+
+	if err := sim.Run(); err != conq.ErrSimLimit || sim.Now().Sub(start) != 10*time.Second {
+		t.Fail()
+		t.Logf("%s: did not stop at limit: %v", name, err)
+	}
+}
+
+func shouldRunNestedTasks(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	ran := 0
+
+	sim.Go(func() {
+		sim.Go(func() {
+			ran += 1
+		})
+
+		ran += 1
+	})
+
+	if err := sim.Run(); err != nil || ran != 2 {
+		t.Fail()
+		t.Logf("%s: did not run nested tasks: %v", name, err)
+	}
+}
+
+func shouldAdvanceOutsideTask(t *testing.T, name string) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sim := &conq.Sim{Start: start}
+
+	sim.Sleep(time.Minute)
+
+	if !sim.Now().Equal(start.Add(time.Minute)) {
+		t.Fail()
+		t.Logf("%s: did not advance to %v", name, sim.Now())
+	}
+}
+
+func shouldDequeueBlockingInSim(t *testing.T, name string) {
+	sim := &conq.Sim{Seed: 42}
+	queue := &conq.Queue{Clock: sim}
+
+	var got interface{}
+	sim.Go(func() {
+		got = queue.DequeueBlocking(10*time.Second, 100*time.Millisecond)
+	})
+	sim.Go(func() {
+		sim.Sleep(5 * time.Second)
+		queue.Enqueue(1)
+	})
+
+	err := sim.Run()
+
+	wait := queue.Stats().DequeueWait
+	if err != nil || got != 1 || wait.Sum < 5*time.Second || wait.Sum > 5100*time.Millisecond {
+		t.Fail()
+		t.Logf("%s: did not dequeue blocking in sim %v after %v: %v", name, got, wait.Sum, err)
+	}
+}
+
+func shouldTimeOutInSim(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	start := sim.Now()
+
+	got := interface{}(1)
+	sim.Go(func() {
+		got = queue.DequeueBlocking(time.Second, 0)
+	})
+
+	if err := sim.Run(); err != nil || got != nil || sim.Now().Sub(start) != time.Second {
+		t.Fail()
+		t.Logf("%s: did not time out in sim at %v: %v", name, sim.Now().Sub(start), err)
+	}
+}
+
+func shouldReleaseDelayedInSim(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.DelayQueue{Clock: sim}
+
+	queue.Enqueue(1, time.Minute)
+	early := queue.Dequeue()
+	sim.Sleep(time.Minute)
+
+	if early != nil || queue.Dequeue() != 1 {
+		t.Fail()
+		t.Logf("%s: did not release delayed item in virtual time", name)
+	}
+}
+
+func shouldTrackAgeInSim(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, TrackAge: true}
+
+	queue.Enqueue(1)
+	sim.Sleep(time.Hour)
+
+	if oldest := queue.Stats().Oldest; oldest != time.Hour {
+		t.Fail()
+		t.Logf("%s: did not track age in virtual time %v", name, oldest)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var evictionNames = [...]string{"reject-new", "evict-own", "evict-longest", "evict-largest"}
+
+/*
+Config has the options of a queue in a form that can be loaded from the
+environment or a JSON file, so deployments can tune queues without
+recompiling. Queue creates a queue with the options. MaxLen and MaxBytes put
+the queue in a Group of its own with those budgets, and Evict is the eviction
+of that group, like "evict-own".
+*/
+type Config struct {
+	ByteArena    int      `json:"byte_arena"`     // bytes per arena for small []byte items, disabled when 0
+	Capacity     int      `json:"capacity"`       // soft cap for items in each chunk of the queue
+	Evict        Eviction `json:"evict"`          // which items are evicted when the queue is full
+	GrowthMax    int      `json:"growth_max"`     // most items per chunk, unlimited when 0
+	GrowthStep   int      `json:"growth_step"`    // items per new chunk, or doubles when 0
+	MaxBytes     int      `json:"max_bytes"`      // most bytes in the queue, unlimited when 0
+	MaxCost      int      `json:"max_cost"`       // most cost of the items in the queue, unlimited when 0
+	MaxHeapBytes uint64   `json:"max_heap_bytes"` // rejects enqueues while the heap is larger, disabled when 0
+	MaxLen       int      `json:"max_len"`        // most items in the queue, unlimited when 0
+	Trace        bool     `json:"trace"`          // annotates execution traces when true
+	TrackAge     bool     `json:"track_age"`      // records when items are enqueued for the Oldest stat when true
+}
+
+/*
+FromEnv loads a Config from environment variables named after the JSON names of
+its fields in upper case with the prefix, like CONQ_CAPACITY for the prefix
+"CONQ_". Options without a variable are 0 or false. It returns an error if a
+variable cannot be parsed or the Config is not valid.
+*/
+func FromEnv(prefix string) (Config, error) {
+	var c Config
+	v := reflect.ValueOf(&c).Elem()
+
+	for i := 0; i < v.NumField(); i++ {
+		name := prefix + strings.ToUpper(v.Type().Field(i).Tag.Get("json"))
+
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if err := setField(v.Field(i), s); err != nil {
+			return Config{}, fmt.Errorf("conq: invalid %s: %v", name, err)
+		}
+	}
+
+	return c, c.Validate()
+}
+
+/*
+FromJSON loads a Config from a JSON object with the JSON names of its fields,
+like {"capacity": 128}. Options that are missing are 0 or false. It returns an
+error if the JSON has unknown options, cannot be decoded, or the Config is not
+valid.
+*/
+func FromJSON(r io.Reader) (Config, error) {
+	var c Config
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&c); err != nil {
+		return Config{}, err
+	}
+
+	return c, c.Validate()
+}
+
+/*
+Validate returns an error that names the first option that is not valid, or
+nil if every option is valid.
+*/
+func (c Config) Validate() error {
+	switch {
+	case c.ByteArena < 0:
+		return errors.New("conq: byte_arena must not be negative")
+	case c.Capacity < 0:
+		return errors.New("conq: capacity must not be negative")
+	case c.Evict < RejectNew || c.Evict > EvictLargest:
+		return errors.New("conq: evict is not an eviction")
+	case c.GrowthMax < 0:
+		return errors.New("conq: growth_max must not be negative")
+	case c.GrowthStep < 0:
+		return errors.New("conq: growth_step must not be negative")
+	case c.GrowthMax > 0 && c.GrowthStep > c.GrowthMax:
+		return errors.New("conq: growth_step must not be larger than growth_max")
+	case c.MaxBytes < 0:
+		return errors.New("conq: max_bytes must not be negative")
+	case c.MaxCost < 0:
+		return errors.New("conq: max_cost must not be negative")
+	case c.MaxLen < 0:
+		return errors.New("conq: max_len must not be negative")
+	default:
+		return nil
+	}
+}
+
+/*
+Queue creates a queue with the options of the Config.
+*/
+func (c Config) Queue() *Queue {
+	q := &Queue{
+		ByteArena:    c.ByteArena,
+		Capacity:     c.Capacity,
+		Growth:       Growth{Step: c.GrowthStep, Max: c.GrowthMax},
+		MaxCost:      c.MaxCost,
+		MaxHeapBytes: c.MaxHeapBytes,
+		Trace:        c.Trace,
+		TrackAge:     c.TrackAge,
+	}
+
+	if c.MaxLen > 0 || c.MaxBytes > 0 {
+		g := &Group{Evict: c.Evict, MaxBytes: c.MaxBytes, MaxLen: c.MaxLen}
+		g.Add(q)
+	}
+
+	return q
+}
+
+/*
+ApplyConfig changes the options of the queue that can be tuned while it is used,
+for tuning during incidents without recreating the queue. Capacity and Growth
+apply to the chunks that are added from then on, and MaxCost and MaxHeapBytes to
+the next Enqueue. MaxLen, MaxBytes, and Evict change the budget of the Group of
+the queue, which is shared with the other queues of the group. If the queue is
+not in a group and the Config has a budget, the queue is put in a Group of its
+own with the items it already has. Lowering a budget below the items in the
+queues does not evict them, but Enqueue evicts or rejects items until they fit.
+
+ByteArena, Trace, and TrackAge cannot be changed once the queue is used. If the
+Config is not valid or changes one of them, ApplyConfig returns an error and
+changes nothing. ApplyConfig locks the queue.
+*/
+func (q *Queue) ApplyConfig(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	q.lock()
+	defer q.unlock()
+
+	switch {
+	case cfg.ByteArena != q.ByteArena:
+		return errors.New("conq: byte_arena cannot be changed")
+	case cfg.Trace != q.Trace:
+		return errors.New("conq: trace cannot be changed")
+	case cfg.TrackAge != q.TrackAge:
+		return errors.New("conq: track_age cannot be changed")
+	}
+
+	q.Capacity = cfg.Capacity
+	q.Growth = Growth{Step: cfg.GrowthStep, Max: cfg.GrowthMax}
+	q.MaxCost = cfg.MaxCost
+	q.MaxHeapBytes = cfg.MaxHeapBytes
+
+	if q.group == nil && (cfg.MaxLen > 0 || cfg.MaxBytes > 0) {
+		g := &Group{queues: []*Queue{q}}
+
+		for c, start := q.head, q.rx; c != nil; c, start = c.next, 0 {
+			for _, val := range c.items[start:q.end(c)] {
+				if item := untrace(val); item != ErrClosed {
+					size := g.size(item)
+					g.len += 1
+					g.bytes += size
+					q.groupBytes += size
+				}
+			}
+		}
+
+		q.group = g
+	}
+
+	if q.group != nil {
+		q.group.mut.Lock()
+		q.group.Evict = cfg.Evict
+		q.group.MaxBytes = cfg.MaxBytes
+		q.group.MaxLen = cfg.MaxLen
+		q.group.mut.Unlock()
+	}
+
+	return nil
+}
+
+/*
+String returns the name of the eviction, like "evict-own".
+*/
+func (e Eviction) String() string {
+	if e < RejectNew || e > EvictLargest {
+		return "Eviction(" + strconv.Itoa(int(e)) + ")"
+	}
+
+	return evictionNames[e]
+}
+
+/*
+MarshalText encodes the eviction as its name.
+*/
+func (e Eviction) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+/*
+UnmarshalText decodes an eviction from its name.
+*/
+func (e *Eviction) UnmarshalText(text []byte) error {
+	for i, name := range evictionNames {
+		if string(text) == name {
+			*e = Eviction(i)
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("conq: unknown eviction %q", text)
+}
+
+func setField(f reflect.Value, s string) error {
+	if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch f.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		f.SetBool(b)
+
+		return err
+	case reflect.Int:
+		n, err := strconv.ParseInt(s, 10, 0)
+		f.SetInt(n)
+
+		return err
+	default:
+		n, err := strconv.ParseUint(s, 10, 64)
+		f.SetUint(n)
+
+		return err
+	}
+}
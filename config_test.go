@@ -0,0 +1,236 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"encoding/json"
+	"github.com/sebuckler/conq"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFromEnv(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should load options from env":    shouldLoadFromEnv,
+		"should fail on unparsable value": shouldFailOnUnparsable,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should load options from json": shouldLoadFromJSON,
+		"should fail on unknown option": shouldFailOnUnknownOption,
+		"should fail on invalid config": shouldFailOnInvalidConfig,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestConfig_Queue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should create queue with budget": shouldCreateQueueWithBudget,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_ApplyConfig(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should apply tunable options":  shouldApplyTunable,
+		"should add budget to queue":    shouldAddBudget,
+		"should reject fixed options":   shouldRejectFixedOptions,
+		"should keep items over budget": shouldKeepItemsOverBudget,
+		"should add budget while used":  shouldAddBudgetWhileUsed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func setEnv(vars map[string]string) func() {
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+
+	return func() {
+		for k := range vars {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func shouldLoadFromEnv(t *testing.T, name string) {
+	defer setEnv(map[string]string{
+		"TEST_CONQ_CAPACITY":       "128",
+		"TEST_CONQ_EVICT":          "evict-longest",
+		"TEST_CONQ_MAX_HEAP_BYTES": "1073741824",
+		"TEST_CONQ_TRACK_AGE":      "true",
+	})()
+
+	c, err := conq.FromEnv("TEST_CONQ_")
+
+	want := conq.Config{Capacity: 128, Evict: conq.EvictLongest, MaxHeapBytes: 1 << 30, TrackAge: true}
+	if err != nil || c != want {
+		t.Fail()
+		t.Logf("%s: did not load options %+v: %v", name, c, err)
+	}
+}
+
+func shouldFailOnUnparsable(t *testing.T, name string) {
+	defer setEnv(map[string]string{"TEST_CONQ_MAX_LEN": "lots"})()
+
+	if _, err := conq.FromEnv("TEST_CONQ_"); err == nil || !strings.Contains(err.Error(), "TEST_CONQ_MAX_LEN") {
+		t.Fail()
+		t.Logf("%s: did not fail on unparsable value: %v", name, err)
+	}
+}
+
+func shouldLoadFromJSON(t *testing.T, name string) {
+	c, err := conq.FromJSON(strings.NewReader(`{"capacity": 64, "growth_step": 256, "max_len": 1000, "evict": "evict-own"}`))
+
+	want := conq.Config{Capacity: 64, Evict: conq.EvictOwn, GrowthStep: 256, MaxLen: 1000}
+	if err != nil || c != want {
+		t.Fail()
+		t.Logf("%s: did not load options %+v: %v", name, c, err)
+	}
+
+	if data, _ := json.Marshal(want); !strings.Contains(string(data), `"evict":"evict-own"`) {
+		t.Fail()
+		t.Logf("%s: did not encode eviction name %s", name, data)
+	}
+}
+
+func shouldFailOnUnknownOption(t *testing.T, name string) {
+	if _, err := conq.FromJSON(strings.NewReader(`{"capacty": 64}`)); err == nil {
+		t.Fail()
+		t.Logf("%s: did not fail on unknown option", name)
+	}
+
+	if _, err := conq.FromJSON(strings.NewReader(`{"evict": "evict-all"}`)); err == nil {
+		t.Fail()
+		t.Logf("%s: did not fail on unknown eviction", name)
+	}
+}
+
+func shouldFailOnInvalidConfig(t *testing.T, name string) {
+	_, err := conq.FromJSON(strings.NewReader(`{"growth_step": 100, "growth_max": 10}`))
+
+	if err == nil || err.Error() != "conq: growth_step must not be larger than growth_max" {
+		t.Fail()
+		t.Logf("%s: did not fail on invalid config: %v", name, err)
+	}
+}
+
+func shouldCreateQueueWithBudget(t *testing.T, name string) {
+	queue := conq.Config{Capacity: 2, Evict: conq.EvictOwn, MaxLen: 2, TrackAge: true}.Queue()
+
+	for i := 0; i < 3; i++ {
+		queue.Enqueue(i)
+	}
+
+	if queue.Len() != 2 || queue.Dequeue() != 1 || !queue.TrackAge || queue.Capacity != 2 {
+		t.Fail()
+		t.Logf("%s: did not create queue with budget", name)
+	}
+}
+
+func shouldApplyTunable(t *testing.T, name string) {
+	queue := conq.Config{MaxLen: 2}.Queue()
+
+	err := queue.ApplyConfig(conq.Config{Capacity: 8, Evict: conq.EvictOwn, GrowthStep: 16, MaxLen: 3})
+
+	for i := 0; i < 4; i++ {
+		queue.Enqueue(i)
+	}
+
+	if err != nil || queue.Capacity != 8 || queue.Growth.Step != 16 || queue.Len() != 3 || queue.Dequeue() != 1 {
+		t.Fail()
+		t.Logf("%s: did not apply tunable options: %v", name, err)
+	}
+}
+
+func shouldAddBudget(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue("ab")
+	queue.Enqueue("cd")
+
+	err := queue.ApplyConfig(conq.Config{MaxBytes: 5})
+
+	if err != nil || queue.TryEnqueue("ef") != conq.ErrBudgetExceeded || queue.Dequeue() != "ab" || queue.TryEnqueue("ef") != nil {
+		t.Fail()
+		t.Logf("%s: did not add budget with queued items: %v", name, err)
+	}
+}
+
+func shouldRejectFixedOptions(t *testing.T, name string) {
+	queue := &conq.Queue{}
+
+	err := queue.ApplyConfig(conq.Config{Capacity: 8, TrackAge: true})
+
+	if err == nil || err.Error() != "conq: track_age cannot be changed" || queue.Capacity != 0 {
+		t.Fail()
+		t.Logf("%s: did not reject fixed option: %v", name, err)
+	}
+
+	if err := queue.ApplyConfig(conq.Config{Capacity: -1}); err == nil {
+		t.Fail()
+		t.Logf("%s: did not reject invalid config", name)
+	}
+}
+
+func shouldKeepItemsOverBudget(t *testing.T, name string) {
+	queue := conq.Config{MaxLen: 3}.Queue()
+
+	for i := 0; i < 3; i++ {
+		queue.Enqueue(i)
+	}
+
+	err := queue.ApplyConfig(conq.Config{MaxLen: 1})
+
+	if err != nil || queue.Len() != 3 || queue.TryEnqueue(3) != conq.ErrBudgetExceeded {
+		t.Fail()
+		t.Logf("%s: did not keep items over budget: %v", name, err)
+	}
+}
+
+func shouldAddBudgetWhileUsed(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	var wg sync.WaitGroup
+
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 1000; i++ {
+				queue.Enqueue("ab")
+				queue.Dequeue()
+			}
+		}()
+	}
+
+	queue.ApplyConfig(conq.Config{MaxLen: 1})
+	wg.Wait()
+
+	for queue.Dequeue() != nil {
+	}
+
+	if err := queue.TryEnqueue("ab"); err != nil || queue.TryEnqueue("cd") != conq.ErrBudgetExceeded {
+		t.Fail()
+		t.Logf("%s: did not count items enqueued while budget was added: %v", name, err)
+	}
+}
@@ -8,9 +8,9 @@ Package conq is for using a performant and thread-safe queue.
 Basic Operations
 
 Create a queue with a given capacity. The capacity is a soft cap of items that
-the queue can hold. The capacity works just like it does for creating slices.
-The items are internally stored in a slice of slices. One slice is for
-enqueuing new items, and the other slice is for dequeuing items.
+each chunk of the queue can hold. The items are internally stored in a linked
+list of fixed size chunks, so the queue never copies items to grow, and memory
+is freed one chunk at a time as items are dequeued.
 This implementation can be faster than channels and linked lists, but it does
 not cover the same use-cases as channels. Use a queue for scheduling and
 batching ordered work. Use channels when separate goroutines need to
@@ -22,6 +22,19 @@ interval to manage the poll cycles.
 
 The length of the queue can be retrieved at any point in O(1) time.
 
+Memory Model
+
+Enqueuing an item happens before dequeuing it, in the sense of the Go memory
+model. Every queue of the package locks its state while items are enqueued and
+dequeued, so writes that a producer makes to an item, or to the memory it
+points to, before the item is enqueued are visible to the consumer that
+dequeues it, without more synchronization. This holds for Queue, DelayQueue,
+DeadlineQueue, and WeightedQueue, and for every method that returns items,
+like Dequeue, DequeueBlocking, Remove, and Snapshot. Writes made to an item
+after it is enqueued are not synchronized with the consumer. A queue with a
+NopLocker gives no guarantee, and a custom Locker must be a real lock to give
+it.
+
 Example code:
 
 	package main
@@ -50,53 +63,231 @@ Example code:
 package conq
 
 import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+/*
+ErrClosed is dequeued in place of an item when a consumer reaches a poison item
+added by EnqueuePoison. It signals that the consumer should stop. It is also
+returned by TryEnqueue when the queue was closed with Close.
+*/
+var ErrClosed = errors.New("conq: queue closed")
+
+/*
+Growth decides the size of the chunks that are added to a queue when its last
+chunk is full, so the memory used by a queue during bursts is predictable. New
+chunks have Step items, or as many items as are already enqueued when Step is
+0, which doubles the capacity of the queue. Chunks are never smaller than the
+Capacity of the queue, and they never have more than Max items unless Max is 0.
+*/
+type Growth struct {
+	Step int // items per new chunk, or doubles when 0
+	Max  int // most items per chunk, unlimited when 0
+}
+
+/*
+Interface is a FIFO queue of items of any type, like Queue. Code that only
+enqueues and dequeues can accept an Interface, so other implementations, like
+wrappers or lock-free queues, can be used instead of a Queue. The conqtest
+package checks that an implementation behaves like a FIFO queue.
+*/
+type Interface interface {
+	Dequeue() interface{}
+	Len() int
+	TryEnqueue(item interface{}) error
+}
+
 /*
 Queue is an abstract data structure for adding and retrieving a sequence of
-items in FIFO order. The items are internally stored in a slice of slices. One
-slice is for enqueuing new items, and the other slice is for dequeuing items.
+items in FIFO order. The items are internally stored in a linked list of fixed
+size chunks. Items are enqueued into the last chunk and dequeued from the first
+chunk, so enqueuing and dequeuing never copy items or reallocate a large slice,
+and each chunk is freed as soon as all of its items are dequeued. The first
+chunk has Capacity items, and more chunks are added as decided by Growth.
+
+When a DequeueBlocking call, Dispatcher, Processor, Window, or Reader is already
+waiting on an empty queue, Enqueue hands the item directly to the waiting
+consumer without storing it in a chunk, which cuts the latency and allocations
+of queues that are usually empty. The item still counts as enqueued and dequeued
+in the Stats. Poison items, items with a Handle, and the items of queues with
+Trace, a Sampler, a Group, or a Mirror are always stored.
+
+Equal is used to compare items when they are removed. Items of any type can be
+enqueued, and many types cannot be compared with ==, so Equal can compare them
+by value or by a key. If Equal is nil, items are compared with ==, and items of
+types that cannot be compared with == are never equal.
+
+Trace opts in to runtime/trace annotations while an execution trace is being
+recorded. Each item gets a task that spans from when it is enqueued until it is
+dequeued or removed, and DequeueBlocking waits for items in a region, so queue
+latency shows up in go tool trace. Trace must be set before the queue is used.
+
+Classify opts in to counting the items in the queue by class, so queues of mixed
+items can report what kind of work dominates. The counts are in the Classes of
+the queue Stats. Use TypeName to count items by type, or any other function to
+count items by a key of the item. Classify must be set before the queue is used.
+
+Locker replaces the sync.Mutex that locks the queue. If the Locker also has
+RLock and RUnlock methods, like a sync.RWMutex, the methods that only read the
+queue take a read lock, so inspecting the queue does not stall other readers.
+A NopLocker removes the cost of locking for a queue that is only used by one
+goroutine. Locker must be set before the queue is used.
+
+MaxHeapBytes is a last resort guard against a queue growing until the process
+runs out of memory. While the heap of the process is larger than MaxHeapBytes,
+TryEnqueue returns ErrMemoryLimit instead of enqueuing items. The heap size is
+read from runtime/metrics at most once every 10ms, so the limit is soft.
+
+Cost opts in to admission by the weight of the work of items, like a CPU
+estimate or credits, instead of their count. While the items in the queue cost
+MaxCost, TryEnqueue returns ErrCostExceeded for items that would cost more. Cost
+must always return the same cost for the same item, and it must be set before
+the queue is used.
+
+TrackAge opts in to recording when each item is enqueued, so the Oldest stat of
+the queue reports how long the item at the head has been waiting, and the
+QueueTime histogram how long dequeued items were waiting. TrackAge must be set
+before the queue is used.
+
+Clock replaces the system clock that DequeueBlocking and TrackAge tell time and
+sleep with, so a Sim can run tests of blocking behavior in virtual time. Clock
+must be set before the queue is used.
+
+Admission decides whether items are enqueued, so overload is handled by a
+policy instead of by each producer. A TokenBucket slows producers down to a
+rate, a ConcurrencyLimit limits the items in flight, and CoDel rejects items
+while the queue is persistently slow. Admission must be set before the queue is
+used.
+
+Labels are static labels of the queue, like the service, tenant, or purpose of
+the queue, that StatsD and the otel package add to every metric of the queue, so
+the telemetry of many queues can be filtered without wrapper code. Labels must
+be set before the queue is used.
+
+Size opts in to counting the bytes of the items in the queue, so the Bytes stat
+reports them and TopK can find the largest items. Size must always return the
+same size for the same item, and it must be set before the queue is used.
+
+Sampler opts in to tracing every Nth item from when it is enqueued until it is
+dequeued, and until a Processor or Dispatcher of the queue has handled it, for
+latency investigations. The traces are kept by the Sampler.
+
+ByteArena reduces the work of the garbage collector for queues of millions of
+small []byte items. When it is set, []byte items of up to a quarter of
+ByteArena bytes are copied into shared arenas of ByteArena bytes, instead of
+each item being its own allocation that the garbage collector has to track.
+An arena is freed once none of its items are referenced, so one long-lived
+item keeps its whole arena alive. Because the items are copied, callers can
+reuse their buffers once Enqueue returns.
 */
 type Queue struct {
-	Capacity int // soft cap for underlying slice of items in queue
-	items    [][]interface{}
-	len      int
-	mut      sync.Mutex
-	rx       int
-	ry       int
-	w        int
+	generation   uint64                      // first, so atomic access is 64-bit aligned on 32-bit platforms
+	Admission    Admission                   // decides whether items are enqueued, admits every item when nil
+	ByteArena    int                         // bytes per arena for small []byte items, disabled when 0
+	Capacity     int                         // soft cap for items in each chunk of the queue
+	Classify     func(interface{}) string    // classifies items for stats, disabled when nil
+	Clock        Clock                       // tells time and sleeps, defaults to the system clock
+	Cost         func(item interface{}) int  // cost of an item for MaxCost, disabled when nil
+	Equal        func(a, b interface{}) bool // compares items, defaults to ==
+	Growth       Growth                      // sizes of new chunks, defaults to doubling
+	Labels       map[string]string           // static labels of the queue for telemetry, like "tenant"
+	Locker       sync.Locker                 // locks the queue, defaults to a sync.Mutex
+	MaxCost      int                         // most cost of the items in the queue, unlimited when 0
+	MaxHeapBytes uint64                      // rejects enqueues while the heap is larger, disabled when 0
+	Sampler      *Sampler                    // traces every Nth item, disabled when nil
+	Size         func(item interface{}) int  // bytes of an item for the Bytes stat and TopK, disabled when nil
+	Trace        bool                        // annotates execution traces when true
+	TrackAge     bool                        // records when items are enqueued for the Oldest stat when true
+	ages         histogram
+	arena        byteArena
+	bytes        int
+	changed      chan struct{}
+	classes      map[string]int
+	closed       bool
+	control      []interface{}
+	cost         int
+	dequeued     uint64
+	dropped      uint64
+	enqueued     uint64
+	group        *Group
+	groupBytes   int
+	head         *chunk
+	heap         uint64
+	heapRead     time.Time
+	interrupted  chan struct{}
+	len          int
+	mirror       *Mirror
+	mut          sync.Mutex
+	removed      uint64
+	rx           int
+	shadow       *Shadow
+	spare        *chunk
+	tail         *chunk
+	takers       []chan interface{}
+	waits        histogram
+	watchers     []*watcher
+	wx           int
 }
 
 /*
-Enqueue adds a new item to the queue of any type. If the queue is empty or the
-current enqueue slice is actively being dequeued, a new slice will be created
-to enqueue items. Enqueue locks the queue while it is adding the item.
+Enqueue adds a new item to the queue of any type. If the last chunk of the
+queue is full, a new chunk will be added to enqueue items. If the item is not
+enqueued, like when the queue is closed, it is dropped and counted in the
+Dropped stat, and TryEnqueue reports why. Enqueue locks the queue while it is
+adding the item.
 */
 func (q *Queue) Enqueue(item interface{}) {
-	q.mut.Lock()
+	q.enqueueWith(item, nil)
+}
 
-	if len(q.items) == 0 || len(q.items) == q.w {
-		q.items = append(q.items, q.newSlice(item))
-	} else {
-		q.items[q.w] = append(q.items[q.w], item)
+/*
+TryEnqueue works like Enqueue, but it returns an error that says why the item
+was not enqueued, like ErrClosed or ErrMemoryLimit, and nil once it is
+enqueued.
+*/
+func (q *Queue) TryEnqueue(item interface{}) error {
+	return q.enqueueWith(item, nil)
+}
+
+/*
+EnqueuePoison adds n poison items to the queue, which are dequeued as ErrClosed
+instead of an item. Every consumer that dequeues ErrClosed should stop, so n
+consumers sharing the queue can be shut down by enqueuing n poison items after
+the last item of work. EnqueuePoison locks the queue while it is adding the
+items.
+*/
+func (q *Queue) EnqueuePoison(n int) {
+	q.lock()
+
+	for i := 0; i < n; i++ {
+		q.enqueue(ErrClosed)
 	}
 
-	q.len += 1
-	q.mut.Unlock()
+	q.notify()
+	q.unlock()
 }
 
 /*
 Dequeue will attempt to retrieve an item from the queue. If the queue is empty
-no item is returned and the interface{} can be asserted against nil. Dequeue
-locks the queue while it is retrieving the item.
+no item is returned and the interface{} can be asserted against nil. The queue
+does not keep a reference to a dequeued item, so it can be garbage collected as
+soon as the caller is done with it. Dequeue locks the queue while it is
+retrieving the item.
 */
 func (q *Queue) Dequeue() interface{} {
-	q.mut.Lock()
-	defer q.mut.Unlock()
+	q.lock()
+	defer q.unlock()
+
+	if val, ok := q.next(); ok {
+		q.notify()
 
-	if val, ok := q.dequeue(); ok {
 		return val
 	}
 
@@ -105,98 +296,585 @@ func (q *Queue) Dequeue() interface{} {
 
 /*
 DequeueBlocking will attempt to retrieve an item from the queue and block until
-there is an item in the queue. If timeout is greater than 0, a timer will be
-started for the given duration and DequeueBlocking will return nil if no item
-is enqueued within that time. If interval is greater than 0, each poll cycle
-will wait an amount of time equal to the interval between each attempt to
-retrieve an item. DequeueBlocking locks the queue during each poll, but it
-unlocks the queue between cycles to allow items to be enqueued.
+there is an item in the queue. If timeout is greater than 0, DequeueBlocking
+will return nil if no item is enqueued within that time. Each poll cycle will
+sleep for the interval between each attempt to retrieve an item, though with the
+system clock a cycle ends as soon as the queue changes. An item enqueued while
+DequeueBlocking waits can be handed to it directly. The timeout and interval are
+measured by the Clock of the queue. If a poison item is dequeued, ErrClosed is
+returned instead of an item, and if the queue is interrupted while
+DequeueBlocking waits, ErrInterrupted is returned. How long each call waited is
+recorded in the DequeueWait histogram of the queue Stats. DequeueBlocking locks
+the queue during each poll, but it unlocks the queue between cycles to allow
+items to be enqueued.
 */
 func (q *Queue) DequeueBlocking(timeout time.Duration, interval time.Duration) interface{} {
-	q.mut.Lock()
+	clock := clockOr(q.Clock)
+	start := clock.Now()
+	q.lock()
 
-	var timer *time.Timer
-	if timeout > 0 {
-		timer = time.NewTimer(timeout)
-		defer timer.Stop()
+	var taker chan interface{}
+	var interrupted <-chan struct{}
+
+	if q.pending() == 0 {
+		taker = make(chan interface{}, 1)
+		interrupted = q.interruption()
+
+		if region := traceRegion(q.Trace); region != nil {
+			defer region.End()
+		}
 	}
 
-	for q.len == 0 {
-		q.mut.Unlock()
+	for q.pending() == 0 {
+		changed := q.wait()
+		q.take(taker)
+		q.unlock()
 
-		if timer != nil {
-			select {
-			case <-timer.C:
-				return nil
-			default:
-				break
-			}
+		waited := clock.Now().Sub(start)
+		if timeout > 0 && waited >= timeout {
+			q.lock()
+			val, _ := q.untake(taker)
+			q.waits.observe(waited)
+			q.unlock()
+
+			return val
 		}
 
-		if interval > 0 {
-			time.Sleep(interval)
+		sleep := interval
+		if timeout > 0 && (sleep <= 0 || sleep > timeout-waited) {
+			sleep = timeout - waited
 		}
 
-		q.mut.Lock()
+		val, ok := pause(clock, sleep, changed, taker, interrupted)
+		q.lock()
+
+		if taken, took := q.untake(taker); took {
+			val, ok = taken, true
+		}
+
+		if ok {
+			q.waits.observe(clock.Now().Sub(start))
+			q.unlock()
+
+			return val
+		}
+
+		if q.pending() == 0 && isInterrupted(interrupted) {
+			q.waits.observe(clock.Now().Sub(start))
+			q.unlock()
+
+			return ErrInterrupted
+		}
 	}
 
-	val, _ := q.dequeue()
-	q.mut.Unlock()
+	val, _ := q.next()
+	q.waits.observe(clock.Now().Sub(start))
+	q.notify()
+	q.unlock()
 
 	return val
 }
 
+/*
+DequeueUntil works like DequeueBlocking, but it blocks until an absolute
+deadline instead of for a timeout, for callers that propagate the deadline of a
+request across retries without recomputing durations. The deadline is measured
+by the Clock of the queue. If the deadline has already passed, DequeueUntil
+tries to dequeue an item once without blocking, and a zero deadline blocks
+until there is an item.
+*/
+func (q *Queue) DequeueUntil(deadline time.Time, interval time.Duration) interface{} {
+	if deadline.IsZero() {
+		return q.DequeueBlocking(0, interval)
+	}
+
+	timeout := deadline.Sub(clockOr(q.Clock).Now())
+	if timeout <= 0 {
+		return q.Dequeue()
+	}
+
+	return q.DequeueBlocking(timeout, interval)
+}
+
+/*
+DequeueGroup removes the item at the head of the queue and the items right
+after it that have the same key, up to max items, and returns them in order.
+It is for consumers that batch work by a key, like the writes for one shard.
+Keys are compared with ==, so key must return comparable values. The group
+stops at the first item with another key, so the order of the queue is kept.
+If the item at the head is a poison item, DequeueGroup returns only ErrClosed,
+and a group stops before a poison item. A control item is always returned on
+its own. DequeueGroup returns nil when no items are queued. DequeueGroup locks
+the queue while it is removing the items.
+*/
+func (q *Queue) DequeueGroup(key func(item interface{}) interface{}, max int) []interface{} {
+	q.lock()
+	defer q.unlock()
+
+	if max <= 0 || q.pending() == 0 {
+		return nil
+	}
+
+	control := len(q.control) > 0
+	first, _ := q.next()
+	items := []interface{}{first}
+
+	if !control && first != ErrClosed {
+		k := key(first)
+
+		for len(items) < max && q.len > 0 {
+			next := untrace(q.head.items[q.rx])
+			if next == ErrClosed || key(next) != k {
+				break
+			}
+
+			val, _ := q.next()
+			items = append(items, val)
+		}
+	}
+
+	q.notify()
+
+	return items
+}
+
 /*
 Len returns how many items are enqueued. Len locks the queue.
 */
 func (q *Queue) Len() int {
-	q.mut.Lock()
-	defer q.mut.Unlock()
+	q.rlock()
+	defer q.runlock()
 
-	return q.len
+	return q.pending()
 }
 
-func (q *Queue) dequeue() (interface{}, bool) {
-	if len(q.items) == 0 || len(q.items[q.ry]) == 0 {
-		return nil, false
+/*
+WaitLen blocks until the queue has at least n items or the context is done. It
+returns the error of the context if the context is done first. WaitLen does not
+poll, it is woken up whenever the queue changes. WaitLen locks the queue to
+check the len, but it unlocks the queue while it is waiting.
+*/
+func (q *Queue) WaitLen(ctx context.Context, n int) error {
+	q.lock()
+
+	for q.pending() < n {
+		changed := q.wait()
+		q.unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+			break
+		}
+
+		q.lock()
 	}
 
-	val := q.items[q.ry][q.rx]
-	q.len -= 1
+	q.unlock()
 
-	if len(q.items[q.ry]) == q.rx+1 {
-		q.items[q.ry] = q.items[q.ry][:0]
-		q.rx = 0
+	return nil
+}
 
-		if q.len == 0 {
-			q.items = q.items[:0]
-			q.ry, q.w = 0, 0
-		} else {
-			q.ry = q.w
+/*
+Contains reports whether any item in the queue matches the predicate. Contains
+is O(n), and it locks the queue while it is checking the items.
+*/
+func (q *Queue) Contains(pred func(item interface{}) bool) bool {
+	q.rlock()
+	defer q.runlock()
+
+	return q.index(pred) >= 0
+}
+
+/*
+Position returns how many items would be dequeued before the first item that
+matches the predicate, so the next item to be dequeued is at position 0. If no
+item matches, Position returns -1. Position is O(n), and it locks the queue
+while it is checking the items.
+*/
+func (q *Queue) Position(pred func(item interface{}) bool) int {
+	q.rlock()
+	defer q.runlock()
+
+	return q.index(pred)
+}
+
+/*
+Remove removes every item in the queue that is equal to the given item, and it
+returns how many items were removed. Items are compared with Equal. The order
+of the remaining items is kept. Remove is O(n), and it locks the queue while it
+is removing the items.
+*/
+func (q *Queue) Remove(item interface{}) int {
+	q.lock()
+	defer q.unlock()
+
+	removed := q.filter(func(val interface{}) bool {
+		if !q.equal(untrace(val), item) {
+			return true
 		}
-	} else {
-		if q.w == q.ry {
-			if q.w > 0 {
-				q.w = 0
-			} else {
-				q.w = q.ry + 1
+
+		q.unadmit(untrace(val))
+
+		return false
+	})
+
+	if removed > 0 {
+		q.notify()
+	}
+
+	return removed
+}
+
+/*
+Range calls fn for each item in the queue in the order they would be dequeued,
+until fn returns false. Range only locks the queue long enough to share the
+internal chunks of the queue, and it iterates over them after the queue is
+unlocked, so a long iteration does not stall producers and consumers. The
+queue never changes or reuses a shared chunk, so Range sees the items that were
+in the queue when it was called, even if they are dequeued while it is
+iterating.
+*/
+func (q *Queue) Range(fn func(item interface{}) bool) {
+	for _, segment := range q.share() {
+		for _, val := range segment {
+			if !fn(untrace(val)) {
+				return
 			}
 		}
+	}
+}
 
-		q.rx += 1
+/*
+PeekWhere returns up to max items for which pred returns true, in the order
+they would be dequeued, without removing them. It is for schedulers that plan
+a batch, like the items for one destination, before dequeuing it. Poison items
+are skipped. Like Range, PeekWhere only locks the queue long enough to share
+the internal chunks of the queue, and pred is called after the queue is
+unlocked, so the items may have been dequeued by the time PeekWhere returns.
+*/
+func (q *Queue) PeekWhere(pred func(item interface{}) bool, max int) []interface{} {
+	if max <= 0 {
+		return nil
 	}
 
-	return val, true
+	var items []interface{}
+
+	q.Range(func(item interface{}) bool {
+		if item != ErrClosed && pred(item) {
+			items = append(items, item)
+		}
+
+		return len(items) < max
+	})
+
+	return items
 }
 
-func (q *Queue) newSlice(e interface{}) []interface{} {
-	capacity := q.Capacity
-	if capacity == 0 {
-		capacity = 1
+/*
+Snapshot returns a copy of the items in the queue in the order they would be
+dequeued. Like Range, Snapshot only locks the queue long enough to share the
+internal chunks of the queue, and it copies the items after the queue is
+unlocked.
+*/
+func (q *Queue) Snapshot() []interface{} {
+	segments := q.share()
+	n := 0
+
+	for _, segment := range segments {
+		n += len(segment)
 	}
 
-	slice := make([]interface{}, 1, capacity)
-	slice[0] = e
+	if n == 0 {
+		return nil
+	}
 
-	return slice
+	items := make([]interface{}, 0, n)
+	for _, segment := range segments {
+		for _, val := range segment {
+			items = append(items, untrace(val))
+		}
+	}
+
+	return items
+}
+
+/*
+Sample returns up to n items chosen at random from the queue without removing
+them. The items are returned in the order they would be dequeued. Sample only
+visits the chosen items and the chunks that hold them, so it can be used to
+show representative contents of very large queues. Sample locks the queue while
+it is choosing the items.
+*/
+func (q *Queue) Sample(n int) []interface{} {
+	q.rlock()
+	defer q.runlock()
+
+	if n > q.len {
+		n = q.len
+	}
+
+	if n <= 0 {
+		return nil
+	}
+
+	chosen := make(map[int]bool, n)
+	for i := q.len - n; i < q.len; i++ {
+		if j := rand.Intn(i + 1); chosen[j] {
+			chosen[i] = true
+		} else {
+			chosen[j] = true
+		}
+	}
+
+	indexes := make([]int, 0, n)
+	for i := range chosen {
+		indexes = append(indexes, i)
+	}
+
+	sort.Ints(indexes)
+
+	items := make([]interface{}, n)
+	c, start, skipped := q.head, q.rx, 0
+
+	for i, index := range indexes {
+		for skipped+q.end(c)-start <= index {
+			skipped += q.end(c) - start
+			c, start = c.next, 0
+		}
+
+		items[i] = untrace(c.items[start+index-skipped])
+	}
+
+	return items
+}
+
+func (q *Queue) notify() {
+	atomic.AddUint64(&q.generation, 1)
+
+	if q.changed != nil {
+		close(q.changed)
+		q.changed = nil
+	}
+}
+
+func (q *Queue) wait() <-chan struct{} {
+	if q.changed == nil {
+		q.changed = make(chan struct{})
+	}
+
+	return q.changed
+}
+
+func pause(clock Clock, d time.Duration, changed <-chan struct{}, taker chan interface{}, interrupted <-chan struct{}) (interface{}, bool) {
+	if sim, ok := clock.(*Sim); ok {
+		sim.sleepOn(d, changed, interrupted)
+
+		return nil, false
+	}
+
+	if _, ok := clock.(systemClock); !ok {
+		clock.Sleep(d)
+
+		return nil, false
+	}
+
+	var expired <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case val := <-taker:
+		return val, true
+	case <-changed:
+		return nil, false
+	case <-interrupted:
+		return nil, false
+	case <-expired:
+		return nil, false
+	}
+}
+
+func pauseAny(clock Clock, d time.Duration, changed []<-chan struct{}) {
+	if sim, ok := clock.(*Sim); ok {
+		sim.sleepOn(d, changed...)
+
+		return
+	}
+
+	if _, ok := clock.(systemClock); !ok {
+		clock.Sleep(d)
+
+		return
+	}
+
+	cases := make([]reflect.SelectCase, 0, len(changed)+1)
+	for _, c := range changed {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)})
+	}
+
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)})
+	}
+
+	reflect.Select(cases)
+}
+
+func (q *Queue) enqueueWith(item interface{}, h *Handle) error {
+	if err := q.admit(item); err != nil {
+		return err
+	}
+
+	size := 0
+
+	q.lock()
+
+	group := q.group
+	if group != nil && item != ErrClosed {
+		q.unlock()
+		size = group.size(item)
+
+		if err := group.reserve(q, size); err != nil {
+			q.lock()
+			q.dropped += 1
+			q.unlock()
+			q.unadmit(item)
+
+			return err
+		}
+
+		q.lock()
+	}
+
+	var err error
+	if q.closed {
+		err = ErrClosed
+	} else if q.overMemory() {
+		err = ErrMemoryLimit
+	} else if q.overCost(item) {
+		err = ErrCostExceeded
+	}
+
+	if err != nil {
+		q.dropped += 1
+		q.unlock()
+
+		if group != nil && item != ErrClosed {
+			group.unreserve(size)
+		}
+
+		q.unadmit(item)
+
+		return err
+	}
+
+	if q.ByteArena > 0 {
+		item = q.arena.copy(item, q.ByteArena)
+	}
+
+	if len(q.watchers) > 0 && item != ErrClosed && q.watch(item, h == nil) {
+		q.enqueued += 1
+		q.dequeued += 1
+		q.unlock()
+
+		if group != nil {
+			group.unreserve(size)
+		}
+
+		return nil
+	}
+
+	if h == nil && q.transfer(item) {
+		q.notify()
+		q.unlock()
+
+		return nil
+	}
+
+	if q.Trace {
+		item = traceItem(item)
+	}
+
+	if q.TrackAge {
+		item = withTime(item, clockOr(q.Clock).Now())
+	}
+
+	if q.Sampler != nil {
+		if smp := q.Sampler.start(untrace(item), clockOr(q.Clock).Now()); smp != nil {
+			item = withSample(item, smp)
+		}
+	}
+
+	if h != nil {
+		item = withHandle(item, h)
+	}
+
+	q.enqueue(item)
+	q.groupBytes += size
+	q.notify()
+	q.unlock()
+
+	return nil
+}
+
+func (q *Queue) dequeueContext(ctx context.Context) (interface{}, error) {
+	val, _, err := q.dequeueSample(ctx)
+
+	return val, err
+}
+
+func (q *Queue) equal(a, b interface{}) bool {
+	if q.Equal != nil {
+		return q.Equal(a, b)
+	}
+
+	if t := reflect.TypeOf(a); t != nil && !t.Comparable() {
+		return false
+	}
+
+	return a == b
+}
+
+func (q *Queue) drop(val interface{}) {
+	settle(val)
+
+	if smp := sampleOf(val); smp != nil {
+		smp.left(clockOr(q.Clock).Now(), "removed")
+	}
+
+	q.unbudget(untrace(val))
+
+	if q.Size != nil {
+		q.bytes -= q.sizeOf(untrace(val))
+	}
+
+	if q.Cost != nil {
+		q.cost -= q.costOf(untrace(val))
+	}
+
+	if q.Classify != nil {
+		q.classify(untrace(val), -1)
+	}
+}
+
+func (q *Queue) classify(item interface{}, delta int) {
+	if q.classes == nil {
+		q.classes = make(map[string]int)
+	}
+
+	class := q.Classify(item)
+
+	if n := q.classes[class] + delta; n > 0 {
+		q.classes[class] = n
+	} else {
+		delete(q.classes, class)
+	}
 }
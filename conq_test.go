@@ -5,7 +5,11 @@
 package conq_test
 
 import (
+	"context"
 	"github.com/sebuckler/conq"
+	"math/rand"
+	"reflect"
+	"runtime"
 	"sort"
 	"sync"
 	"testing"
@@ -14,7 +18,28 @@ import (
 
 func TestQueue_Enqueue(t *testing.T) {
 	testCases := map[string]func(t *testing.T, name string){
-		"should grow queue len": shouldGrowQueue,
+		"should grow queue len":             shouldGrowQueue,
+		"should drop items of closed queue": shouldDropEnqueueOfClosed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_TryEnqueue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should return error of closed queue": shouldReturnTryEnqueueClosed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_Growth(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should grow by growth policy": shouldGrowByPolicy,
 	}
 
 	for name, test := range testCases {
@@ -27,6 +52,9 @@ func TestQueue_Dequeue(t *testing.T) {
 		"should have correct items":            shouldHaveItems,
 		"should have correct concurrent items": shouldHaveConcItems,
 		"should be nil when no items queued":   shouldDequeueNil,
+		"should release dequeued items":        shouldReleaseDequeued,
+		"should match slice of items":          shouldMatchSliceOfItems,
+		"should publish writes before enqueue": shouldPublishDequeue,
 	}
 
 	for name, test := range testCases {
@@ -39,6 +67,108 @@ func TestQueue_DequeueBlocking(t *testing.T) {
 		"should have correct items":                  shouldHaveItemsBlockingNoTimeoutNoInterval,
 		"should block until concurrent items queued": shouldBlockUntilItems,
 		"should be nil when no items queued":         shouldDequeueNilBlockingNoTimeoutNoInterval,
+		"should be closed once per poison item":      shouldDequeueClosedPerPoison,
+		"should publish writes before enqueue":       shouldPublishDequeueBlocking,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_DequeueUntil(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should not block after deadline":     shouldNotBlockAfterDeadline,
+		"should block until deadline":         shouldBlockUntilDeadline,
+		"should dequeue item before deadline": shouldDequeueBeforeDeadline,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_DequeueGroup(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should dequeue items with head key": shouldDequeueHeadGroup,
+		"should dequeue up to max items":     shouldDequeueGroupUpToMax,
+		"should stop before poison item":     shouldStopGroupAtPoison,
+		"should be nil when no items queued": shouldDequeueGroupNil,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_WaitLen(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should wait until len reached":     shouldWaitUntilLen,
+		"should stop waiting when done":     shouldStopWaitingLen,
+		"should not wait with enough items": shouldNotWaitLen,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_Position(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have position of first match": shouldHavePosition,
+		"should not have position of missing": shouldNotHavePosition,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_Remove(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should remove equal items":              shouldRemoveEqualItems,
+		"should remove items with equal func":    shouldRemoveItemsWithEqual,
+		"should not remove uncomparable items":   shouldNotRemoveUncomparable,
+		"should dequeue remaining after removal": shouldDequeueAfterRemove,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_Snapshot(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have items in queue order":     shouldSnapshotItems,
+		"should not change after queue change": shouldNotChangeSnapshot,
+		"should stop range when fn is false":   shouldStopRange,
+		"should range while queue is in use":   shouldRangeConcurrently,
+		"should be nil when no items queued":   shouldSnapshotNil,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_PeekWhere(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should peek matching items":  shouldPeekMatching,
+		"should peek up to max items": shouldPeekUpToMax,
+		"should skip poison items":    shouldPeekSkipPoison,
+		"should be nil when max is 0": shouldPeekNilMax,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_Sample(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have items in queue order":   shouldSampleItemsInOrder,
+		"should have all items when n large": shouldSampleAllItems,
+		"should be nil when no items queued": shouldSampleNil,
 	}
 
 	for name, test := range testCases {
@@ -164,3 +294,725 @@ func shouldDequeueNilBlockingNoTimeoutNoInterval(t *testing.T, name string) {
 		t.Logf("%s: was not nil after timeout", name)
 	}
 }
+
+func shouldSampleItemsInOrder(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	for i := 0; i < 10; i++ {
+		queue.Enqueue(i)
+	}
+
+	queue.Dequeue()
+	queue.Enqueue(10)
+	actual := queue.Sample(4)
+
+	if len(actual) != 4 || queue.Len() != 10 {
+		t.Fail()
+		t.Logf("%s: did not sample correct number of items %v", name, actual)
+
+		return
+	}
+
+	for i := 1; i < len(actual); i++ {
+		if actual[i-1].(int) >= actual[i].(int) || actual[i-1].(int) < 1 {
+			t.Fail()
+			t.Logf("%s: did not have items in queue order %v", name, actual)
+		}
+	}
+}
+
+func shouldSampleAllItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	queue.Dequeue()
+	queue.Enqueue(3)
+	actual := queue.Sample(5)
+
+	if len(actual) != 2 || actual[0] != 2 || actual[1] != 3 {
+		t.Fail()
+		t.Logf("%s: did not have all items %v", name, actual)
+	}
+}
+
+func shouldSampleNil(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	if queue.Sample(3) != nil {
+		t.Fail()
+		t.Logf("%s: was not nil", name)
+	}
+}
+
+func shouldDequeueClosedPerPoison(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	var closed int
+	var items []int
+	var mut sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		queue.Enqueue(i)
+	}
+
+	queue.EnqueuePoison(3)
+	wg.Add(3)
+
+	for range [3]int{} {
+		go func() {
+			for {
+				item := queue.DequeueBlocking(time.Second, 0)
+				mut.Lock()
+
+				if item == conq.ErrClosed {
+					closed += 1
+					mut.Unlock()
+					wg.Done()
+
+					return
+				}
+
+				items = append(items, item.(int))
+				mut.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if closed != 3 || len(items) != 10 || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: had %d closed consumers and %d items", name, closed, len(items))
+	}
+}
+
+func shouldRemoveEqualItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	queue.Enqueue(1)
+
+	if removed := queue.Remove(1); removed != 2 || queue.Len() != 1 || queue.Dequeue() != 2 {
+		t.Fail()
+		t.Logf("%s: removed %d items", name, removed)
+	}
+}
+
+func shouldRemoveItemsWithEqual(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, Equal: reflect.DeepEqual}
+
+	queue.Enqueue([]int{1})
+	queue.Enqueue([]int{2})
+
+	if removed := queue.Remove([]int{1}); removed != 1 || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: removed %d items", name, removed)
+	}
+}
+
+func shouldNotRemoveUncomparable(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	queue.Enqueue([]int{1})
+
+	if removed := queue.Remove([]int{1}); removed != 0 || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: removed %d items", name, removed)
+	}
+}
+
+func shouldDequeueAfterRemove(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	var actual []int
+
+	for i := 1; i <= 4; i++ {
+		queue.Enqueue(i)
+	}
+
+	queue.Dequeue()
+	queue.Enqueue(5)
+	queue.Enqueue(6)
+	queue.Remove(2)
+	queue.Remove(3)
+	queue.Remove(4)
+	queue.Enqueue(7)
+
+	for queue.Len() > 0 {
+		actual = append(actual, queue.Dequeue().(int))
+	}
+
+	if len(actual) != 3 || actual[0] != 5 || actual[1] != 6 || actual[2] != 7 {
+		t.Fail()
+		t.Logf("%s: did not have remaining items %v", name, actual)
+	}
+}
+
+func shouldHavePosition(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	isEven := func(item interface{}) bool { return item.(int)%2 == 0 }
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	queue.Dequeue()
+	queue.Enqueue(3)
+	queue.Enqueue(4)
+
+	if position := queue.Position(isEven); position != 0 || !queue.Contains(isEven) {
+		t.Fail()
+		t.Logf("%s: had position %d", name, position)
+	}
+
+	queue.Dequeue()
+
+	if position := queue.Position(isEven); position != 1 || !queue.Contains(isEven) {
+		t.Fail()
+		t.Logf("%s: had position %d", name, position)
+	}
+}
+
+func shouldNotHavePosition(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	isZero := func(item interface{}) bool { return item == 0 }
+
+	queue.Enqueue(1)
+
+	if position := queue.Position(isZero); position != -1 || queue.Contains(isZero) {
+		t.Fail()
+		t.Logf("%s: had position %d", name, position)
+	}
+}
+
+func shouldWaitUntilLen(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := range [3]int{} {
+		go func(i int) {
+			time.Sleep(time.Millisecond)
+			queue.Enqueue(i)
+		}(i)
+	}
+
+	if err := queue.WaitLen(ctx, 3); err != nil || queue.Len() != 3 {
+		t.Fail()
+		t.Logf("%s: did not wait until len was reached: %v", name, err)
+	}
+}
+
+func shouldStopWaitingLen(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	queue.Enqueue(1)
+
+	if err := queue.WaitLen(ctx, 2); err != context.DeadlineExceeded {
+		t.Fail()
+		t.Logf("%s: did not stop waiting: %v", name, err)
+	}
+}
+
+func shouldNotWaitLen(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	queue.Enqueue(1)
+
+	if err := queue.WaitLen(ctx, 1); err != nil {
+		t.Fail()
+		t.Logf("%s: waited with enough items: %v", name, err)
+	}
+}
+
+func shouldSnapshotItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	for i := 1; i <= 4; i++ {
+		queue.Enqueue(i)
+	}
+
+	queue.Dequeue()
+	queue.Enqueue(5)
+	actual := queue.Snapshot()
+
+	if len(actual) != 4 || actual[0] != 2 || actual[3] != 5 || queue.Len() != 4 {
+		t.Fail()
+		t.Logf("%s: did not have correct items %v", name, actual)
+	}
+}
+
+func shouldNotChangeSnapshot(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	for i := 1; i <= 4; i++ {
+		queue.Enqueue(i)
+	}
+
+	queue.Dequeue()
+	queue.Enqueue(5)
+	queue.Enqueue(6)
+	expected := []interface{}{2, 3, 4, 5, 6}
+	var actual []interface{}
+	snapshot := queue.Snapshot()
+
+	queue.Range(func(item interface{}) bool {
+		if len(actual) == 0 {
+			queue.Remove(3)
+
+			for i := 0; i < 20; i++ {
+				queue.Dequeue()
+				queue.Enqueue(i + 10)
+			}
+
+			queue.Remove(12)
+		}
+
+		actual = append(actual, item)
+
+		return true
+	})
+
+	if !reflect.DeepEqual(actual, expected) || !reflect.DeepEqual(snapshot, expected) {
+		t.Fail()
+		t.Logf("%s: changed to %v and %v", name, actual, snapshot)
+	}
+}
+
+func shouldStopRange(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	var actual []interface{}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	queue.Range(func(item interface{}) bool {
+		actual = append(actual, item)
+
+		return false
+	})
+
+	if len(actual) != 1 || actual[0] != 1 {
+		t.Fail()
+		t.Logf("%s: did not stop range %v", name, actual)
+	}
+}
+
+func shouldRangeConcurrently(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 8}
+	var wg sync.WaitGroup
+
+	wg.Add(3)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			queue.Enqueue(i)
+		}
+
+		wg.Done()
+	}()
+
+	go func() {
+		for i := 0; i < 1000; i++ {
+			queue.DequeueBlocking(time.Second, 0)
+		}
+
+		wg.Done()
+	}()
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			previous := -1
+
+			queue.Range(func(item interface{}) bool {
+				if item.(int) <= previous {
+					t.Fail()
+					t.Logf("%s: did not have items in order", name)
+				}
+
+				previous = item.(int)
+
+				return true
+			})
+		}
+
+		wg.Done()
+	}()
+
+	wg.Wait()
+}
+
+func shouldSnapshotNil(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	if queue.Snapshot() != nil {
+		t.Fail()
+		t.Logf("%s: was not nil", name)
+	}
+}
+
+func shouldGrowByPolicy(t *testing.T, name string) {
+	item := &struct{}{}
+	allocs := func(growth conq.Growth) float64 {
+		return testing.AllocsPerRun(10, func() {
+			queue := &conq.Queue{Capacity: 1, Growth: growth}
+
+			for i := 0; i < 500; i++ {
+				queue.Enqueue(item)
+			}
+
+			if queue.Len() != 500 {
+				t.Fail()
+				t.Logf("%s: did not have correct len", name)
+			}
+		})
+	}
+
+	step := allocs(conq.Growth{Step: 1000})
+	double := allocs(conq.Growth{})
+	max := allocs(conq.Growth{Max: 10})
+
+	if step >= double || double >= max {
+		t.Fail()
+		t.Logf("%s: allocated %v with step, %v doubling, and %v with max", name, step, double, max)
+	}
+}
+
+func shouldReleaseDequeued(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 8}
+	released := make(chan struct{})
+	item := &[64]byte{}
+
+	runtime.SetFinalizer(item, func(*[64]byte) { close(released) })
+	queue.Enqueue(item)
+	queue.Enqueue(2)
+	queue.Enqueue(3)
+	queue.Dequeue()
+	item = nil
+
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+
+		select {
+		case <-released:
+			runtime.KeepAlive(queue)
+
+			return
+		case <-time.After(10 * time.Millisecond):
+			break
+		}
+	}
+
+	t.Fail()
+	t.Logf("%s: did not release dequeued item with %d items in queue", name, queue.Len())
+}
+
+func shouldMatchSliceOfItems(t *testing.T, name string) {
+	random := rand.New(rand.NewSource(1))
+
+	for _, growth := range []conq.Growth{{}, {Step: 3}, {Max: 2}} {
+		queue := &conq.Queue{Capacity: 2, Growth: growth}
+		var expected []interface{}
+		var snapshots [][]interface{}
+		var copies [][]interface{}
+
+		for i := 0; i < 5000; i++ {
+			switch op := random.Intn(10); {
+			case op < 5:
+				queue.Enqueue(i)
+				expected = append(expected, i)
+			case op < 8:
+				if val := queue.Dequeue(); len(expected) > 0 && val != expected[0] || len(expected) == 0 && val != nil {
+					t.Fail()
+					t.Logf("%s: dequeued %v instead of %v", name, val, expected)
+
+					return
+				}
+
+				if len(expected) > 0 {
+					expected = expected[1:]
+				}
+			case op < 9 && len(expected) > 0:
+				item := expected[random.Intn(len(expected))]
+				queue.Remove(item)
+
+				for j, val := range expected {
+					if val == item {
+						expected = append(expected[:j:j], expected[j+1:]...)
+
+						break
+					}
+				}
+			default:
+				snapshots = append(snapshots, queue.Snapshot())
+				copies = append(copies, append([]interface{}(nil), expected...))
+			}
+		}
+
+		if actual := queue.Snapshot(); queue.Len() != len(expected) || len(expected) > 0 && !reflect.DeepEqual(actual, expected) {
+			t.Fail()
+			t.Logf("%s: had %v instead of %v", name, actual, expected)
+		}
+
+		for i := range snapshots {
+			if len(copies[i]) > 0 && !reflect.DeepEqual(snapshots[i], copies[i]) {
+				t.Fail()
+				t.Logf("%s: snapshot changed to %v from %v", name, snapshots[i], copies[i])
+			}
+		}
+	}
+}
+
+func shouldPeekMatching(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2}
+
+	for i := 1; i <= 6; i++ {
+		queue.Enqueue(i)
+	}
+
+	actual := queue.PeekWhere(func(item interface{}) bool { return item.(int)%2 == 0 }, 10)
+
+	if !reflect.DeepEqual(actual, []interface{}{2, 4, 6}) || queue.Len() != 6 {
+		t.Fail()
+		t.Logf("%s: did not peek matching items %v", name, actual)
+	}
+}
+
+func shouldPeekUpToMax(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	calls := 0
+
+	for i := 1; i <= 6; i++ {
+		queue.Enqueue(i)
+	}
+
+	actual := queue.PeekWhere(func(item interface{}) bool {
+		calls += 1
+
+		return item.(int) > 1
+	}, 2)
+
+	if !reflect.DeepEqual(actual, []interface{}{2, 3}) || calls != 3 {
+		t.Fail()
+		t.Logf("%s: did not peek up to max items %v after %d calls", name, actual, calls)
+	}
+}
+
+func shouldPeekSkipPoison(t *testing.T, name string) {
+	queue := &conq.Queue{}
+
+	queue.Enqueue(1)
+	queue.EnqueuePoison(1)
+
+	actual := queue.PeekWhere(func(item interface{}) bool { return true }, 10)
+
+	if !reflect.DeepEqual(actual, []interface{}{1}) {
+		t.Fail()
+		t.Logf("%s: did not skip poison items %v", name, actual)
+	}
+}
+
+func shouldPeekNilMax(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+
+	if actual := queue.PeekWhere(func(item interface{}) bool { return true }, 0); actual != nil {
+		t.Fail()
+		t.Logf("%s: did not peek nil %v", name, actual)
+	}
+}
+
+func shard(item interface{}) interface{} {
+	return item.(string)[:1]
+}
+
+func shouldDequeueHeadGroup(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2}
+
+	for _, item := range []string{"a1", "a2", "a3", "b1", "a4"} {
+		queue.Enqueue(item)
+	}
+
+	first := queue.DequeueGroup(shard, 10)
+	second := queue.DequeueGroup(shard, 10)
+
+	if !reflect.DeepEqual(first, []interface{}{"a1", "a2", "a3"}) || !reflect.DeepEqual(second, []interface{}{"b1"}) ||
+		queue.Len() != 1 || queue.Stats().Dequeued != 4 {
+		t.Fail()
+		t.Logf("%s: did not dequeue groups %v %v", name, first, second)
+	}
+}
+
+func shouldDequeueGroupUpToMax(t *testing.T, name string) {
+	queue := &conq.Queue{}
+
+	for _, item := range []string{"a1", "a2", "a3"} {
+		queue.Enqueue(item)
+	}
+
+	if actual := queue.DequeueGroup(shard, 2); !reflect.DeepEqual(actual, []interface{}{"a1", "a2"}) || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: did not dequeue up to max items %v", name, actual)
+	}
+}
+
+func shouldStopGroupAtPoison(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue("a1")
+	queue.EnqueuePoison(1)
+	queue.Enqueue("a2")
+
+	first := queue.DequeueGroup(shard, 10)
+	second := queue.DequeueGroup(shard, 10)
+
+	if !reflect.DeepEqual(first, []interface{}{"a1"}) || len(second) != 1 || second[0] != conq.ErrClosed || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: did not stop group at poison item %v %v", name, first, second)
+	}
+}
+
+func shouldDequeueGroupNil(t *testing.T, name string) {
+	queue := &conq.Queue{}
+
+	if actual := queue.DequeueGroup(shard, 10); actual != nil {
+		t.Fail()
+		t.Logf("%s: did not dequeue nil %v", name, actual)
+	}
+}
+
+type published struct {
+	data  []int
+	ready bool
+}
+
+func checkPublished(t *testing.T, name string, enqueue func(item interface{}), dequeue func() interface{}) {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			p := &published{data: make([]int, 8)}
+			for j := range p.data {
+				p.data[j] = i
+			}
+
+			p.ready = true
+			enqueue(p)
+		}
+	}()
+
+	for i := 0; i < 100; {
+		val := dequeue()
+		if val == nil {
+			runtime.Gosched()
+
+			continue
+		}
+
+		if p := val.(*published); !p.ready || p.data[0] != p.data[7] {
+			t.Fail()
+			t.Logf("%s: did not see writes made before enqueue of item %d", name, p.data[0])
+		}
+
+		i += 1
+	}
+
+	<-done
+}
+
+func shouldPublishDequeue(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 4}
+
+	checkPublished(t, name, func(item interface{}) { queue.Enqueue(item) }, queue.Dequeue)
+}
+
+func shouldPublishDequeueBlocking(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 4}
+
+	checkPublished(t, name, func(item interface{}) { queue.Enqueue(item) }, func() interface{} {
+		return queue.DequeueBlocking(time.Second, 0)
+	})
+}
+
+func shouldNotBlockAfterDeadline(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	start := sim.Now()
+	sim.Sleep(time.Second)
+
+	empty := queue.DequeueUntil(start, time.Millisecond)
+	queue.Enqueue(1)
+	got := queue.DequeueUntil(start, time.Millisecond)
+
+	if empty != nil || got != 1 || sim.Now().Sub(start) != time.Second {
+		t.Fail()
+		t.Logf("%s: dequeued %v and %v after the deadline", name, empty, got)
+	}
+}
+
+func shouldBlockUntilDeadline(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	deadline := sim.Now().Add(time.Second)
+
+	got := interface{}(0)
+	var at time.Time
+	sim.Go(func() {
+		got = queue.DequeueUntil(deadline, 300*time.Millisecond)
+		at = sim.Now()
+	})
+
+	if err := sim.Run(); err != nil || got != nil || at.Before(deadline) {
+		t.Fail()
+		t.Logf("%s: dequeued %v at %v before %v: %v", name, got, at, deadline, err)
+	}
+}
+
+func shouldDequeueBeforeDeadline(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	start := sim.Now()
+
+	var got interface{}
+	var waited time.Duration
+	sim.Go(func() {
+		got = queue.DequeueUntil(start.Add(time.Minute), 100*time.Millisecond)
+		waited = sim.Now().Sub(start)
+	})
+	sim.Go(func() {
+		sim.Sleep(time.Second)
+		queue.Enqueue(1)
+	})
+
+	if err := sim.Run(); err != nil || got != 1 || waited >= time.Minute {
+		t.Fail()
+		t.Logf("%s: dequeued %v after %v: %v", name, got, waited, err)
+	}
+}
+
+func shouldDropEnqueueOfClosed(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	var enqueue func(item interface{}) = queue.Enqueue
+
+	enqueue(1)
+	queue.Close()
+	enqueue(2)
+
+	if stats := queue.Stats(); stats.Len != 1 || stats.Dropped != 1 {
+		t.Fail()
+		t.Logf("%s: did not drop item of closed queue: %+v", name, stats)
+	}
+}
+
+func shouldReturnTryEnqueueClosed(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	first := queue.TryEnqueue(1)
+	queue.Close()
+
+	if err := queue.TryEnqueue(2); first != nil || err != conq.ErrClosed || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: returned %v and %v", name, first, err)
+	}
+}
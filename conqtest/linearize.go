@@ -0,0 +1,251 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conqtest
+
+import (
+	"fmt"
+	"github.com/sebuckler/conq"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	linearizeGoroutines = 4
+	linearizeOps        = 25
+	linearizeRounds     = 20
+)
+
+/*
+Op is an operation on a queue in a History. Call and Return are the logical
+times when the operation was called and when it returned, so an operation that
+returned before another was called happened before it. Item is the item that
+was enqueued, or the item that was dequeued, which is nil if the queue was
+empty.
+*/
+type Op struct {
+	Call    int64       // logical time when the operation was called
+	Dequeue bool        // whether the operation is a Dequeue, or else an Enqueue
+	Err     error       // error returned by TryEnqueue
+	Item    interface{} // item enqueued or dequeued
+	Return  int64       // logical time when the operation returned
+}
+
+/*
+History records the operations of goroutines on a queue, so Check can verify
+that the queue is linearizable: that each operation appears to take effect at
+one instant between its call and its return, in an order that a FIFO queue
+could have produced. Enqueue and Dequeue call the queue and record the
+operation, and they can be called from several goroutines at once. The items
+must be comparable with == and each item must only be enqueued once, so the
+dequeued items can be told apart. Enqueues that return an error are not checked.
+*/
+type History struct {
+	time int64 // first, as atomic adds need 64-bit alignment on 32-bit platforms
+	mut  sync.Mutex
+	ops  []Op
+}
+
+type linearizer struct {
+	done []uint64
+	ops  []Op
+	seen map[string]bool
+}
+
+/*
+Enqueue enqueues the item into the queue, records the operation, and returns
+the error of the queue.
+*/
+func (h *History) Enqueue(q conq.Interface, item interface{}) error {
+	call := atomic.AddInt64(&h.time, 1)
+	err := q.TryEnqueue(item)
+	h.record(Op{Call: call, Err: err, Item: item, Return: atomic.AddInt64(&h.time, 1)})
+
+	return err
+}
+
+/*
+Dequeue dequeues an item from the queue, records the operation, and returns the
+item.
+*/
+func (h *History) Dequeue(q conq.Interface) interface{} {
+	call := atomic.AddInt64(&h.time, 1)
+	item := q.Dequeue()
+	h.record(Op{Call: call, Dequeue: true, Item: item, Return: atomic.AddInt64(&h.time, 1)})
+
+	return item
+}
+
+/*
+Ops returns a copy of the recorded operations in the order they returned.
+*/
+func (h *History) Ops() []Op {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	return append([]Op(nil), h.ops...)
+}
+
+/*
+Check returns nil if the recorded operations are linearizable, or an error
+otherwise. It is CheckHistory of the recorded operations.
+*/
+func (h *History) Check() error {
+	return CheckHistory(h.Ops())
+}
+
+/*
+CheckHistory returns nil if the operations are linearizable for a FIFO queue
+that is empty before the first operation, or an error otherwise. It searches
+the orders of the operations that respect which operation happened before
+which, and skips orders that lead to a state it already ruled out, but the
+search can still take exponential time, so histories should be kept to a few
+hundred operations.
+*/
+func CheckHistory(ops []Op) error {
+	l := &linearizer{seen: make(map[string]bool)}
+
+	for _, op := range ops {
+		if op.Err == nil {
+			l.ops = append(l.ops, op)
+		}
+	}
+
+	l.done = make([]uint64, (len(l.ops)+63)/64)
+
+	if !l.search(nil, len(l.ops)) {
+		return fmt.Errorf("conqtest: history of %d operations is not linearizable", len(l.ops))
+	}
+
+	return nil
+}
+
+/*
+CheckLinearizable checks that the queues created by newQueue are linearizable.
+It runs rounds of random enqueues and dequeues on a new queue from several
+goroutines at once, records them in a History, and fails the test if a history
+is not linearizable. The operations are chosen by a source seeded with seed,
+which is logged with every failure so it can be reproduced.
+*/
+func CheckLinearizable(t testing.TB, seed int64, newQueue func() conq.Interface) {
+	t.Helper()
+
+	for round := 0; round < linearizeRounds; round++ {
+		queue := newQueue()
+		h := &History{}
+
+		var wg sync.WaitGroup
+		for g := 0; g < linearizeGoroutines; g++ {
+			wg.Add(1)
+
+			go func(g int) {
+				defer wg.Done()
+
+				r := rand.New(rand.NewSource(seed + int64(round*linearizeGoroutines+g)))
+
+				for op := 0; op < linearizeOps; op++ {
+					if r.Intn(2) == 0 {
+						h.Enqueue(queue, g*linearizeOps+op)
+					} else {
+						h.Dequeue(queue)
+					}
+				}
+			}(g)
+		}
+
+		wg.Wait()
+
+		if err := h.Check(); err != nil {
+			t.Errorf("conqtest: seed %d: round %d: %v", seed, round, err)
+
+			return
+		}
+	}
+}
+
+func (h *History) record(op Op) {
+	h.mut.Lock()
+	h.ops = append(h.ops, op)
+	h.mut.Unlock()
+}
+
+func (l *linearizer) search(state []int, left int) bool {
+	if left == 0 {
+		return true
+	}
+
+	key := l.key(state)
+	if l.seen[key] {
+		return false
+	}
+
+	l.seen[key] = true
+
+	first := int64(math.MaxInt64)
+	for i, op := range l.ops {
+		if !l.isDone(i) && op.Return < first {
+			first = op.Return
+		}
+	}
+
+	for i, op := range l.ops {
+		if l.isDone(i) || op.Call > first {
+			continue
+		}
+
+		next, ok := l.apply(state, i)
+		if !ok {
+			continue
+		}
+
+		l.done[i/64] |= 1 << uint(i%64)
+
+		if l.search(next, left-1) {
+			return true
+		}
+
+		l.done[i/64] &^= 1 << uint(i%64)
+	}
+
+	return false
+}
+
+func (l *linearizer) apply(state []int, i int) ([]int, bool) {
+	op := l.ops[i]
+
+	if !op.Dequeue {
+		return append(state[:len(state):len(state)], i), true
+	}
+
+	if len(state) == 0 {
+		return state, op.Item == nil
+	}
+
+	return state[1:], op.Item == l.ops[state[0]].Item
+}
+
+func (l *linearizer) isDone(i int) bool {
+	return l.done[i/64]&(1<<uint(i%64)) != 0
+}
+
+func (l *linearizer) key(state []int) string {
+	var b strings.Builder
+
+	for _, word := range l.done {
+		b.WriteString(strconv.FormatUint(word, 16))
+		b.WriteByte(',')
+	}
+
+	for _, i := range state {
+		b.WriteByte(';')
+		b.WriteString(strconv.Itoa(i))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conqtest_test
+
+import (
+	"github.com/sebuckler/conq"
+	"github.com/sebuckler/conq/conqtest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type lockedLIFO struct {
+	lifo
+	mut sync.Mutex
+}
+
+func TestCheckHistory(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should pass sequential history":    shouldPassSequentialHistory,
+		"should pass overlapping enqueues":  shouldPassOverlappingEnqueues,
+		"should fail reordered dequeues":    shouldFailReorderedDequeues,
+		"should fail empty dequeue":         shouldFailEmptyDequeue,
+		"should ignore failed enqueues":     shouldIgnoreFailedEnqueues,
+		"should fail duplicate dequeue":     shouldFailDuplicateDequeue,
+		"should pass dequeue of concurrent": shouldPassDequeueOfConcurrent,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestHistory_Check(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should record operations": shouldRecordOperations,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should pass linearizable queue": shouldPassLinearizableQueue,
+		"should fail lifo queue":         shouldFailLinearizableLIFO,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func (l *lockedLIFO) Dequeue() interface{} {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.lifo.Dequeue()
+}
+
+func (l *lockedLIFO) TryEnqueue(item interface{}) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.lifo.TryEnqueue(item)
+}
+
+func (l *lockedLIFO) Len() int {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.lifo.Len()
+}
+
+func enq(call, ret int64, item interface{}) conqtest.Op {
+	return conqtest.Op{Call: call, Item: item, Return: ret}
+}
+
+func deq(call, ret int64, item interface{}) conqtest.Op {
+	return conqtest.Op{Call: call, Dequeue: true, Item: item, Return: ret}
+}
+
+func shouldPassSequentialHistory(t *testing.T, name string) {
+	ops := []conqtest.Op{enq(1, 2, 1), enq(3, 4, 2), deq(5, 6, 1), deq(7, 8, 2), deq(9, 10, nil)}
+
+	if err := conqtest.CheckHistory(ops); err != nil {
+		t.Fail()
+		t.Logf("%s: did not pass sequential history: %v", name, err)
+	}
+}
+
+func shouldPassOverlappingEnqueues(t *testing.T, name string) {
+	ops := []conqtest.Op{enq(1, 4, 1), enq(2, 3, 2), deq(5, 6, 2), deq(7, 8, 1)}
+
+	if err := conqtest.CheckHistory(ops); err != nil {
+		t.Fail()
+		t.Logf("%s: did not pass overlapping enqueues: %v", name, err)
+	}
+}
+
+func shouldFailReorderedDequeues(t *testing.T, name string) {
+	ops := []conqtest.Op{enq(1, 2, 1), enq(3, 4, 2), deq(5, 6, 2), deq(7, 8, 1)}
+
+	if err := conqtest.CheckHistory(ops); err == nil {
+		t.Fail()
+		t.Logf("%s: did not fail reordered dequeues", name)
+	}
+}
+
+func shouldFailEmptyDequeue(t *testing.T, name string) {
+	ops := []conqtest.Op{enq(1, 2, 1), deq(3, 4, nil)}
+
+	if err := conqtest.CheckHistory(ops); err == nil || !strings.Contains(err.Error(), "not linearizable") {
+		t.Fail()
+		t.Logf("%s: did not fail empty dequeue: %v", name, err)
+	}
+}
+
+func shouldIgnoreFailedEnqueues(t *testing.T, name string) {
+	failed := enq(1, 2, 1)
+	failed.Err = conq.ErrClosed
+	ops := []conqtest.Op{failed, deq(3, 4, nil)}
+
+	if err := conqtest.CheckHistory(ops); err != nil {
+		t.Fail()
+		t.Logf("%s: did not ignore failed enqueue: %v", name, err)
+	}
+}
+
+func shouldFailDuplicateDequeue(t *testing.T, name string) {
+	ops := []conqtest.Op{enq(1, 2, 1), deq(3, 6, 1), deq(4, 5, 1)}
+
+	if err := conqtest.CheckHistory(ops); err == nil {
+		t.Fail()
+		t.Logf("%s: did not fail duplicate dequeue", name)
+	}
+}
+
+func shouldPassDequeueOfConcurrent(t *testing.T, name string) {
+	ops := []conqtest.Op{deq(1, 4, 1), enq(2, 3, 1), deq(5, 6, nil)}
+
+	if err := conqtest.CheckHistory(ops); err != nil {
+		t.Fail()
+		t.Logf("%s: did not pass dequeue of concurrent enqueue: %v", name, err)
+	}
+}
+
+func shouldRecordOperations(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	h := &conqtest.History{}
+
+	h.Enqueue(queue, 1)
+	got := h.Dequeue(queue)
+	ops := h.Ops()
+
+	if got != 1 || len(ops) != 2 || !ops[1].Dequeue || ops[1].Item != 1 || ops[0].Return >= ops[1].Call || h.Check() != nil {
+		t.Fail()
+		t.Logf("%s: did not record operations %+v", name, ops)
+	}
+}
+
+func shouldPassLinearizableQueue(t *testing.T, name string) {
+	for _, newQueue := range []func() conq.Interface{
+		func() conq.Interface { return &conq.Queue{Capacity: 2} },
+		func() conq.Interface { return &conqtest.Model{} },
+	} {
+		r := &recorder{TB: t}
+
+		conqtest.CheckLinearizable(r, 1, newQueue)
+
+		if len(r.errors) != 0 {
+			t.Fail()
+			t.Logf("%s: did not pass linearizable queue: %v", name, r.errors)
+		}
+	}
+}
+
+func shouldFailLinearizableLIFO(t *testing.T, name string) {
+	r := &recorder{TB: t}
+
+	conqtest.CheckLinearizable(r, 1, func() conq.Interface {
+		return &lockedLIFO{}
+	})
+
+	if len(r.errors) == 0 || !strings.HasPrefix(r.errors[0], "conqtest: seed 1: round ") {
+		t.Fail()
+		t.Logf("%s: did not fail lifo queue: %v", name, r.errors)
+	}
+}
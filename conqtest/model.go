@@ -0,0 +1,242 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+/*
+Package conqtest has helpers for testing queues that implement conq.Interface,
+like wrappers of conq queues or other queue implementations. It is to conq what
+httptest is to net/http.
+
+CheckAgainstModel runs random operations against a queue and checks that it
+behaves like Model, a simple FIFO queue that is obviously correct.
+CheckLinearizable records the operations of concurrent goroutines in a History
+and checks that the queue is linearizable, so each operation appears to happen
+at one instant, in an order a FIFO queue could have produced. StressTest runs
+producers and consumers on a queue for a while, checks that no item was lost
+or duplicated and that the heap stayed bounded, and reports the throughput.
+
+Example code:
+
+	func TestMyQueue(t *testing.T) {
+		conqtest.CheckAgainstModel(t, 1, func() conq.Interface {
+			return NewMyQueue()
+		})
+	}
+*/
+package conqtest
+
+import (
+	"fmt"
+	"github.com/sebuckler/conq"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const (
+	modelGoroutines = 4
+	modelOps        = 1000
+)
+
+/*
+Model is a FIFO queue backed by a slice and locked by a mutex. It is slow but
+obviously correct, so it is the reference queue that CheckAgainstModel compares
+queues against.
+*/
+type Model struct {
+	items []interface{}
+	mut   sync.Mutex
+}
+
+type modelItem struct {
+	goroutine int
+	seq       int
+}
+
+/*
+Dequeue removes and returns the first item, or nil if the model is empty.
+*/
+func (m *Model) Dequeue() interface{} {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if len(m.items) == 0 {
+		return nil
+	}
+
+	item := m.items[0]
+	m.items[0] = nil
+	m.items = m.items[1:]
+
+	return item
+}
+
+/*
+TryEnqueue adds an item after the last item, and never returns an error.
+*/
+func (m *Model) TryEnqueue(item interface{}) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.items = append(m.items, item)
+
+	return nil
+}
+
+/*
+Len returns how many items are in the model.
+*/
+func (m *Model) Len() int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	return len(m.items)
+}
+
+/*
+CheckAgainstModel checks that the queues created by newQueue behave like Model.
+It first runs a random sequence of operations on a new queue and a Model, and
+fails the test at the first result that differs. If none differs, it runs random
+operations on a new queue from several goroutines at once, drains it, and fails
+the test if an item was lost or dequeued twice, or if the items of one goroutine
+were dequeued out of order. The operations are chosen by a source seeded with
+seed, which is logged with every failure so it can be reproduced. The queues
+must not be bounded, as Model never rejects an item.
+*/
+func CheckAgainstModel(t testing.TB, seed int64, newQueue func() conq.Interface) {
+	t.Helper()
+
+	if checkSequential(t, seed, newQueue()) {
+		checkConcurrent(t, seed, newQueue())
+	}
+}
+
+func checkSequential(t testing.TB, seed int64, queue conq.Interface) bool {
+	t.Helper()
+
+	r := rand.New(rand.NewSource(seed))
+	model := &Model{}
+
+	for op := 0; op < modelOps; op++ {
+		var got, want interface{}
+		var name string
+
+		switch r.Intn(5) {
+		case 0, 1:
+			name = "TryEnqueue"
+			got, want = queue.TryEnqueue(op), model.TryEnqueue(op)
+		case 2, 3:
+			name = "Dequeue"
+			got, want = queue.Dequeue(), model.Dequeue()
+		default:
+			name = "Len"
+			got, want = queue.Len(), model.Len()
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("conqtest: seed %d: op %d: %s returned %v, want %v", seed, op, name, got, want)
+
+			return false
+		}
+	}
+
+	return true
+}
+
+func checkConcurrent(t testing.TB, seed int64, queue conq.Interface) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	enqueued := make([]int, modelGoroutines)
+	dequeued := make([][]interface{}, modelGoroutines+1)
+	errs := make([]error, modelGoroutines)
+
+	for g := 0; g < modelGoroutines; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			r := rand.New(rand.NewSource(seed + int64(g) + 1))
+
+			for op := 0; op < modelOps; op++ {
+				if r.Intn(2) == 0 {
+					if err := queue.TryEnqueue(modelItem{goroutine: g, seq: enqueued[g]}); err != nil {
+						errs[g] = err
+
+						return
+					}
+
+					enqueued[g] += 1
+				} else if item := queue.Dequeue(); item != nil {
+					dequeued[g] = append(dequeued[g], item)
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("conqtest: seed %d: TryEnqueue returned %v", seed, err)
+
+			return
+		}
+	}
+
+	for item := queue.Dequeue(); item != nil; item = queue.Dequeue() {
+		dequeued[modelGoroutines] = append(dequeued[modelGoroutines], item)
+	}
+
+	if err := checkDequeued(enqueued, dequeued); err != nil {
+		t.Errorf("conqtest: seed %d: %v", seed, err)
+	}
+
+	if n := queue.Len(); n != 0 {
+		t.Errorf("conqtest: seed %d: Len returned %d after the queue was drained, want 0", seed, n)
+	}
+}
+
+func checkDequeued(enqueued []int, dequeued [][]interface{}) error {
+	seen := make([][]bool, len(enqueued))
+	for g, n := range enqueued {
+		seen[g] = make([]bool, n)
+	}
+
+	for _, items := range dequeued {
+		last := make([]int, len(enqueued))
+		for g := range last {
+			last[g] = -1
+		}
+
+		for _, val := range items {
+			item, ok := val.(modelItem)
+			if !ok || item.goroutine < 0 || item.goroutine >= len(enqueued) || item.seq >= enqueued[item.goroutine] {
+				return fmt.Errorf("dequeued %v, which was never enqueued", val)
+			}
+
+			if seen[item.goroutine][item.seq] {
+				return fmt.Errorf("dequeued item %d of goroutine %d twice", item.seq, item.goroutine)
+			}
+
+			if item.seq < last[item.goroutine] {
+				return fmt.Errorf("dequeued item %d of goroutine %d after item %d", item.seq, item.goroutine, last[item.goroutine])
+			}
+
+			seen[item.goroutine][item.seq] = true
+			last[item.goroutine] = item.seq
+		}
+	}
+
+	for g, items := range seen {
+		for seq, ok := range items {
+			if !ok {
+				return fmt.Errorf("lost item %d of goroutine %d", seq, g)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conqtest_test
+
+import (
+	"fmt"
+	"github.com/sebuckler/conq"
+	"github.com/sebuckler/conq/conqtest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recorder struct {
+	testing.TB
+	errors []string
+	mut    sync.Mutex
+}
+
+type lifo struct {
+	conqtest.Model
+	items []interface{}
+}
+
+type lossy struct {
+	conq.Queue
+	n   int
+	mut sync.Mutex
+}
+
+func TestCheckAgainstModel(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should pass queue":         shouldPassQueue,
+		"should pass model":         shouldPassModel,
+		"should fail lifo queue":    shouldFailLIFO,
+		"should fail lossy queue":   shouldFailLossy,
+		"should log seed of a fail": shouldLogSeed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.mut.Lock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+	r.mut.Unlock()
+}
+
+func (l *lifo) Dequeue() interface{} {
+	if len(l.items) == 0 {
+		return nil
+	}
+
+	item := l.items[len(l.items)-1]
+	l.items = l.items[:len(l.items)-1]
+
+	return item
+}
+
+func (l *lifo) TryEnqueue(item interface{}) error {
+	l.items = append(l.items, item)
+
+	return nil
+}
+
+func (l *lifo) Len() int {
+	return len(l.items)
+}
+
+func (l *lossy) TryEnqueue(item interface{}) error {
+	l.mut.Lock()
+	l.n += 1
+	drop := l.n%100 == 0
+	l.mut.Unlock()
+
+	if drop {
+		return nil
+	}
+
+	return l.Queue.TryEnqueue(item)
+}
+
+func shouldPassQueue(t *testing.T, name string) {
+	r := &recorder{TB: t}
+
+	conqtest.CheckAgainstModel(r, 1, func() conq.Interface {
+		return &conq.Queue{Capacity: 4}
+	})
+
+	if len(r.errors) != 0 {
+		t.Fail()
+		t.Logf("%s: did not pass queue: %v", name, r.errors)
+	}
+}
+
+func shouldPassModel(t *testing.T, name string) {
+	r := &recorder{TB: t}
+
+	conqtest.CheckAgainstModel(r, 2, func() conq.Interface {
+		return &conqtest.Model{}
+	})
+
+	if len(r.errors) != 0 {
+		t.Fail()
+		t.Logf("%s: did not pass model: %v", name, r.errors)
+	}
+}
+
+func shouldFailLIFO(t *testing.T, name string) {
+	r := &recorder{TB: t}
+
+	conqtest.CheckAgainstModel(r, 3, func() conq.Interface {
+		return &lifo{}
+	})
+
+	if len(r.errors) == 0 || !strings.Contains(r.errors[0], "Dequeue returned") {
+		t.Fail()
+		t.Logf("%s: did not fail lifo queue: %v", name, r.errors)
+	}
+}
+
+func shouldFailLossy(t *testing.T, name string) {
+	r := &recorder{TB: t}
+
+	conqtest.CheckAgainstModel(r, 4, func() conq.Interface {
+		return &lossy{}
+	})
+
+	if len(r.errors) == 0 {
+		t.Fail()
+		t.Logf("%s: did not fail lossy queue", name)
+	}
+}
+
+func shouldLogSeed(t *testing.T, name string) {
+	r := &recorder{TB: t}
+
+	conqtest.CheckAgainstModel(r, 42, func() conq.Interface {
+		return &lifo{}
+	})
+
+	if len(r.errors) == 0 || !strings.HasPrefix(r.errors[0], "conqtest: seed 42: ") {
+		t.Fail()
+		t.Logf("%s: did not log seed: %v", name, r.errors)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conqtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/sebuckler/conq"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const heapSampleInterval = 10 * time.Millisecond
+
+/*
+StressConfig is the load of a StressTest. Producers enqueue items as fast as
+they can for Duration, while Consumers dequeue them, and the consumers then
+drain the queue. MaxLen makes the producers wait while the queue is that long,
+so the memory of the queue stays bounded when the consumers are slower than
+the producers.
+*/
+type StressConfig struct {
+	Consumers    int            // goroutines that dequeue items, defaults to 4
+	Duration     time.Duration  // how long the producers enqueue items, defaults to 1s
+	MaxHeapBytes uint64         // most bytes of heap while the test runs, unchecked when 0
+	MaxLen       int            // len of the queue that producers wait below, unlimited when 0
+	Producers    int            // goroutines that enqueue items, defaults to 4
+	Queue        conq.Interface // queue under test, defaults to a new conq.Queue
+}
+
+/*
+StressResult is what a StressTest measured.
+*/
+type StressResult struct {
+	Dequeued     uint64        // items dequeued
+	Duration     time.Duration // time from the start until the queue was drained
+	Enqueued     uint64        // items enqueued
+	MaxHeapBytes uint64        // most bytes of heap sampled while the test ran
+	Throughput   float64       // items dequeued per second
+}
+
+type stressItem struct {
+	producer int
+	seq      int
+}
+
+type stressConsumer struct {
+	dequeued uint64
+	err      error
+	last     []int
+	seen     [][]uint64
+}
+
+/*
+StressTest runs the load of the config on a queue, to validate a queue on the
+target hardware and report its throughput. The producers stop when Duration
+has elapsed or the context is done. Enqueues that return an error are retried,
+so a queue that rejects items slows the producers down. StressTest returns an
+error if an item was lost or dequeued twice, if one consumer dequeued the items
+of one producer out of order, or if the heap grew larger than MaxHeapBytes.
+The heap includes what the consumers use to track the items they dequeued,
+which is 1 bit per item.
+*/
+func StressTest(ctx context.Context, cfg StressConfig) (StressResult, error) {
+	producers, consumers, duration := cfg.Producers, cfg.Consumers, cfg.Duration
+	if producers <= 0 {
+		producers = 4
+	}
+
+	if consumers <= 0 {
+		consumers = 4
+	}
+
+	if duration <= 0 {
+		duration = time.Second
+	}
+
+	queue := cfg.Queue
+	if queue == nil {
+		queue = &conq.Queue{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var maxHeap uint64
+	sampled := make(chan struct{})
+	stopSampling := make(chan struct{})
+
+	go func() {
+		defer close(sampled)
+
+		ticker := time.NewTicker(heapSampleInterval)
+		defer ticker.Stop()
+
+		for {
+			if heap := heapBytes(); heap > maxHeap {
+				maxHeap = heap
+			}
+
+			select {
+			case <-stopSampling:
+				return
+			case <-ticker.C:
+				break
+			}
+		}
+	}()
+
+	start := time.Now()
+	enqueued := make([]int, producers)
+	producing := int32(producers)
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+
+		go func(p int) {
+			defer wg.Done()
+			defer atomic.AddInt32(&producing, -1)
+
+			for ctx.Err() == nil {
+				if cfg.MaxLen > 0 && queue.Len() >= cfg.MaxLen {
+					runtime.Gosched()
+
+					continue
+				}
+
+				if queue.TryEnqueue(stressItem{producer: p, seq: enqueued[p]}) != nil {
+					runtime.Gosched()
+
+					continue
+				}
+
+				enqueued[p] += 1
+			}
+		}(p)
+	}
+
+	stats := make([]*stressConsumer, consumers)
+	for c := range stats {
+		stats[c] = &stressConsumer{last: make([]int, producers), seen: make([][]uint64, producers)}
+		wg.Add(1)
+
+		go func(s *stressConsumer) {
+			defer wg.Done()
+
+			for {
+				done := atomic.LoadInt32(&producing) == 0
+
+				val := queue.Dequeue()
+				if val == nil {
+					if done {
+						return
+					}
+
+					runtime.Gosched()
+
+					continue
+				}
+
+				s.observe(val)
+			}
+		}(stats[c])
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(stopSampling)
+	<-sampled
+
+	result := StressResult{Duration: elapsed, MaxHeapBytes: maxHeap}
+	for _, n := range enqueued {
+		result.Enqueued += uint64(n)
+	}
+
+	err := checkStress(enqueued, stats, &result)
+	if result.Duration > 0 {
+		result.Throughput = float64(result.Dequeued) / result.Duration.Seconds()
+	}
+
+	if err == nil && cfg.MaxHeapBytes > 0 && maxHeap > cfg.MaxHeapBytes {
+		err = fmt.Errorf("conqtest: heap grew to %d bytes, more than %d", maxHeap, cfg.MaxHeapBytes)
+	}
+
+	return result, err
+}
+
+func (s *stressConsumer) observe(val interface{}) {
+	s.dequeued += 1
+
+	item, ok := val.(stressItem)
+	if !ok || item.producer < 0 || item.producer >= len(s.seen) {
+		if s.err == nil {
+			s.err = fmt.Errorf("conqtest: dequeued %v, which was never enqueued", val)
+		}
+
+		return
+	}
+
+	if item.seq < s.last[item.producer] && s.err == nil {
+		s.err = fmt.Errorf("conqtest: dequeued item %d of producer %d after item %d", item.seq, item.producer, s.last[item.producer])
+	}
+
+	s.last[item.producer] = item.seq
+
+	seen := s.seen[item.producer]
+	for len(seen) <= item.seq/64 {
+		seen = append(seen, 0)
+	}
+
+	if seen[item.seq/64]&(1<<uint(item.seq%64)) != 0 && s.err == nil {
+		s.err = fmt.Errorf("conqtest: dequeued item %d of producer %d twice", item.seq, item.producer)
+	}
+
+	seen[item.seq/64] |= 1 << uint(item.seq%64)
+	s.seen[item.producer] = seen
+}
+
+func checkStress(enqueued []int, consumers []*stressConsumer, result *StressResult) error {
+	for _, s := range consumers {
+		result.Dequeued += s.dequeued
+	}
+
+	for _, s := range consumers {
+		if s.err != nil {
+			return s.err
+		}
+	}
+
+	for p, n := range enqueued {
+		words := make([]uint64, (n+63)/64)
+
+		for _, s := range consumers {
+			for i, word := range s.seen[p] {
+				if i >= len(words) {
+					if word != 0 {
+						return fmt.Errorf("conqtest: dequeued items of producer %d that were never enqueued", p)
+					}
+
+					continue
+				}
+
+				if words[i]&word != 0 {
+					return fmt.Errorf("conqtest: dequeued items of producer %d twice", p)
+				}
+
+				words[i] |= word
+			}
+		}
+
+		if n%64 != 0 && words[len(words)-1]>>uint(n%64) != 0 {
+			return fmt.Errorf("conqtest: dequeued items of producer %d that were never enqueued", p)
+		}
+
+		for seq := 0; seq < n; seq++ {
+			if words[seq/64]&(1<<uint(seq%64)) == 0 {
+				return fmt.Errorf("conqtest: lost item %d of producer %d", seq, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return sample[0].Value.Uint64()
+}
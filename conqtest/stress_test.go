@@ -0,0 +1,99 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conqtest_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"github.com/sebuckler/conq/conqtest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStressTest(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should pass queue under load":  shouldPassQueueUnderLoad,
+		"should fail lossy queue":       shouldFailLossyUnderLoad,
+		"should fail heap over limit":   shouldFailHeapOverLimit,
+		"should bound len of the queue": shouldBoundLen,
+		"should stop when context done": shouldStopWhenContextDone,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+type maxLenQueue struct {
+	conq.Queue
+	max int
+}
+
+func (q *maxLenQueue) TryEnqueue(item interface{}) error {
+	err := q.Queue.TryEnqueue(item)
+
+	if n := q.Queue.Len(); n > q.max {
+		q.max = n
+	}
+
+	return err
+}
+
+func shouldPassQueueUnderLoad(t *testing.T, name string) {
+	result, err := conqtest.StressTest(context.Background(), conqtest.StressConfig{Duration: 50 * time.Millisecond})
+
+	if err != nil || result.Enqueued == 0 || result.Dequeued != result.Enqueued || result.Throughput <= 0 || result.MaxHeapBytes == 0 {
+		t.Fail()
+		t.Logf("%s: did not pass queue under load %+v: %v", name, result, err)
+	}
+}
+
+func shouldFailLossyUnderLoad(t *testing.T, name string) {
+	result, err := conqtest.StressTest(context.Background(), conqtest.StressConfig{Duration: 50 * time.Millisecond, Queue: &lossy{}})
+
+	if err == nil || !strings.HasPrefix(err.Error(), "conqtest: lost item") || result.Dequeued >= result.Enqueued {
+		t.Fail()
+		t.Logf("%s: did not fail lossy queue %+v: %v", name, result, err)
+	}
+}
+
+func shouldFailHeapOverLimit(t *testing.T, name string) {
+	_, err := conqtest.StressTest(context.Background(), conqtest.StressConfig{Duration: 20 * time.Millisecond, MaxHeapBytes: 1})
+
+	if err == nil || !strings.HasPrefix(err.Error(), "conqtest: heap grew") {
+		t.Fail()
+		t.Logf("%s: did not fail heap over limit: %v", name, err)
+	}
+}
+
+func shouldBoundLen(t *testing.T, name string) {
+	queue := &maxLenQueue{}
+
+	result, err := conqtest.StressTest(context.Background(), conqtest.StressConfig{
+		Consumers: 1,
+		Duration:  50 * time.Millisecond,
+		MaxLen:    100,
+		Producers: 1,
+		Queue:     queue,
+	})
+
+	if err != nil || result.Enqueued == 0 || queue.max > 100 {
+		t.Fail()
+		t.Logf("%s: did not bound len to 100, got %d: %v", name, queue.max, err)
+	}
+}
+
+func shouldStopWhenContextDone(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := conqtest.StressTest(ctx, conqtest.StressConfig{Duration: time.Hour})
+
+	if err != nil || result.Duration > time.Second || result.Enqueued != 0 {
+		t.Fail()
+		t.Logf("%s: did not stop when context done %+v: %v", name, result, err)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+/*
+EnqueueControl adds an item to the control lane of the queue, for in-band
+commands to workers like flush or reconfigure without a second queue. Control
+items are dequeued before every data item, in the order they were enqueued,
+by Dequeue, DequeueBlocking, DequeueGroup, and the Dispatcher, Processor,
+Reader, and RESPServer of the queue. They count toward Len, but they are not
+data: they are never removed, watched, mirrored, handed off, merged, limited
+by a Group or MaxHeapBytes, or visited by Range and the other methods that
+inspect the items. Control items can be enqueued after Close, so workers can
+still be told to flush while the queue drains. EnqueueControl locks the queue.
+*/
+func (q *Queue) EnqueueControl(item interface{}) {
+	q.lock()
+	q.control = append(q.control, item)
+	q.enqueued += 1
+	q.notify()
+	q.unlock()
+}
+
+func (q *Queue) next() (interface{}, bool) {
+	if len(q.control) == 0 {
+		q.observeAge()
+		val, ok := q.dequeue()
+		if ok && q.shadow != nil && val != ErrClosed {
+			q.shadow.record(val)
+		}
+
+		return val, ok
+	}
+
+	val := q.control[0]
+	q.control[0] = nil
+	q.control = q.control[1:]
+	q.dequeued += 1
+
+	if len(q.control) == 0 {
+		q.control = nil
+	}
+
+	return val, true
+}
+
+func (q *Queue) pending() int {
+	return q.len + len(q.control)
+}
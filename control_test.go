@@ -0,0 +1,109 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueue_EnqueueControl(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should dequeue control before data":  shouldDequeueControlFirst,
+		"should count control items in len":   shouldCountControlInLen,
+		"should wake blocking dequeue":        shouldWakeBlockingForControl,
+		"should return control item alone":    shouldGroupControlAlone,
+		"should enqueue control after close":  shouldEnqueueControlAfterClose,
+		"should not range over control items": shouldNotRangeControl,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldDequeueControlFirst(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	queue.EnqueueControl("flush")
+	queue.EnqueueControl("reload")
+
+	var got []interface{}
+	for item := queue.Dequeue(); item != nil; item = queue.Dequeue() {
+		got = append(got, item)
+	}
+
+	if !reflect.DeepEqual(got, []interface{}{"flush", "reload", 1, 2}) {
+		t.Fail()
+		t.Logf("%s: dequeued %v", name, got)
+	}
+}
+
+func shouldCountControlInLen(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	queue.EnqueueControl("flush")
+	stats := queue.Stats()
+
+	if queue.Len() != 2 || stats.Len != 2 || stats.Enqueued != 2 {
+		t.Fail()
+		t.Logf("%s: returned len %d and stats %+v", name, queue.Len(), stats)
+	}
+}
+
+func shouldWakeBlockingForControl(t *testing.T, name string) {
+	queue := &conq.Queue{}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		queue.EnqueueControl("flush")
+	}()
+
+	if item := queue.DequeueBlocking(time.Second, time.Millisecond); item != "flush" {
+		t.Fail()
+		t.Logf("%s: dequeued %v instead of control item", name, item)
+	}
+}
+
+func shouldGroupControlAlone(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue("a")
+	queue.Enqueue("a")
+	queue.EnqueueControl("a")
+
+	key := func(item interface{}) interface{} { return item }
+	first := queue.DequeueGroup(key, 10)
+	second := queue.DequeueGroup(key, 10)
+
+	if !reflect.DeepEqual(first, []interface{}{"a"}) || !reflect.DeepEqual(second, []interface{}{"a", "a"}) {
+		t.Fail()
+		t.Logf("%s: dequeued groups %v and %v", name, first, second)
+	}
+}
+
+func shouldEnqueueControlAfterClose(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Close()
+	queue.EnqueueControl("flush")
+
+	if item := queue.Dequeue(); item != "flush" {
+		t.Fail()
+		t.Logf("%s: dequeued %v instead of control item", name, item)
+	}
+}
+
+func shouldNotRangeControl(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	queue.EnqueueControl("flush")
+
+	if got := queue.Snapshot(); !reflect.DeepEqual(got, []interface{}{1}) {
+		t.Fail()
+		t.Logf("%s: snapshot %v included control items", name, got)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"errors"
+)
+
+/*
+ErrCostExceeded is returned by TryEnqueue when the items in a queue with a Cost
+would cost more than the MaxCost of the queue with the item.
+*/
+var ErrCostExceeded = errors.New("conq: queue cost exceeded")
+
+func (q *Queue) overCost(item interface{}) bool {
+	if q.MaxCost <= 0 || q.Cost == nil {
+		return false
+	}
+
+	return q.cost+q.costOf(item) > q.MaxCost
+}
+
+func (q *Queue) costOf(item interface{}) int {
+	if item == ErrClosed {
+		return 0
+	}
+
+	return q.Cost(item)
+}
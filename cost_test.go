@@ -0,0 +1,75 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"testing"
+)
+
+func TestQueue_MaxCost(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should reject items over max cost":  shouldRejectOverCost,
+		"should free cost of dequeued items": shouldFreeCostOfDequeued,
+		"should free cost of removed items":  shouldFreeCostOfRemoved,
+		"should not limit without cost":      shouldNotLimitWithoutCost,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func costOf(item interface{}) int {
+	return item.(int)
+}
+
+func shouldRejectOverCost(t *testing.T, name string) {
+	queue := &conq.Queue{Cost: costOf, MaxCost: 10}
+
+	queue.Enqueue(6)
+	queue.Enqueue(3)
+	err := queue.TryEnqueue(2)
+	stats := queue.Stats()
+
+	if err != conq.ErrCostExceeded || queue.Len() != 2 || stats.Cost != 9 || stats.Dropped != 1 || queue.TryEnqueue(1) != nil {
+		t.Fail()
+		t.Logf("%s: did not reject item over max cost: %v", name, err)
+	}
+}
+
+func shouldFreeCostOfDequeued(t *testing.T, name string) {
+	queue := &conq.Queue{Cost: costOf, MaxCost: 10}
+
+	queue.Enqueue(8)
+	queue.Dequeue()
+
+	if err := queue.TryEnqueue(9); err != nil || queue.Stats().Cost != 9 {
+		t.Fail()
+		t.Logf("%s: did not free cost of dequeued item: %v", name, err)
+	}
+}
+
+func shouldFreeCostOfRemoved(t *testing.T, name string) {
+	queue := &conq.Queue{Cost: costOf, MaxCost: 10}
+
+	queue.Enqueue(8)
+	queue.Remove(8)
+	queue.EnqueuePoison(1)
+
+	if err := queue.TryEnqueue(10); err != nil || queue.Stats().Cost != 10 {
+		t.Fail()
+		t.Logf("%s: did not free cost of removed item: %v", name, err)
+	}
+}
+
+func shouldNotLimitWithoutCost(t *testing.T, name string) {
+	queue := &conq.Queue{MaxCost: 1}
+
+	if err := queue.TryEnqueue(5); err != nil || queue.TryEnqueue(5) != nil {
+		t.Fail()
+		t.Logf("%s: limited items without a cost: %v", name, err)
+	}
+}
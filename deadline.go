@@ -0,0 +1,171 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+/*
+DeadlineQueue is a queue that dequeues items in earliest-deadline-first order
+instead of FIFO order. Each item carries a deadline, and the item with the
+nearest deadline is always dequeued first. Items with equal deadlines are
+dequeued in the order they were enqueued. Items whose deadline has already
+passed are not dropped, they are simply the most urgent.
+*/
+type DeadlineQueue struct {
+	Capacity int   // soft cap for underlying slice of items in queue
+	Clock    Clock // tells time and sleeps, defaults to the system clock
+	changed  chan struct{}
+	items    deadlineHeap
+	mut      sync.Mutex
+	seq      uint64
+}
+
+type deadlineItem struct {
+	deadline time.Time
+	item     interface{}
+	seq      uint64
+}
+
+type deadlineHeap []deadlineItem
+
+/*
+Enqueue adds a new item of any type to the queue with a deadline. Enqueue is
+O(log n), and it locks the queue while it is adding the item.
+*/
+func (d *DeadlineQueue) Enqueue(item interface{}, deadline time.Time) {
+	d.mut.Lock()
+
+	if d.items == nil {
+		d.items = make(deadlineHeap, 0, d.Capacity)
+	}
+
+	heap.Push(&d.items, deadlineItem{deadline: deadline, item: item, seq: d.seq})
+	d.seq += 1
+
+	if d.changed != nil {
+		close(d.changed)
+		d.changed = nil
+	}
+
+	d.mut.Unlock()
+}
+
+/*
+Dequeue will attempt to retrieve the item with the nearest deadline. If the
+queue is empty no item is returned and the interface{} can be asserted against
+nil. Dequeue locks the queue while it is retrieving the item.
+*/
+func (d *DeadlineQueue) Dequeue() interface{} {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if len(d.items) == 0 {
+		return nil
+	}
+
+	return heap.Pop(&d.items).(deadlineItem).item
+}
+
+/*
+DequeueBlocking will attempt to retrieve the item with the nearest deadline and
+block until there is an item in the queue. The timeout and interval work the
+same as they do for Queue.DequeueBlocking, and Enqueue wakes waiting calls.
+DequeueBlocking locks the queue during each poll, but it unlocks the queue
+between cycles to allow items to be enqueued.
+*/
+func (d *DeadlineQueue) DequeueBlocking(timeout time.Duration, interval time.Duration) interface{} {
+	clock := clockOr(d.Clock)
+	start := clock.Now()
+	d.mut.Lock()
+
+	for len(d.items) == 0 {
+		if d.changed == nil {
+			d.changed = make(chan struct{})
+		}
+
+		changed := d.changed
+		d.mut.Unlock()
+
+		waited := clock.Now().Sub(start)
+		if timeout > 0 && waited >= timeout {
+			return nil
+		}
+
+		sleep := interval
+		if timeout > 0 && (sleep <= 0 || sleep > timeout-waited) {
+			sleep = timeout - waited
+		}
+
+		pause(clock, sleep, changed, nil, nil)
+		d.mut.Lock()
+	}
+
+	val := heap.Pop(&d.items).(deadlineItem).item
+	d.mut.Unlock()
+
+	return val
+}
+
+/*
+DequeueUntil will attempt to retrieve the item with the nearest deadline and
+block until the deadline instead of for a timeout. It works the same as
+Queue.DequeueUntil, and the deadline is measured by the Clock of the queue.
+*/
+func (d *DeadlineQueue) DequeueUntil(deadline time.Time, interval time.Duration) interface{} {
+	if deadline.IsZero() {
+		return d.DequeueBlocking(0, interval)
+	}
+
+	timeout := deadline.Sub(clockOr(d.Clock).Now())
+	if timeout <= 0 {
+		return d.Dequeue()
+	}
+
+	return d.DequeueBlocking(timeout, interval)
+}
+
+/*
+Len returns how many items are enqueued. Len locks the queue.
+*/
+func (d *DeadlineQueue) Len() int {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return len(d.items)
+}
+
+func (h deadlineHeap) Len() int {
+	return len(h)
+}
+
+func (h deadlineHeap) Less(i, j int) bool {
+	if h[i].deadline.Equal(h[j].deadline) {
+		return h[i].seq < h[j].seq
+	}
+
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *deadlineHeap) Push(x interface{}) {
+	*h = append(*h, x.(deadlineItem))
+}
+
+func (h *deadlineHeap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	val := old[n]
+	old[n] = deadlineItem{}
+	*h = old[:n]
+
+	return val
+}
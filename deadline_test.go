@@ -0,0 +1,129 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+func TestDeadlineQueue_Dequeue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have items in deadline order":   shouldHaveItemsInDeadlineOrder,
+		"should have equal deadlines in order":  shouldHaveEqualDeadlinesInOrder,
+		"should be nil when no items queued":    shouldDequeueNilDeadline,
+		"should block until items queued":       shouldBlockUntilDeadlineItems,
+		"should be nil when blocking times out": shouldDequeueNilDeadlineBlocking,
+		"should publish writes before enqueue":  shouldPublishDeadline,
+		"should wake when item enqueued":        shouldWakeDeadlineEnqueued,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldHaveItemsInDeadlineOrder(t *testing.T, name string) {
+	queue := &conq.DeadlineQueue{Capacity: 3}
+	now := time.Now()
+	var actual []int
+
+	queue.Enqueue(3, now.Add(time.Hour))
+	queue.Enqueue(1, now.Add(-time.Second))
+	queue.Enqueue(2, now.Add(time.Minute))
+
+	actual = append(actual, queue.Dequeue().(int))
+	actual = append(actual, queue.Dequeue().(int))
+	actual = append(actual, queue.Dequeue().(int))
+
+	if actual[0] != 1 || actual[1] != 2 || actual[2] != 3 || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not have items in deadline order %v", name, actual)
+	}
+}
+
+func shouldHaveEqualDeadlinesInOrder(t *testing.T, name string) {
+	queue := &conq.DeadlineQueue{Capacity: 3}
+	deadline := time.Now().Add(time.Minute)
+	var actual []int
+
+	queue.Enqueue(1, deadline)
+	queue.Enqueue(2, deadline)
+	queue.Enqueue(3, deadline)
+
+	actual = append(actual, queue.Dequeue().(int))
+	actual = append(actual, queue.Dequeue().(int))
+	actual = append(actual, queue.Dequeue().(int))
+
+	if actual[0] != 1 || actual[1] != 2 || actual[2] != 3 {
+		t.Fail()
+		t.Logf("%s: did not have items in enqueue order %v", name, actual)
+	}
+}
+
+func shouldDequeueNilDeadline(t *testing.T, name string) {
+	queue := &conq.DeadlineQueue{Capacity: 3}
+
+	if queue.Dequeue() != nil {
+		t.Fail()
+		t.Logf("%s: was not nil", name)
+	}
+}
+
+func shouldBlockUntilDeadlineItems(t *testing.T, name string) {
+	queue := &conq.DeadlineQueue{Capacity: 3}
+
+	go queue.Enqueue(1, time.Now())
+
+	if val := queue.DequeueBlocking(time.Second, 0); val != 1 {
+		t.Fail()
+		t.Logf("%s: did not have item %v", name, val)
+	}
+}
+
+func shouldDequeueNilDeadlineBlocking(t *testing.T, name string) {
+	queue := &conq.DeadlineQueue{Capacity: 3}
+
+	if queue.DequeueBlocking(time.Microsecond, 0) != nil {
+		t.Fail()
+		t.Logf("%s: was not nil after timeout", name)
+	}
+}
+
+func shouldPublishDeadline(t *testing.T, name string) {
+	queue := &conq.DeadlineQueue{}
+	now := time.Now()
+	var seq int64
+
+	checkPublished(t, name, func(item interface{}) {
+		seq += 1
+		queue.Enqueue(item, now.Add(time.Duration(seq)))
+	}, queue.Dequeue)
+}
+
+func shouldWakeDeadlineEnqueued(t *testing.T, name string) {
+	for _, interval := range []time.Duration{0, time.Hour} {
+		sim := &conq.Sim{}
+		queue := &conq.DeadlineQueue{Clock: sim}
+		start := sim.Now()
+
+		var got interface{}
+		var waited time.Duration
+		sim.Go(func() {
+			got = queue.DequeueBlocking(0, interval)
+			waited = sim.Now().Sub(start)
+		})
+		sim.Go(func() {
+			sim.Sleep(time.Second)
+			queue.Enqueue(1, time.Time{})
+		})
+
+		if err := sim.Run(); err != nil || got != 1 || waited != time.Second {
+			t.Fail()
+			t.Logf("%s: dequeued %v after %v with interval %v: %v", name, got, waited, interval, err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelLevels = (64 + wheelBits - 1) / wheelBits
+)
+
+/*
+DelayQueue is a queue of items that can only be dequeued after a delay has
+elapsed. Delayed items are held in a hierarchical timing wheel instead of a
+heap, so enqueuing stays O(1) no matter how many items are pending. Each level
+of the wheel has 64 slots, and one slot of a level spans all 64 slots of the
+level below it. As time advances, items cascade down the levels until they
+expire and are moved into a FIFO queue of ready items.
+
+The wheel is advanced lazily by the methods of the queue, so no goroutine is
+started. Granularity is the duration of one tick of the wheel, and it must not
+be changed after the queue is first used.
+*/
+type DelayQueue struct {
+	Capacity    int           // soft cap for underlying slice of ready items
+	Clock       Clock         // tells time and sleeps, defaults to the system clock
+	Granularity time.Duration // duration of one tick of the wheel, defaults to 1ms
+	changed     chan struct{}
+	delayed     int
+	masks       [wheelLevels]uint64
+	mut         sync.Mutex
+	ready       Queue
+	slots       [wheelLevels][wheelSlots][]delayedItem
+	start       time.Time
+	tick        uint64
+}
+
+type delayedItem struct {
+	expires uint64
+	item    interface{}
+}
+
+/*
+Enqueue adds a new item of any type to the queue that will be ready after the
+delay. The delay is rounded up to the next tick of the wheel, so an item is
+never ready early. If the delay is not greater than 0, the item is ready
+immediately. Enqueue locks the queue while it is adding the item.
+*/
+func (d *DelayQueue) Enqueue(item interface{}, delay time.Duration) {
+	d.mut.Lock()
+
+	now := clockOr(d.Clock).Now()
+	d.advance(now)
+
+	expires := d.tick
+	if delay > 0 {
+		g := d.granularity()
+		expires = uint64((now.Add(delay).Sub(d.start) + g - 1) / g)
+	}
+
+	d.schedule(delayedItem{expires: expires, item: item})
+
+	if d.changed != nil {
+		close(d.changed)
+		d.changed = nil
+	}
+
+	d.mut.Unlock()
+}
+
+/*
+Dequeue will attempt to retrieve a ready item from the queue. If no item is
+ready no item is returned and the interface{} can be asserted against nil.
+Dequeue locks the queue while it is retrieving the item.
+*/
+func (d *DelayQueue) Dequeue() interface{} {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.advance(clockOr(d.Clock).Now())
+
+	if val, ok := d.ready.dequeue(); ok {
+		return val
+	}
+
+	return nil
+}
+
+/*
+DequeueBlocking will attempt to retrieve a ready item from the queue and block
+until an item is ready. The timeout and interval work the same as they do for
+Queue.DequeueBlocking, except that a poll cycle sleeps only until the next
+delayed item is ready or the timeout elapses when that is sooner than the
+interval, so a long interval does not make items late. If the interval is not
+greater than 0, a poll cycle sleeps until one of them. Enqueue wakes waiting
+calls, so an item that is ready sooner is not late either. DequeueBlocking
+locks the queue during each poll, but it unlocks the queue between cycles to
+allow items to be enqueued.
+*/
+func (d *DelayQueue) DequeueBlocking(timeout time.Duration, interval time.Duration) interface{} {
+	clock := clockOr(d.Clock)
+	start := clock.Now()
+	d.mut.Lock()
+	d.advance(clock.Now())
+
+	for d.ready.len == 0 {
+		now := clock.Now()
+		wait := interval
+
+		if ready, ok := d.nextReady(); ok && (wait <= 0 || ready.Sub(now) < wait) {
+			wait = ready.Sub(now)
+		}
+
+		if d.changed == nil {
+			d.changed = make(chan struct{})
+		}
+
+		changed := d.changed
+		d.mut.Unlock()
+
+		if timeout > 0 && now.Sub(start) >= timeout {
+			return nil
+		}
+
+		if left := timeout - now.Sub(start); timeout > 0 && (wait <= 0 || left < wait) {
+			wait = left
+		}
+
+		pause(clock, wait, changed, nil, nil)
+		d.mut.Lock()
+		d.advance(clock.Now())
+	}
+
+	val, _ := d.ready.dequeue()
+	d.mut.Unlock()
+
+	return val
+}
+
+/*
+DequeueUntil will attempt to retrieve a ready item and block until the deadline
+instead of for a timeout. It works the same as Queue.DequeueUntil, and the
+deadline is measured by the Clock of the queue.
+*/
+func (d *DelayQueue) DequeueUntil(deadline time.Time, interval time.Duration) interface{} {
+	if deadline.IsZero() {
+		return d.DequeueBlocking(0, interval)
+	}
+
+	timeout := deadline.Sub(clockOr(d.Clock).Now())
+	if timeout <= 0 {
+		return d.Dequeue()
+	}
+
+	return d.DequeueBlocking(timeout, interval)
+}
+
+/*
+Len returns how many items are enqueued, including items that are not ready
+yet. Len locks the queue.
+*/
+func (d *DelayQueue) Len() int {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return d.ready.len + d.delayed
+}
+
+func (d *DelayQueue) advance(now time.Time) {
+	if d.start.IsZero() {
+		d.start = now
+		d.ready.Capacity = d.Capacity
+
+		return
+	}
+
+	target := uint64(now.Sub(d.start) / d.granularity())
+
+	for d.delayed > 0 {
+		next, level, ok := d.next()
+		if !ok || next > target {
+			break
+		}
+
+		d.tick = next
+		d.cascade(level)
+	}
+
+	if target > d.tick {
+		d.tick = target
+	}
+}
+
+func (d *DelayQueue) cascade(level int) {
+	slot := (d.tick >> uint(level*wheelBits)) & (wheelSlots - 1)
+	items := d.slots[level][slot]
+
+	d.masks[level] &^= 1 << slot
+	d.delayed -= len(items)
+
+	for i, item := range items {
+		d.schedule(item)
+		items[i] = delayedItem{}
+	}
+
+	d.slots[level][slot] = items[:0]
+}
+
+func (d *DelayQueue) granularity() time.Duration {
+	if d.Granularity <= 0 {
+		return time.Millisecond
+	}
+
+	return d.Granularity
+}
+
+func (d *DelayQueue) next() (uint64, int, bool) {
+	for level := 0; level < wheelLevels; level++ {
+		shift := uint(level * wheelBits)
+		current := (d.tick >> shift) & (wheelSlots - 1)
+		pending := d.masks[level] &^ (2<<current - 1)
+
+		if pending == 0 {
+			continue
+		}
+
+		slot := uint64(bits.TrailingZeros64(pending))
+		epoch := d.tick >> (shift + wheelBits) << (shift + wheelBits)
+
+		return epoch | slot<<shift, level, true
+	}
+
+	return 0, 0, false
+}
+
+func (d *DelayQueue) nextReady() (time.Time, bool) {
+	next, level, ok := d.next()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	if level > 0 {
+		slot := (next >> uint(level*wheelBits)) & (wheelSlots - 1)
+
+		earliest := uint64(math.MaxUint64)
+
+		for _, item := range d.slots[level][slot] {
+			if item.expires < earliest {
+				earliest = item.expires
+			}
+		}
+
+		next = earliest
+	}
+
+	return d.start.Add(time.Duration(next) * d.granularity()), true
+}
+
+func (d *DelayQueue) schedule(item delayedItem) {
+	if item.expires <= d.tick {
+		d.ready.enqueue(item.item)
+
+		return
+	}
+
+	level := (bits.Len64(item.expires^d.tick) - 1) / wheelBits
+	slot := (item.expires >> uint(level*wheelBits)) & (wheelSlots - 1)
+
+	d.slots[level][slot] = append(d.slots[level][slot], item)
+	d.masks[level] |= 1 << slot
+	d.delayed += 1
+}
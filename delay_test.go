@@ -0,0 +1,207 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+func TestDelayQueue_Enqueue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should grow queue len with delayed items": shouldGrowDelayQueue,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestDelayQueue_Dequeue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have items without delay":      shouldHaveUndelayedItems,
+		"should be nil when items are delayed": shouldDequeueNilDelayed,
+		"should publish writes before enqueue": shouldPublishDelayed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestDelayQueue_DequeueBlocking(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have items in expiry order":    shouldHaveItemsInExpiryOrder,
+		"should block until delay has passed":  shouldBlockUntilDelayPassed,
+		"should be nil when blocking timeout":  shouldDequeueNilDelayedBlocking,
+		"should wake when next item is ready":  shouldWakeWhenDelayedReady,
+		"should wake when ready item enqueued": shouldWakeWhenReadyEnqueued,
+		"should wake at timeout":               shouldWakeDelayedAtTimeout,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldGrowDelayQueue(t *testing.T, name string) {
+	queue := &conq.DelayQueue{Capacity: 3}
+
+	queue.Enqueue(1, 0)
+	queue.Enqueue(2, time.Hour)
+	queue.Enqueue(3, 24*time.Hour)
+
+	if queue.Len() != 3 {
+		t.Fail()
+		t.Logf("%s: did not have correct len", name)
+	}
+}
+
+func shouldHaveUndelayedItems(t *testing.T, name string) {
+	queue := &conq.DelayQueue{Capacity: 3}
+	var actual []int
+
+	queue.Enqueue(1, 0)
+	queue.Enqueue(2, 0)
+	queue.Enqueue(3, -time.Second)
+
+	actual = append(actual, queue.Dequeue().(int))
+	actual = append(actual, queue.Dequeue().(int))
+	actual = append(actual, queue.Dequeue().(int))
+
+	if actual[0] != 1 || actual[1] != 2 || actual[2] != 3 || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not have correct items", name)
+	}
+}
+
+func shouldDequeueNilDelayed(t *testing.T, name string) {
+	queue := &conq.DelayQueue{Capacity: 3}
+
+	queue.Enqueue(1, time.Hour)
+
+	if queue.Dequeue() != nil || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: was not nil", name)
+	}
+}
+
+func shouldHaveItemsInExpiryOrder(t *testing.T, name string) {
+	queue := &conq.DelayQueue{Capacity: 3, Granularity: time.Microsecond}
+	var actual []int
+
+	queue.Enqueue(4, 300*time.Millisecond)
+	queue.Enqueue(2, 100*time.Microsecond)
+	queue.Enqueue(3, 5*time.Millisecond)
+	queue.Enqueue(1, 10*time.Microsecond)
+
+	for range [4]int{} {
+		actual = append(actual, queue.DequeueBlocking(time.Second, 0).(int))
+	}
+
+	if actual[0] != 1 || actual[1] != 2 || actual[2] != 3 || actual[3] != 4 || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not have items in expiry order %v", name, actual)
+	}
+}
+
+func shouldBlockUntilDelayPassed(t *testing.T, name string) {
+	queue := &conq.DelayQueue{Capacity: 3}
+	start := time.Now()
+
+	queue.Enqueue(1, 20*time.Millisecond)
+	queue.DequeueBlocking(time.Second, time.Millisecond)
+
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fail()
+		t.Logf("%s: did not block until delay passed, only blocked %v", name, elapsed)
+	}
+}
+
+func shouldDequeueNilDelayedBlocking(t *testing.T, name string) {
+	queue := &conq.DelayQueue{Capacity: 3}
+
+	queue.Enqueue(1, time.Hour)
+
+	if queue.DequeueBlocking(time.Millisecond, 0) != nil {
+		t.Fail()
+		t.Logf("%s: was not nil after timeout", name)
+	}
+}
+
+func shouldPublishDelayed(t *testing.T, name string) {
+	queue := &conq.DelayQueue{}
+
+	checkPublished(t, name, func(item interface{}) { queue.Enqueue(item, 0) }, queue.Dequeue)
+}
+
+func shouldWakeWhenDelayedReady(t *testing.T, name string) {
+	for _, delay := range []time.Duration{90 * time.Millisecond, 10 * time.Second} {
+		sim := &conq.Sim{}
+		queue := &conq.DelayQueue{Clock: sim}
+		start := sim.Now()
+
+		queue.Enqueue(1, delay)
+
+		var got interface{}
+		var waited time.Duration
+		sim.Go(func() {
+			got = queue.DequeueBlocking(0, time.Hour)
+			waited = sim.Now().Sub(start)
+		})
+
+		if err := sim.Run(); err != nil || got != 1 || waited != delay {
+			t.Fail()
+			t.Logf("%s: dequeued %v after %v instead of %v: %v", name, got, waited, delay, err)
+		}
+	}
+}
+
+func shouldWakeDelayedAtTimeout(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.DelayQueue{Clock: sim}
+	start := sim.Now()
+
+	queue.Enqueue(1, time.Hour)
+
+	got := interface{}(0)
+	var waited time.Duration
+	sim.Go(func() {
+		got = queue.DequeueBlocking(time.Second, time.Minute)
+		waited = sim.Now().Sub(start)
+	})
+
+	if err := sim.Run(); err != nil || got != nil || waited != time.Second {
+		t.Fail()
+		t.Logf("%s: dequeued %v after %v: %v", name, got, waited, err)
+	}
+}
+
+func shouldWakeWhenReadyEnqueued(t *testing.T, name string) {
+	for _, interval := range []time.Duration{0, time.Hour} {
+		sim := &conq.Sim{}
+		queue := &conq.DelayQueue{Clock: sim}
+		start := sim.Now()
+
+		queue.Enqueue(1, 10*time.Second)
+
+		var got interface{}
+		var waited time.Duration
+		sim.Go(func() {
+			got = queue.DequeueBlocking(0, interval)
+			waited = sim.Now().Sub(start)
+		})
+		sim.Go(func() {
+			sim.Sleep(time.Second)
+			queue.Enqueue(2, 0)
+		})
+
+		if err := sim.Run(); err != nil || got != 2 || waited != time.Second {
+			t.Fail()
+			t.Logf("%s: dequeued %v after %v with interval %v: %v", name, got, waited, interval, err)
+		}
+	}
+}
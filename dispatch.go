@@ -0,0 +1,392 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const ringReplicas = 64
+
+/*
+ErrConsumerExists is returned when a consumer is added to a dispatcher with the
+name of a consumer it already has.
+*/
+var ErrConsumerExists = errors.New("conq: consumer already exists")
+
+/*
+Balance decides which consumer of a dispatcher gets the next item.
+*/
+type Balance int
+
+const (
+	RoundRobin  Balance = iota // each consumer gets an item in turn
+	LeastLoaded                // the consumer with the fewest waiting items gets the item
+)
+
+/*
+Dispatcher dequeues items from a Source queue and distributes them between
+named consumers, for building simple load balancers. A consumer is either a
+sub-queue, which the dispatcher enqueues items into, or a func, which the
+dispatcher calls with one item at a time in its own goroutine.
+
+With RoundRobin, each consumer gets an item in turn. With LeastLoaded, the
+consumer with the fewest items waiting gets the item, where the items waiting
+for a sub-queue are its Len. A func consumer is skipped while it is still busy
+with its last item, and a consumer is skipped while it is paused. When no
+consumer can take an item, the dispatcher waits until one can, so the items
+stay in the Source queue instead of piling up in the dispatcher.
+
+Key opts in to sticky routing, where items with the same key always go to the
+same consumer, so items of each key are processed in order even with many
+consumers. Keys are assigned to consumers by consistent hashing, so when a
+consumer is added or removed, only the keys of about one consumer move. With
+sticky routing, Balance is not used, and when the consumer of the next item is
+paused or busy, the dispatcher waits for it, even if other consumers are idle.
+Keys are hashed into Partitions, and the partitions are what is assigned to the
+consumers.
+
+When a consumer is added or removed, the dispatcher rebalances the partitions
+between the consumers. It stops dispatching items, and it calls OnRevoke for
+each consumer that lost partitions, including a removed consumer. It then waits
+for those consumers to return from the items they are busy with, and it calls
+OnAssign for each consumer that gained partitions before it dispatches items
+again. A sub-queue consumer should finish or hand off the items of its revoked
+partitions before OnRevoke returns, so no item of a partition is processed by
+two consumers at once and no partition is left without a consumer. The
+callbacks are called by the goroutine adding or removing the consumer, and
+rebalances happen one at a time.
+
+Consumers can be added, paused, resumed, and removed while the dispatcher is
+running.
+*/
+type Dispatcher struct {
+	Balance       Balance                                 // how consumers are chosen, defaults to RoundRobin
+	Key           func(interface{}) string                // routes items by key, disabled when nil
+	OnAssign      func(consumer string, partitions []int) // called when partitions are assigned to a consumer
+	OnRevoke      func(consumer string, partitions []int) // called when partitions are revoked from a consumer
+	Partitions    int                                     // partitions keys are hashed into, defaults to 256
+	Source        *Queue                                  // queue the items are dequeued from
+	changed       chan struct{}
+	consumers     []*consumer
+	held          bool
+	membership    sync.Mutex
+	mut           sync.Mutex
+	next          int
+	pending       interface{}
+	pendingSample *sample
+	rebalancing   bool
+	ring          []ringPoint
+	running       sync.WaitGroup
+}
+
+type ringPoint struct {
+	consumer *consumer
+	hash     uint32
+}
+
+type consumer struct {
+	busy   bool
+	fn     func(item interface{})
+	name   string
+	paused bool
+	queue  *Queue
+}
+
+/*
+AddQueue adds a consumer that gets items enqueued into the queue. If the
+dispatcher already has a consumer with the name, AddQueue returns
+ErrConsumerExists.
+*/
+func (d *Dispatcher) AddQueue(name string, queue *Queue) error {
+	return d.add(&consumer{name: name, queue: queue})
+}
+
+/*
+AddFunc adds a consumer that gets items by being called with them. The func is
+called in its own goroutine, and it is not given another item until it returns.
+If the dispatcher already has a consumer with the name, AddFunc returns
+ErrConsumerExists.
+*/
+func (d *Dispatcher) AddFunc(name string, fn func(item interface{})) error {
+	return d.add(&consumer{name: name, fn: fn})
+}
+
+/*
+Pause stops the consumer with the name from getting items until it is resumed.
+It reports whether the dispatcher has the consumer.
+*/
+func (d *Dispatcher) Pause(name string) bool {
+	return d.setPaused(name, true)
+}
+
+/*
+Resume lets a paused consumer with the name get items again. It reports whether
+the dispatcher has the consumer.
+*/
+func (d *Dispatcher) Resume(name string) bool {
+	return d.setPaused(name, false)
+}
+
+/*
+Remove removes the consumer with the name so it gets no more items. Items that
+were already enqueued into a sub-queue stay there, and a func consumer finishes
+the item it is busy with. It reports whether the dispatcher had the consumer.
+*/
+func (d *Dispatcher) Remove(name string) bool {
+	d.membership.Lock()
+	defer d.membership.Unlock()
+
+	d.mut.Lock()
+
+	for i, c := range d.consumers {
+		if c.name == name {
+			before := d.assignment()
+			d.consumers = append(d.consumers[:i], d.consumers[i+1:]...)
+
+			if d.next > i {
+				d.next -= 1
+			}
+
+			d.buildRing()
+			d.rebalancing = d.Key != nil
+			after := d.assignment()
+			d.mut.Unlock()
+			d.rebalance(before, after)
+
+			return true
+		}
+	}
+
+	d.mut.Unlock()
+
+	return false
+}
+
+/*
+Run dequeues items from the Source queue and dispatches them to the consumers
+until the context is done or a poison item is dequeued, and it returns the error
+of the context or ErrClosed, or ErrInterrupted if the Source is interrupted
+while Run waits for an item. If an item cannot be enqueued into a sub-queue, Run
+returns the error of TryEnqueue. An item that was dequeued but not dispatched
+when Run returns is dispatched first by the next call to Run. Run waits for busy
+func consumers to return before it returns. Run must not be called concurrently.
+*/
+func (d *Dispatcher) Run(ctx context.Context) error {
+	defer d.running.Wait()
+
+	for {
+		if !d.held {
+			item, smp, err := d.Source.dequeueSample(ctx)
+			if err != nil {
+				return err
+			}
+
+			if item == ErrClosed {
+				return ErrClosed
+			}
+
+			d.pending, d.pendingSample, d.held = item, smp, true
+		}
+
+		if err := d.dispatch(ctx, d.pending, d.pendingSample); err != nil {
+			return err
+		}
+
+		d.pending, d.pendingSample, d.held = nil, nil, false
+	}
+}
+
+func (d *Dispatcher) add(c *consumer) error {
+	d.membership.Lock()
+	defer d.membership.Unlock()
+
+	d.mut.Lock()
+
+	for _, other := range d.consumers {
+		if other.name == c.name {
+			d.mut.Unlock()
+
+			return ErrConsumerExists
+		}
+	}
+
+	before := d.assignment()
+	d.consumers = append(d.consumers, c)
+	d.buildRing()
+	d.rebalancing = d.Key != nil
+	after := d.assignment()
+	d.mut.Unlock()
+	d.rebalance(before, after)
+
+	return nil
+}
+
+func (d *Dispatcher) setPaused(name string, paused bool) bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for _, c := range d.consumers {
+		if c.name == name {
+			c.paused = paused
+			d.notify()
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, item interface{}, smp *sample) error {
+	d.mut.Lock()
+
+	c := d.choose(item)
+	for c == nil {
+		changed := d.wait()
+		d.mut.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+			break
+		}
+
+		d.mut.Lock()
+		c = d.choose(item)
+	}
+
+	defer d.mut.Unlock()
+
+	if c.queue != nil {
+		smp.handled(0, "dispatched")
+
+		return c.queue.TryEnqueue(item)
+	}
+
+	c.busy = true
+	d.running.Add(1)
+
+	go func() {
+		defer d.running.Done()
+
+		start := time.Now()
+		c.fn(item)
+		smp.handled(time.Since(start), "handled")
+
+		d.mut.Lock()
+		c.busy = false
+		d.notify()
+		d.mut.Unlock()
+	}()
+
+	return nil
+}
+
+func (d *Dispatcher) choose(item interface{}) *consumer {
+	if d.Key != nil {
+		if d.rebalancing {
+			return nil
+		}
+
+		if c := d.owner(d.partition(item)); c != nil && !c.paused && !c.busy {
+			return c
+		}
+
+		return nil
+	}
+
+	var best *consumer
+	bestLoad, bestIndex := 0, 0
+
+	for i := range d.consumers {
+		j := (d.next + i) % len(d.consumers)
+		c := d.consumers[j]
+
+		if c.paused || c.busy {
+			continue
+		}
+
+		if d.Balance != LeastLoaded {
+			d.next = j + 1
+
+			return c
+		}
+
+		if load := c.load(); best == nil || load < bestLoad {
+			best, bestLoad, bestIndex = c, load, j
+		}
+	}
+
+	if best != nil {
+		d.next = bestIndex + 1
+	}
+
+	return best
+}
+
+func (d *Dispatcher) owner(partition int) *consumer {
+	if len(d.ring) == 0 {
+		return nil
+	}
+
+	h := hashString(strconv.Itoa(partition))
+	i := sort.Search(len(d.ring), func(i int) bool { return d.ring[i].hash >= h })
+
+	if i == len(d.ring) {
+		i = 0
+	}
+
+	return d.ring[i].consumer
+}
+
+func (d *Dispatcher) buildRing() {
+	d.ring = d.ring[:0]
+
+	for _, c := range d.consumers {
+		for i := 0; i < ringReplicas; i++ {
+			d.ring = append(d.ring, ringPoint{consumer: c, hash: hashString(c.name + "#" + strconv.Itoa(i))})
+		}
+	}
+
+	sort.Slice(d.ring, func(i, j int) bool { return d.ring[i].hash < d.ring[j].hash })
+}
+
+func (d *Dispatcher) notify() {
+	if d.changed != nil {
+		close(d.changed)
+		d.changed = nil
+	}
+}
+
+func (d *Dispatcher) wait() <-chan struct{} {
+	if d.changed == nil {
+		d.changed = make(chan struct{})
+	}
+
+	return d.changed
+}
+
+func (c *consumer) load() int {
+	if c.queue != nil {
+		return c.queue.Len()
+	}
+
+	return 0
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+
+	return h.Sum32()
+}
@@ -0,0 +1,323 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcher_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should dispatch items round robin":     shouldDispatchRoundRobin,
+		"should dispatch items to least loaded": shouldDispatchLeastLoaded,
+		"should dispatch items to funcs":        shouldDispatchFuncs,
+		"should stop at poison item":            shouldStopDispatchPoison,
+		"should keep item until next run":       shouldKeepDispatchItem,
+		"should dispatch items by key":          shouldDispatchByKey,
+		"should only move keys of removed":      shouldMoveKeysOfRemoved,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestDispatcher_AddQueue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should not add consumer twice": shouldNotAddConsumerTwice,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestDispatcher_Pause(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should skip paused consumer":       shouldSkipPausedConsumer,
+		"should not pause missing consumer": shouldNotPauseMissingConsumer,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestDispatcher_Remove(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should skip removed consumer": shouldSkipRemovedConsumer,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func runDispatcher(dispatcher *conq.Dispatcher) {
+	dispatcher.Source.EnqueuePoison(1)
+	dispatcher.Run(context.Background())
+}
+
+func shouldDispatchRoundRobin(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Source: &conq.Queue{}}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	dispatcher.AddQueue("a", a)
+	dispatcher.AddQueue("b", b)
+
+	for i := 0; i < 4; i++ {
+		dispatcher.Source.Enqueue(i)
+	}
+
+	runDispatcher(dispatcher)
+
+	if a.Dequeue() != 0 || a.Dequeue() != 2 || b.Dequeue() != 1 || b.Dequeue() != 3 {
+		t.Fail()
+		t.Logf("%s: did not dispatch round robin", name)
+	}
+}
+
+func shouldDispatchLeastLoaded(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Balance: conq.LeastLoaded, Source: &conq.Queue{}}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	dispatcher.AddQueue("a", a)
+	dispatcher.AddQueue("b", b)
+
+	a.Enqueue(-1)
+	a.Enqueue(-2)
+
+	for i := 0; i < 4; i++ {
+		dispatcher.Source.Enqueue(i)
+	}
+
+	runDispatcher(dispatcher)
+
+	if a.Len() != 3 || b.Len() != 3 || b.Dequeue() != 0 || b.Dequeue() != 1 {
+		t.Fail()
+		t.Logf("%s: did not dispatch to least loaded", name)
+	}
+}
+
+func shouldDispatchFuncs(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Source: &conq.Queue{}}
+	var mut sync.Mutex
+	counts := map[string]int{}
+	busy := map[string]bool{}
+	overlapped := false
+
+	for _, consumer := range []string{"a", "b", "c"} {
+		consumer := consumer
+		dispatcher.AddFunc(consumer, func(item interface{}) {
+			mut.Lock()
+			overlapped = overlapped || busy[consumer]
+			busy[consumer] = true
+			mut.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mut.Lock()
+			busy[consumer] = false
+			counts[consumer] += 1
+			mut.Unlock()
+		})
+	}
+
+	for i := 0; i < 30; i++ {
+		dispatcher.Source.Enqueue(i)
+	}
+
+	runDispatcher(dispatcher)
+
+	if overlapped || counts["a"]+counts["b"]+counts["c"] != 30 || counts["a"] == 0 || counts["b"] == 0 || counts["c"] == 0 {
+		t.Fail()
+		t.Logf("%s: did not dispatch to funcs %v", name, counts)
+	}
+}
+
+func shouldStopDispatchPoison(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Source: &conq.Queue{}}
+	a := &conq.Queue{}
+	dispatcher.AddQueue("a", a)
+
+	dispatcher.Source.Enqueue(1)
+	dispatcher.Source.EnqueuePoison(1)
+	dispatcher.Source.Enqueue(2)
+
+	if err := dispatcher.Run(context.Background()); err != conq.ErrClosed || a.Len() != 1 || dispatcher.Source.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: did not stop at poison item: %v", name, err)
+	}
+}
+
+func shouldKeepDispatchItem(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Source: &conq.Queue{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	dispatcher.Source.Enqueue(1)
+
+	if err := dispatcher.Run(ctx); err != context.DeadlineExceeded {
+		t.Fail()
+		t.Logf("%s: did not stop without consumers: %v", name, err)
+	}
+
+	a := &conq.Queue{}
+	dispatcher.AddQueue("a", a)
+	runDispatcher(dispatcher)
+
+	if a.Dequeue() != 1 {
+		t.Fail()
+		t.Logf("%s: did not keep item", name)
+	}
+}
+
+func shouldNotAddConsumerTwice(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Source: &conq.Queue{}}
+
+	if dispatcher.AddQueue("a", &conq.Queue{}) != nil || dispatcher.AddFunc("a", func(interface{}) {}) != conq.ErrConsumerExists {
+		t.Fail()
+		t.Logf("%s: added consumer twice", name)
+	}
+}
+
+func shouldSkipPausedConsumer(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Source: &conq.Queue{}}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	dispatcher.AddQueue("a", a)
+	dispatcher.AddQueue("b", b)
+	dispatcher.Pause("a")
+
+	dispatcher.Source.Enqueue(1)
+	dispatcher.Source.Enqueue(2)
+	runDispatcher(dispatcher)
+
+	dispatcher.Resume("a")
+	dispatcher.Source.Enqueue(3)
+	runDispatcher(dispatcher)
+
+	if a.Len() != 1 || b.Len() != 2 || a.Dequeue() != 3 {
+		t.Fail()
+		t.Logf("%s: did not skip paused consumer", name)
+	}
+}
+
+func shouldNotPauseMissingConsumer(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Source: &conq.Queue{}}
+
+	if dispatcher.Pause("a") || dispatcher.Resume("a") || dispatcher.Remove("a") {
+		t.Fail()
+		t.Logf("%s: paused missing consumer", name)
+	}
+}
+
+func shouldSkipRemovedConsumer(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Source: &conq.Queue{}}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	dispatcher.AddQueue("a", a)
+	dispatcher.AddQueue("b", b)
+
+	dispatcher.Source.Enqueue(1)
+	runDispatcher(dispatcher)
+
+	if !dispatcher.Remove("a") {
+		t.Fail()
+		t.Logf("%s: did not remove consumer", name)
+	}
+
+	dispatcher.Source.Enqueue(2)
+	dispatcher.Source.Enqueue(3)
+	runDispatcher(dispatcher)
+
+	if a.Len() != 1 || b.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: did not skip removed consumer", name)
+	}
+}
+
+func keyOf(item interface{}) string {
+	return strconv.Itoa(item.(int) % 10)
+}
+
+func shouldDispatchByKey(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Key: keyOf, Source: &conq.Queue{}}
+	queues := []*conq.Queue{{}, {}, {}}
+
+	for i, queue := range queues {
+		dispatcher.AddQueue(strconv.Itoa(i), queue)
+	}
+
+	for i := 0; i < 100; i++ {
+		dispatcher.Source.Enqueue(i)
+	}
+
+	runDispatcher(dispatcher)
+
+	seen := map[string]int{}
+	for i, queue := range queues {
+		last := map[string]int{}
+
+		for _, item := range queue.Snapshot() {
+			key := keyOf(item)
+
+			if owner, ok := seen[key]; ok && owner != i || last[key] > item.(int) {
+				t.Fail()
+				t.Logf("%s: did not dispatch %v by key", name, item)
+			}
+
+			seen[key] = i
+			last[key] = item.(int)
+		}
+	}
+
+	if len(seen) != 10 {
+		t.Fail()
+		t.Logf("%s: did not dispatch all keys", name)
+	}
+}
+
+func shouldMoveKeysOfRemoved(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Key: keyOf, Source: &conq.Queue{}}
+	queues := map[string]*conq.Queue{"a": {}, "b": {}, "c": {}}
+	owners := func() map[string]string {
+		keys := map[string]string{}
+
+		for consumer, queue := range queues {
+			for queue.Len() > 0 {
+				keys[keyOf(queue.Dequeue())] = consumer
+			}
+		}
+
+		return keys
+	}
+
+	for consumer, queue := range queues {
+		dispatcher.AddQueue(consumer, queue)
+	}
+
+	for i := 0; i < 100; i++ {
+		dispatcher.Source.Enqueue(i)
+	}
+
+	runDispatcher(dispatcher)
+	before := owners()
+	dispatcher.Remove("a")
+
+	for i := 0; i < 100; i++ {
+		dispatcher.Source.Enqueue(i)
+	}
+
+	runDispatcher(dispatcher)
+
+	for key, consumer := range owners() {
+		if consumer == "a" || before[key] != "a" && before[key] != consumer {
+			t.Fail()
+			t.Logf("%s: moved key %s from %s to %s", name, key, before[key], consumer)
+		}
+	}
+}
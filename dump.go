@@ -0,0 +1,181 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+/*
+Dump writes the state of named queues as JSON, to debug hangs together with
+the goroutine dump of the process. Every queue is locked while the dump is
+taken, so the queues are frozen at the same instant and the dump is consistent
+across them. Each queue is dumped with its len, its read and write cursors, how
+many chunks it has, whether it is closed, its Stats, and up to Samples items at
+its head. Items are encoded as JSON, errors like poison items as their message,
+and items that cannot be encoded as their %v format.
+*/
+type Dump struct {
+	Queues  map[string]*Queue // queues by name
+	Samples int               // most items dumped from the head of each queue, defaults to 10
+	Timeout time.Duration     // longest Run waits for the dump after SIGQUIT, defaults to 5s
+}
+
+/*
+QueueDump is the state of one queue in a dump.
+*/
+type QueueDump struct {
+	Chunks int           `json:"chunks"` // number of chunks of the queue
+	Closed bool          `json:"closed"` // whether the queue was closed
+	Head   []interface{} `json:"head"`   // items at the head of the queue, oldest first
+	Len    int           `json:"len"`    // number of items enqueued
+	Read   int           `json:"read"`   // index of the next item to dequeue in the first chunk
+	Stats  Stats         `json:"stats"`  // stats of the queue
+	Write  int           `json:"write"`  // index of the next item to enqueue in the last chunk
+}
+
+/*
+Snapshot locks every queue, copies their state, and unlocks them, and returns
+the state of each queue by name.
+*/
+func (d *Dump) Snapshot() map[string]QueueDump {
+	samples := d.Samples
+	if samples <= 0 {
+		samples = 10
+	}
+
+	names := make([]string, 0, len(d.Queues))
+	for name := range d.Queues {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	locked := make(map[*Queue]bool, len(names))
+	for _, name := range names {
+		if q := d.Queues[name]; !locked[q] {
+			q.lock()
+			locked[q] = true
+		}
+	}
+
+	dumps := make(map[string]QueueDump, len(names))
+	for _, name := range names {
+		dumps[name] = d.Queues[name].dump(samples)
+	}
+
+	for q := range locked {
+		q.unlock()
+	}
+
+	return dumps
+}
+
+/*
+WriteTo writes a snapshot of the queues to the writer as a JSON object with a
+property for each queue, and returns the number of bytes written.
+*/
+func (d *Dump) WriteTo(w io.Writer) (int64, error) {
+	dumps := d.Snapshot()
+
+	for _, dump := range dumps {
+		for i, item := range dump.Head {
+			if err, ok := item.(error); ok {
+				item = err.Error()
+			}
+
+			data, err := json.Marshal(item)
+			if err != nil {
+				data, _ = json.Marshal(fmt.Sprintf("%v", item))
+			}
+
+			dump.Head[i] = json.RawMessage(data)
+		}
+	}
+
+	data, err := json.MarshalIndent(dumps, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(append(data, '\n'))
+
+	return int64(n), err
+}
+
+/*
+Run waits until the process receives SIGQUIT and writes a dump to the writer.
+Then it stops receiving SIGQUIT and raises it again, so the runtime still writes
+the goroutine dump and exits the process, unless other code of the process also
+receives SIGQUIT. Errors writing the dump are ignored, and Run gives up waiting
+for the dump after Timeout, like when a queue stays locked in a deadlock, so
+they do not prevent the goroutine dump. Run returns the error of the context
+once it is done, without a dump if no SIGQUIT was received.
+*/
+func (d *Dump) Run(ctx context.Context, w io.Writer) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-signals:
+		break
+	}
+
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	written := make(chan struct{})
+	go func() {
+		d.WriteTo(w)
+		close(written)
+	}()
+
+	timer := time.NewTimer(timeout)
+
+	select {
+	case <-written:
+		break
+	case <-timer.C:
+		break
+	}
+
+	timer.Stop()
+	signal.Stop(signals)
+
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(syscall.SIGQUIT)
+	}
+
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func (q *Queue) dump(samples int) QueueDump {
+	d := QueueDump{Closed: q.closed, Len: q.len, Read: q.rx, Stats: q.stats(), Write: q.wx}
+
+	for c, start := q.head, q.rx; c != nil; c, start = c.next, 0 {
+		d.Chunks += 1
+
+		for i := start; i < q.end(c) && len(d.Head) < samples; i++ {
+			d.Head = append(d.Head, untrace(c.items[i]))
+		}
+	}
+
+	return d
+}
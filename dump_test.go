@@ -0,0 +1,145 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+func TestDump_Snapshot(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should snapshot queues":        shouldSnapshotQueues,
+		"should limit head samples":     shouldLimitHeadSamples,
+		"should lock shared queue once": shouldLockSharedOnce,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestDump_WriteTo(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should write json dump": shouldWriteJSONDump,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestDump_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should stop without dump": shouldStopWithoutDump,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldSnapshotQueues(t *testing.T, name string) {
+	a, b := &conq.Queue{Capacity: 2}, &conq.Queue{}
+	dump := &conq.Dump{Queues: map[string]*conq.Queue{"a": a, "b": b}}
+
+	for i := 0; i < 3; i++ {
+		a.Enqueue(i)
+	}
+
+	a.Dequeue()
+	b.Close()
+
+	dumps := dump.Snapshot()
+
+	got := dumps["a"]
+	if got.Len != 2 || got.Chunks != 2 || got.Read != 1 || got.Write != 1 || len(got.Head) != 2 || got.Head[0] != 1 || got.Stats.Enqueued != 3 {
+		t.Fail()
+		t.Logf("%s: did not snapshot queue %+v", name, got)
+	}
+
+	if !dumps["b"].Closed || dumps["b"].Len != 0 {
+		t.Fail()
+		t.Logf("%s: did not snapshot closed queue %+v", name, dumps["b"])
+	}
+}
+
+func shouldLimitHeadSamples(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	dump := &conq.Dump{Queues: map[string]*conq.Queue{"jobs": queue}, Samples: 2}
+
+	for i := 0; i < 5; i++ {
+		queue.Enqueue(i)
+	}
+
+	if head := dump.Snapshot()["jobs"].Head; len(head) != 2 || head[0] != 0 || head[1] != 1 {
+		t.Fail()
+		t.Logf("%s: did not limit head samples %v", name, head)
+	}
+}
+
+func shouldLockSharedOnce(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	dump := &conq.Dump{Queues: map[string]*conq.Queue{"jobs": queue, "work": queue}}
+
+	done := make(chan struct{})
+	go func() {
+		dump.Snapshot()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		break
+	case <-time.After(time.Second):
+		t.Fail()
+		t.Logf("%s: did not snapshot queue with two names", name)
+	}
+}
+
+func shouldWriteJSONDump(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	dump := &conq.Dump{Queues: map[string]*conq.Queue{"jobs": queue}}
+
+	queue.Enqueue("job")
+	queue.Enqueue(func() {})
+	queue.EnqueuePoison(1)
+
+	var buf bytes.Buffer
+	n, err := dump.WriteTo(&buf)
+
+	var got map[string]struct {
+		Head []string `json:"head"`
+		Len  int      `json:"len"`
+	}
+
+	if jsonErr := json.Unmarshal(buf.Bytes(), &got); err != nil || jsonErr != nil || n != int64(buf.Len()) {
+		t.Fail()
+		t.Logf("%s: did not write json dump: %v %v", name, err, jsonErr)
+
+		return
+	}
+
+	if jobs := got["jobs"]; jobs.Len != 3 || len(jobs.Head) != 3 || jobs.Head[0] != "job" || jobs.Head[2] != conq.ErrClosed.Error() {
+		t.Fail()
+		t.Logf("%s: did not dump items %+v", name, jobs)
+	}
+}
+
+func shouldStopWithoutDump(t *testing.T, name string) {
+	dump := &conq.Dump{Queues: map[string]*conq.Queue{"jobs": {}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	if err := dump.Run(ctx, &buf); err != context.Canceled || buf.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not stop without dump: %v", name, err)
+	}
+}
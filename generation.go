@@ -0,0 +1,60 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+Generation returns a number that changes whenever items are enqueued, dequeued,
+or removed, or the queue is closed, so pollers can detect that the queue changed
+without locking it. Only whether the generation changed is meaningful, and
+Generation does not lock the queue. The generation is a 64-bit counter that is
+read atomically, so on 32-bit platforms a Queue that is a field of another
+struct must be its first field.
+*/
+func (q *Queue) Generation() uint64 {
+	return atomic.LoadUint64(&q.generation)
+}
+
+/*
+WaitChange blocks until the generation of the queue is no longer gen, and it
+returns the new generation. It is for custom consumption loops that wait for the
+queue to change instead of polling it. If timeout is greater than 0 and the
+queue does not change within that time, WaitChange returns gen. Like WaitLen,
+WaitChange does not poll, and the timeout is measured by the system clock.
+*/
+func (q *Queue) WaitChange(gen uint64, timeout time.Duration) uint64 {
+	var expired <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	q.lock()
+
+	for q.Generation() == gen {
+		changed := q.wait()
+		q.unlock()
+
+		select {
+		case <-expired:
+			return q.Generation()
+		case <-changed:
+			break
+		}
+
+		q.lock()
+	}
+
+	q.unlock()
+
+	return q.Generation()
+}
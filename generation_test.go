@@ -0,0 +1,110 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+func TestQueue_Generation(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should change on every mutation": shouldChangeGenerationOnMutation,
+		"should not change on reads":      shouldNotChangeGenerationOnRead,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_WaitChange(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should return when already changed": shouldReturnWhenAlreadyChanged,
+		"should wake when queue changes":     shouldWakeWhenQueueChanges,
+		"should return gen after timeout":    shouldReturnGenAfterTimeout,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldChangeGenerationOnMutation(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	mutations := []func(){
+		func() { queue.Enqueue(1) },
+		func() { queue.Enqueue(2) },
+		func() { queue.Dequeue() },
+		func() { queue.Remove(2) },
+		func() { queue.Close() },
+		func() { queue.EnqueuePoison(1) },
+	}
+
+	for i, mutate := range mutations {
+		gen := queue.Generation()
+		mutate()
+
+		if queue.Generation() == gen {
+			t.Fail()
+			t.Logf("%s: generation did not change after mutation %d", name, i)
+		}
+	}
+}
+
+func shouldNotChangeGenerationOnRead(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	queue.Close()
+	gen := queue.Generation()
+
+	queue.Len()
+	queue.Stats()
+	queue.Contains(func(interface{}) bool { return false })
+	queue.Remove(2)
+	queue.Close()
+
+	if queue.Generation() != gen {
+		t.Fail()
+		t.Logf("%s: generation changed without mutations", name)
+	}
+}
+
+func shouldReturnWhenAlreadyChanged(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	gen := queue.Generation()
+	queue.Enqueue(1)
+
+	if got := queue.WaitChange(gen, 0); got == gen || got != queue.Generation() {
+		t.Fail()
+		t.Logf("%s: returned generation %d", name, got)
+	}
+}
+
+func shouldWakeWhenQueueChanges(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	gen := queue.Generation()
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		queue.Enqueue(1)
+	}()
+
+	if got := queue.WaitChange(gen, time.Minute); got == gen || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: did not wake on enqueue", name)
+	}
+}
+
+func shouldReturnGenAfterTimeout(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	gen := queue.Generation()
+
+	if got := queue.WaitChange(gen, 10*time.Millisecond); got != gen {
+		t.Fail()
+		t.Logf("%s: returned generation %d instead of %d", name, got, gen)
+	}
+}
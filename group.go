@@ -0,0 +1,249 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"errors"
+	"sync"
+)
+
+/*
+ErrBudgetExceeded is returned by TryEnqueue when a queue is in a Group that has
+no room for the item, and the Group could not evict items to make room.
+*/
+var ErrBudgetExceeded = errors.New("conq: group budget exceeded")
+
+/*
+Eviction decides which items a group evicts to make room for a new item.
+*/
+type Eviction int
+
+const (
+	RejectNew    Eviction = iota // no items are evicted, and TryEnqueue returns ErrBudgetExceeded
+	EvictOwn                     // the oldest items of the queue the item is enqueued into are evicted
+	EvictLongest                 // the oldest items of the queue with the most items are evicted
+	EvictLargest                 // the oldest items of the queue with the most bytes are evicted
+)
+
+/*
+Group is a budget of items and bytes that is shared by several queues, like
+per-tenant queues that share one memory budget. Once the queues of a group hold
+MaxLen items or MaxBytes bytes, enqueuing an item into any of them evicts the
+oldest items of a queue chosen by Evict until the item fits. Evicted items
+count as removed in the queue Stats, and they are passed to OnEvict. With
+RejectNew, or when no queue has items to evict, TryEnqueue returns
+ErrBudgetExceeded instead.
+
+EvictFirst chooses which item of the chosen queue is evicted, like the largest
+item or the item with the lowest priority. It reports whether item a should be
+evicted before item b, and the first item for which no other item should be
+evicted before it is evicted, so ties evict the oldest item. Choosing an item
+with EvictFirst is O(n), and the queue is locked while EvictFirst is called.
+
+Overflow opts in to diverting evicted items instead of dropping them. Evicted
+items are enqueued into Overflow before they are passed to OnEvict, and they
+are only dropped if Overflow rejects them. Overflow must not be in the group.
+
+Size returns the bytes of an item, and it must always return the same size for
+the same item. It defaults to the length of []byte and string items, and 0 for
+other items. Poison items are not counted against the budget.
+*/
+type Group struct {
+	Evict      Eviction                    // which queue items are evicted from, defaults to RejectNew
+	EvictFirst func(a, b interface{}) bool // whether item a is evicted before b, defaults to the oldest item
+	MaxBytes   int                         // most bytes in all queues, unlimited when 0
+	MaxLen     int                         // most items in all queues, unlimited when 0
+	OnEvict    func(item interface{})      // called with each evicted item, after it is evicted
+	Overflow   *Queue                      // queue evicted items are diverted into, dropped when nil
+	Size       func(item interface{}) int  // bytes of an item, defaults to the length of []byte and string items
+	bytes      int
+	len        int
+	mut        sync.Mutex
+	queues     []*Queue
+}
+
+/*
+Add adds a queue to the group, so its items are counted against the budget of
+the group. A queue can only be in one group, and it must be added before it is
+used.
+*/
+func (g *Group) Add(q *Queue) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	q.group = g
+	g.queues = append(g.queues, q)
+}
+
+/*
+Len returns how many items are in the queues of the group.
+*/
+func (g *Group) Len() int {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	return g.len
+}
+
+/*
+Bytes returns how many bytes are in the queues of the group, as measured by
+Size.
+*/
+func (g *Group) Bytes() int {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	return g.bytes
+}
+
+func (g *Group) reserve(q *Queue, size int) error {
+	for {
+		g.mut.Lock()
+
+		if g.MaxBytes > 0 && size > g.MaxBytes {
+			g.mut.Unlock()
+
+			return ErrBudgetExceeded
+		}
+
+		if (g.MaxLen <= 0 || g.len < g.MaxLen) && (g.MaxBytes <= 0 || g.bytes+size <= g.MaxBytes) {
+			g.len += 1
+			g.bytes += size
+			g.mut.Unlock()
+
+			return nil
+		}
+
+		evict, first, overflow := g.Evict, g.EvictFirst, g.Overflow
+		queues := append([]*Queue(nil), g.queues...)
+		g.mut.Unlock()
+
+		victim := chooseVictim(q, queues, evict)
+		if victim == nil {
+			return ErrBudgetExceeded
+		}
+
+		item, ok := victim.evict(first)
+		if !ok {
+			return ErrBudgetExceeded
+		}
+
+		if overflow != nil {
+			overflow.Enqueue(item)
+		}
+
+		if g.OnEvict != nil {
+			g.OnEvict(item)
+		}
+	}
+}
+
+func (g *Group) unreserve(size int) {
+	g.mut.Lock()
+	g.len -= 1
+	g.bytes -= size
+	g.mut.Unlock()
+}
+
+func chooseVictim(q *Queue, queues []*Queue, evict Eviction) *Queue {
+	switch evict {
+	case EvictOwn:
+		return q
+	case EvictLongest, EvictLargest:
+		var victim *Queue
+		most := 0
+
+		for _, other := range queues {
+			other.rlock()
+			n := other.len
+			if evict == EvictLargest {
+				n = other.groupBytes
+			}
+			other.runlock()
+
+			if victim == nil || n > most {
+				victim, most = other, n
+			}
+		}
+
+		return victim
+	default:
+		return nil
+	}
+}
+
+func (g *Group) size(item interface{}) int {
+	if g.Size != nil {
+		return g.Size(item)
+	}
+
+	switch item := item.(type) {
+	case []byte:
+		return len(item)
+	case string:
+		return len(item)
+	default:
+		return 0
+	}
+}
+
+func (q *Queue) evict(first func(a, b interface{}) bool) (interface{}, bool) {
+	q.lock()
+	defer q.unlock()
+
+	if q.len == 0 {
+		return nil, false
+	}
+
+	victim, i := -1, 0
+	var item interface{}
+
+	for c, start := q.head, q.rx; c != nil && (first != nil || i == 0); c, start = c.next, 0 {
+		for _, val := range c.items[start:q.end(c)] {
+			if next := untrace(val); next != ErrClosed && (victim < 0 || first(next, item)) {
+				victim, item = i, next
+			}
+
+			i += 1
+
+			if first == nil {
+				break
+			}
+		}
+	}
+
+	switch victim {
+	case -1:
+		return nil, false
+	case 0:
+		q.dequeue()
+		q.dequeued -= 1
+		q.removed += 1
+	default:
+		j := 0
+		q.filter(func(interface{}) bool {
+			keep := j != victim
+			j += 1
+
+			return keep
+		})
+	}
+
+	q.dropped += 1
+	q.unadmit(item)
+	q.notify()
+
+	return item, true
+}
+
+func (q *Queue) unbudget(item interface{}) {
+	if q.group == nil || item == ErrClosed {
+		return
+	}
+
+	size := q.group.size(item)
+	q.groupBytes -= size
+	q.group.unreserve(size)
+}
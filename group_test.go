@@ -0,0 +1,180 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+)
+
+func TestGroup_Add(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should reject items over max len":     shouldRejectOverGroupLen,
+		"should reject items over max bytes":   shouldRejectOverGroupBytes,
+		"should free budget of dequeued items": shouldFreeGroupBudget,
+		"should evict own items":               shouldEvictOwnItems,
+		"should evict items of longest queue":  shouldEvictLongestQueue,
+		"should evict items of largest queue":  shouldEvictLargestQueue,
+		"should not count poison items":        shouldNotCountPoison,
+		"should evict item chosen first":       shouldEvictChosenItem,
+		"should divert evicted items":          shouldDivertEvictedItems,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldRejectOverGroupLen(t *testing.T, name string) {
+	group := &conq.Group{MaxLen: 2}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	group.Add(a)
+	group.Add(b)
+
+	a.Enqueue(1)
+	b.Enqueue(2)
+
+	if err := a.TryEnqueue(3); err != conq.ErrBudgetExceeded || a.Len() != 1 || group.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: did not reject item: %v", name, err)
+	}
+}
+
+func shouldRejectOverGroupBytes(t *testing.T, name string) {
+	group := &conq.Group{MaxBytes: 8}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	group.Add(a)
+	group.Add(b)
+
+	a.Enqueue("abcd")
+	b.Enqueue([]byte("efg"))
+	b.Enqueue(1)
+
+	if err := a.TryEnqueue("hi"); err != conq.ErrBudgetExceeded || group.Bytes() != 7 || group.Len() != 3 {
+		t.Fail()
+		t.Logf("%s: did not reject item: %v", name, err)
+	}
+}
+
+func shouldFreeGroupBudget(t *testing.T, name string) {
+	group := &conq.Group{MaxLen: 2, MaxBytes: 10}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	group.Add(a)
+	group.Add(b)
+
+	a.Enqueue("abc")
+	b.Enqueue("def")
+	a.Dequeue()
+	b.Remove("def")
+
+	if a.TryEnqueue("ghi") != nil || b.TryEnqueue("jkl") != nil || group.Len() != 2 || group.Bytes() != 6 {
+		t.Fail()
+		t.Logf("%s: did not free budget", name)
+	}
+}
+
+func shouldEvictOwnItems(t *testing.T, name string) {
+	var evicted []interface{}
+	group := &conq.Group{Evict: conq.EvictOwn, MaxLen: 3, OnEvict: func(item interface{}) { evicted = append(evicted, item) }}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	group.Add(a)
+	group.Add(b)
+
+	a.Enqueue(1)
+	a.Enqueue(2)
+	b.Enqueue(3)
+
+	if err := a.TryEnqueue(4); err != nil || len(evicted) != 1 || evicted[0] != 1 || a.Len() != 2 || b.Len() != 1 || a.Stats().Removed != 1 {
+		t.Fail()
+		t.Logf("%s: did not evict own items: %v", name, err)
+	}
+}
+
+func shouldEvictLongestQueue(t *testing.T, name string) {
+	group := &conq.Group{Evict: conq.EvictLongest, MaxLen: 3}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	group.Add(a)
+	group.Add(b)
+
+	a.Enqueue(1)
+	a.Enqueue(2)
+	b.Enqueue(3)
+	b.Enqueue(4)
+
+	if a.Len() != 1 || a.Dequeue() != 2 || b.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: did not evict items of longest queue", name)
+	}
+}
+
+func shouldEvictLargestQueue(t *testing.T, name string) {
+	group := &conq.Group{Evict: conq.EvictLargest, MaxBytes: 10}
+	a, b := &conq.Queue{}, &conq.Queue{}
+	group.Add(a)
+	group.Add(b)
+
+	a.Enqueue("a")
+	a.Enqueue("b")
+	b.Enqueue("cdefgh")
+	a.Enqueue("ijk")
+
+	if a.Len() != 3 || b.Len() != 0 || group.Bytes() != 5 {
+		t.Fail()
+		t.Logf("%s: did not evict items of largest queue", name)
+	}
+}
+
+func shouldNotCountPoison(t *testing.T, name string) {
+	group := &conq.Group{Evict: conq.EvictOwn, MaxLen: 1}
+	a := &conq.Queue{}
+	group.Add(a)
+
+	a.EnqueuePoison(1)
+
+	if a.TryEnqueue(1) != nil || a.TryEnqueue(2) != conq.ErrBudgetExceeded || a.Dequeue() != conq.ErrClosed || group.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: counted poison items", name)
+	}
+}
+
+func shouldEvictChosenItem(t *testing.T, name string) {
+	var evicted []interface{}
+	group := &conq.Group{
+		Evict:      conq.EvictOwn,
+		EvictFirst: func(a, b interface{}) bool { return len(a.(string)) > len(b.(string)) },
+		MaxLen:     3,
+		OnEvict:    func(item interface{}) { evicted = append(evicted, item) },
+	}
+	a := &conq.Queue{}
+	group.Add(a)
+
+	a.Enqueue("b")
+	a.Enqueue("ccc")
+	a.Enqueue("ddd")
+
+	err := a.TryEnqueue("e")
+
+	if err != nil || !reflect.DeepEqual(evicted, []interface{}{"ccc"}) || !reflect.DeepEqual(a.Snapshot(), []interface{}{"b", "ddd", "e"}) || a.Stats().Dropped != 1 {
+		t.Fail()
+		t.Logf("%s: evicted %v leaving %v: %v", name, evicted, a.Snapshot(), err)
+	}
+}
+
+func shouldDivertEvictedItems(t *testing.T, name string) {
+	overflow := &conq.Queue{}
+	group := &conq.Group{Evict: conq.EvictOwn, MaxLen: 2, Overflow: overflow}
+	a := &conq.Queue{}
+	group.Add(a)
+
+	a.Enqueue(1)
+	a.Enqueue(2)
+	a.Enqueue(3)
+
+	if !reflect.DeepEqual(overflow.Snapshot(), []interface{}{1}) || !reflect.DeepEqual(a.Snapshot(), []interface{}{2, 3}) || group.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: diverted %v leaving %v", name, overflow.Snapshot(), a.Snapshot())
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+/*
+Handle refers to one item enqueued with EnqueueHandle or found by PeekHandles,
+so the item can be cancelled or taken until it is dequeued. A handle only
+refers to its own item, even if other items in the queue are equal to it.
+*/
+type Handle struct {
+	item    interface{}
+	queue   *Queue
+	settled bool
+}
+
+/*
+EnqueueHandle adds a new item to the queue like Enqueue, and it returns a handle
+that can cancel the item while it is still in the queue. It is for jobs that
+users can cancel before they start. If the item is not enqueued, EnqueueHandle
+returns a nil handle and an error that says why.
+*/
+func (q *Queue) EnqueueHandle(item interface{}) (*Handle, error) {
+	h := &Handle{queue: q}
+
+	if err := q.enqueueWith(item, h); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+/*
+PeekHandles returns handles to up to max items for which pred returns true, in
+the order they would be dequeued, without removing them. It is for schedulers
+that plan a batch and then commit it: Take dequeues exactly the items of the
+handles that are still in the queue. An item that already has a handle gets
+the same handle again, and poison items are skipped. PeekHandles is O(n), and
+it locks the queue while it calls pred.
+*/
+func (q *Queue) PeekHandles(pred func(item interface{}) bool, max int) []*Handle {
+	q.lock()
+	defer q.unlock()
+
+	if max <= 0 || q.len == 0 {
+		return nil
+	}
+
+	if q.isShared() {
+		q.filter(func(interface{}) bool { return true })
+	}
+
+	var handles []*Handle
+
+	for c, start := q.head, q.rx; c != nil && len(handles) < max; c, start = c.next, 0 {
+		for i := start; i < q.end(c) && len(handles) < max; i++ {
+			val := c.items[i]
+
+			if item := untrace(val); item == ErrClosed || !pred(item) {
+				continue
+			}
+
+			if e, ok := val.(*envelope); ok && e.handle != nil {
+				handles = append(handles, e.handle)
+
+				continue
+			}
+
+			h := &Handle{queue: q}
+			c.items[i] = withHandle(val, h)
+			handles = append(handles, h)
+		}
+	}
+
+	return handles
+}
+
+/*
+Take removes the items of the handles from the queue at once and returns them
+in the order they would have been dequeued. Handles of items that were already
+dequeued, removed, or taken, and handles of other queues, are skipped, so Take
+only returns the items that were still in the queue. Taken items count as
+dequeued in the queue Stats. Take is O(n), and it locks the queue while it is
+removing the items.
+*/
+func (q *Queue) Take(handles ...*Handle) []interface{} {
+	q.lock()
+	defer q.unlock()
+
+	taking := make(map[*Handle]bool, len(handles))
+	for _, h := range handles {
+		if h != nil && h.queue == q && !h.settled {
+			taking[h] = true
+		}
+	}
+
+	if len(taking) == 0 {
+		return nil
+	}
+
+	var items []interface{}
+
+	q.filter(func(val interface{}) bool {
+		if e, ok := val.(*envelope); ok && taking[e.handle] {
+			items = append(items, e.item)
+			q.unadmit(e.item)
+
+			return false
+		}
+
+		return true
+	})
+
+	q.removed -= uint64(len(items))
+	q.dequeued += uint64(len(items))
+	q.notify()
+
+	return items
+}
+
+/*
+Item returns the item of the handle.
+*/
+func (h *Handle) Item() interface{} {
+	return h.item
+}
+
+/*
+Cancel removes the item of the handle from the queue if it has not been
+dequeued or removed yet, and it reports whether the item was removed. The order
+of the remaining items is kept, and a cancelled item counts as removed in the
+queue Stats. Cancel is O(n), and it locks the queue while it is removing the
+item.
+*/
+func (h *Handle) Cancel() bool {
+	q := h.queue
+	q.lock()
+	defer q.unlock()
+
+	if h.settled {
+		return false
+	}
+
+	q.filter(func(val interface{}) bool {
+		if e, ok := val.(*envelope); !ok || e.handle != h {
+			return true
+		}
+
+		q.unadmit(h.item)
+
+		return false
+	})
+	q.notify()
+
+	return true
+}
+
+func withHandle(item interface{}, h *Handle) interface{} {
+	h.item = untrace(item)
+
+	if e, ok := item.(*envelope); ok {
+		e.handle = h
+
+		return e
+	}
+
+	return &envelope{handle: h, item: item}
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+)
+
+func TestHandle_Cancel(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should cancel enqueued item":       shouldCancelEnqueuedItem,
+		"should only cancel its own item":   shouldCancelOwnItem,
+		"should not cancel dequeued item":   shouldNotCancelDequeued,
+		"should not cancel item twice":      shouldNotCancelTwice,
+		"should cancel traced item":         shouldCancelTracedItem,
+		"should not have handle over limit": shouldNotHaveHandleOverLimit,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_PeekHandles(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should peek handles of matching items": shouldPeekHandles,
+		"should reuse handle of item":           shouldReuseHandle,
+		"should not change shared items":        shouldNotChangeSharedItems,
+		"should cancel peeked item":             shouldCancelPeekedItem,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_Take(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should take items of handles":        shouldTakeItems,
+		"should skip items no longer queued":  shouldSkipGoneItems,
+		"should skip handles of other queues": shouldSkipOtherQueues,
+		"should release budget of taken item": shouldReleaseTakenBudget,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func isEven(item interface{}) bool {
+	return item.(int)%2 == 0
+}
+
+func shouldPeekHandles(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2}
+
+	for i := 1; i <= 6; i++ {
+		queue.Enqueue(i)
+	}
+
+	queue.EnqueuePoison(1)
+	handles := queue.PeekHandles(isEven, 2)
+
+	if len(handles) != 2 || handles[0].Item() != 2 || handles[1].Item() != 4 || queue.Len() != 7 {
+		t.Fail()
+		t.Logf("%s: did not peek handles %v", name, handles)
+	}
+}
+
+func shouldReuseHandle(t *testing.T, name string) {
+	queue := &conq.Queue{TrackAge: true}
+	handle, _ := queue.EnqueueHandle(2)
+
+	first := queue.PeekHandles(isEven, 1)
+	again := queue.PeekHandles(isEven, 1)
+
+	if len(first) != 1 || first[0] != handle || len(again) != 1 || again[0] != handle || handle.Item() != 2 {
+		t.Fail()
+		t.Logf("%s: did not reuse handle", name)
+	}
+}
+
+func shouldNotChangeSharedItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 4}
+
+	for i := 1; i <= 4; i++ {
+		queue.Enqueue(i)
+	}
+
+	var ranged []interface{}
+	queue.Range(func(item interface{}) bool {
+		if len(ranged) == 0 {
+			queue.PeekHandles(isEven, 2)
+		}
+
+		ranged = append(ranged, item)
+
+		return true
+	})
+
+	if !reflect.DeepEqual(ranged, []interface{}{1, 2, 3, 4}) || !reflect.DeepEqual(queue.Snapshot(), ranged) {
+		t.Fail()
+		t.Logf("%s: changed shared items %v", name, ranged)
+	}
+}
+
+func shouldCancelPeekedItem(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+
+	handles := queue.PeekHandles(isEven, 1)
+
+	if len(handles) != 1 || !handles[0].Cancel() || !reflect.DeepEqual(queue.Snapshot(), []interface{}{1}) {
+		t.Fail()
+		t.Logf("%s: did not cancel peeked item", name)
+	}
+}
+
+func shouldTakeItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2}
+
+	for i := 1; i <= 6; i++ {
+		queue.Enqueue(i)
+	}
+
+	handles := queue.PeekHandles(isEven, 10)
+	taken := queue.Take(handles[2], handles[0])
+	stats := queue.Stats()
+
+	if !reflect.DeepEqual(taken, []interface{}{2, 6}) || !reflect.DeepEqual(queue.Snapshot(), []interface{}{1, 3, 4, 5}) ||
+		stats.Dequeued != 2 || stats.Removed != 0 || queue.Take(handles[0]) != nil {
+		t.Fail()
+		t.Logf("%s: did not take items %v", name, taken)
+	}
+}
+
+func shouldSkipGoneItems(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(2)
+	queue.Enqueue(4)
+
+	handles := queue.PeekHandles(isEven, 10)
+	queue.Dequeue()
+
+	if taken := queue.Take(handles...); !reflect.DeepEqual(taken, []interface{}{4}) || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not skip dequeued item %v", name, taken)
+	}
+}
+
+func shouldSkipOtherQueues(t *testing.T, name string) {
+	a, b := &conq.Queue{}, &conq.Queue{}
+	a.Enqueue(2)
+	b.Enqueue(2)
+
+	if taken := b.Take(a.PeekHandles(isEven, 1)...); taken != nil || a.Len() != 1 || b.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: took item of other queue %v", name, taken)
+	}
+}
+
+func shouldReleaseTakenBudget(t *testing.T, name string) {
+	group := &conq.Group{MaxLen: 2}
+	queue := &conq.Queue{}
+	group.Add(queue)
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+
+	queue.Take(queue.PeekHandles(isEven, 1)...)
+
+	if group.Len() != 1 || queue.TryEnqueue(3) != nil {
+		t.Fail()
+		t.Logf("%s: did not release budget of taken item", name)
+	}
+}
+
+func shouldCancelEnqueuedItem(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2}
+	queue.Enqueue(1)
+	handle, _ := queue.EnqueueHandle(2)
+	queue.Enqueue(3)
+
+	if !handle.Cancel() || !reflect.DeepEqual(queue.Snapshot(), []interface{}{1, 3}) || queue.Stats().Removed != 1 {
+		t.Fail()
+		t.Logf("%s: did not cancel item", name)
+	}
+}
+
+func shouldCancelOwnItem(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2}
+	queue.Enqueue(1)
+	handle, _ := queue.EnqueueHandle(1)
+	queue.Enqueue(1)
+
+	if !handle.Cancel() || queue.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: did not cancel only its own item", name)
+	}
+}
+
+func shouldNotCancelDequeued(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2}
+	handle, _ := queue.EnqueueHandle(1)
+	queue.Enqueue(2)
+
+	if queue.Dequeue() != 1 || handle.Cancel() || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: cancelled dequeued item", name)
+	}
+}
+
+func shouldNotCancelTwice(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2}
+	handle, _ := queue.EnqueueHandle(1)
+
+	if !handle.Cancel() || handle.Cancel() {
+		t.Fail()
+		t.Logf("%s: cancelled item twice", name)
+	}
+}
+
+func shouldCancelTracedItem(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 2, Trace: true}
+	handle, _ := queue.EnqueueHandle(1)
+	queue.Enqueue(2)
+
+	if !handle.Cancel() || queue.Dequeue() != 2 {
+		t.Fail()
+		t.Logf("%s: did not cancel traced item", name)
+	}
+}
+
+func shouldNotHaveHandleOverLimit(t *testing.T, name string) {
+	queue := &conq.Queue{MaxHeapBytes: 1}
+
+	if handle, err := queue.EnqueueHandle(1); handle != nil || err != conq.ErrMemoryLimit {
+		t.Fail()
+		t.Logf("%s: had handle over memory limit", name)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"encoding/gob"
+	"io"
+)
+
+type handoffItem struct {
+	Item   interface{}
+	Poison bool
+}
+
+/*
+Handoff writes every item of the queue to the writer with encoding/gob and
+empties the queue, so a replacement process can Restore the items during a
+restart instead of dropping queued work. The writer can be a temp file, or a
+socket or pipe inherited by the replacement process. Items must be types that
+gob can encode, and types other than the basic types must be registered with
+gob.Register in both processes. Poison items are handed off as well.
+
+Handoff locks the queue until all items are written, so producers and
+consumers should be stopped first. If an item cannot be encoded or written,
+Handoff returns the error and leaves every item in the queue. Otherwise it
+returns how many items were handed off. Items lose their handles and traces.
+*/
+func (q *Queue) Handoff(w io.Writer) (int, error) {
+	q.lock()
+	defer q.unlock()
+
+	enc := gob.NewEncoder(w)
+
+	for c, start := q.head, q.rx; c != nil; c, start = c.next, 0 {
+		for _, val := range c.items[start:q.end(c)] {
+			h := handoffItem{Item: untrace(val)}
+			if h.Item == ErrClosed {
+				h = handoffItem{Poison: true}
+			}
+
+			if err := enc.Encode(&h); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n := q.len
+	for q.len > 0 {
+		val, _ := q.dequeue()
+		q.unadmit(untrace(val))
+	}
+
+	q.notify()
+
+	return n, nil
+}
+
+/*
+Restore reads items written by Handoff from the reader and enqueues them in
+the same order, until the reader returns io.EOF. It returns how many items
+were enqueued, and the first error reading, decoding, or enqueuing an item, in
+which case the remaining items are not enqueued.
+*/
+func (q *Queue) Restore(r io.Reader) (int, error) {
+	dec := gob.NewDecoder(r)
+	n := 0
+
+	for {
+		var h handoffItem
+		if err := dec.Decode(&h); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		if h.Poison {
+			q.EnqueuePoison(1)
+		} else if err := q.TryEnqueue(h.Item); err != nil {
+			return n, err
+		}
+
+		n += 1
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+)
+
+type handoffJob struct {
+	ID   int
+	Name string
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func init() {
+	gob.Register(handoffJob{})
+}
+
+func TestQueue_Handoff(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should hand off items to restore": shouldHandOffItems,
+		"should keep items on failure":     shouldKeepItemsOnFailure,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_Restore(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should restore poison items": shouldRestorePoison,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldHandOffItems(t *testing.T, name string) {
+	old, replacement := &conq.Queue{Capacity: 2}, &conq.Queue{}
+	items := []interface{}{1, "a", []byte("b"), handoffJob{ID: 1, Name: "job"}, 2.5}
+	var buf bytes.Buffer
+
+	for _, item := range items {
+		old.Enqueue(item)
+	}
+
+	handed, err := old.Handoff(&buf)
+	restored, rerr := replacement.Restore(&buf)
+
+	if handed != 5 || restored != 5 || err != nil || rerr != nil || old.Len() != 0 || !reflect.DeepEqual(replacement.Snapshot(), items) {
+		t.Fail()
+		t.Logf("%s: did not hand off items %v: %v %v", name, replacement.Snapshot(), err, rerr)
+	}
+}
+
+func shouldKeepItemsOnFailure(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	queue.Enqueue(struct{ private int }{})
+
+	if _, err := queue.Handoff(&bytes.Buffer{}); err == nil || queue.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: did not keep unencodable items", name)
+	}
+
+	if _, err := queue.Handoff(failingWriter{}); err == nil || queue.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: did not keep items for failing writer", name)
+	}
+}
+
+func shouldRestorePoison(t *testing.T, name string) {
+	old, replacement := &conq.Queue{}, &conq.Queue{}
+	var buf bytes.Buffer
+
+	old.Enqueue(1)
+	old.EnqueuePoison(1)
+	old.Handoff(&buf)
+	replacement.Restore(&buf)
+
+	if replacement.Dequeue() != 1 || replacement.Dequeue() != conq.ErrClosed {
+		t.Fail()
+		t.Logf("%s: did not restore poison item", name)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Health checks the stats of named queues against thresholds, for wiring queues
+into liveness and readiness probes like those of Kubernetes. A queue is
+degraded while it has more than MaxLen items, its oldest item has waited longer
+than MaxAge, or more than MaxDropRate of the items offered to it were dropped
+within the last Window. Items are dropped when Enqueue rejects them or a Group
+evicts them. MaxAge needs queues with TrackAge set, and a threshold of 0 is not
+checked.
+
+Healthz reports degraded queues, and Readyz also reports closed queues, which
+no longer take new work. Health is an http.Handler that serves Readyz for
+paths that end in "readyz" and Healthz for other paths.
+*/
+type Health struct {
+	MaxAge      time.Duration     // age of the oldest item that degrades a queue, unchecked when 0
+	MaxDropRate float64           // fraction of items dropped within a window that degrades a queue, unchecked when 0
+	MaxLen      int               // len that degrades a queue, unchecked when 0
+	Queues      map[string]*Queue // queues by name
+	Window      time.Duration     // time drop rates are measured over, defaults to 1m
+	base        map[string]Stats
+	baseTime    time.Time
+	mut         sync.Mutex
+}
+
+/*
+Healthz returns nil if no queue is degraded, or an error that names every
+degraded queue and why.
+*/
+func (h *Health) Healthz() error {
+	return h.check(false)
+}
+
+/*
+Readyz returns nil if no queue is degraded or closed, or an error that names
+every such queue and why.
+*/
+func (h *Health) Readyz() error {
+	return h.check(true)
+}
+
+/*
+ServeHTTP responds with 200 and "ok" when the check passes, and with 503 and
+the error of the check when it fails.
+*/
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	check := h.Healthz
+	if strings.HasSuffix(r.URL.Path, "readyz") {
+		check = h.Readyz
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if err := check(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *Health) check(ready bool) error {
+	names := make([]string, 0, len(h.Queues))
+	for name := range h.Queues {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	stats := make(map[string]Stats, len(names))
+	for _, name := range names {
+		stats[name] = h.Queues[name].Stats()
+	}
+
+	rates := h.dropRates(stats)
+
+	var problems []string
+	for _, name := range names {
+		s := stats[name]
+
+		if h.MaxLen > 0 && s.Len > h.MaxLen {
+			problems = append(problems, fmt.Sprintf("%s has %d items", name, s.Len))
+		}
+
+		if h.MaxAge > 0 && s.Oldest > h.MaxAge {
+			problems = append(problems, fmt.Sprintf("%s has waited %s", name, s.Oldest))
+		}
+
+		if h.MaxDropRate > 0 && rates[name] > h.MaxDropRate {
+			problems = append(problems, fmt.Sprintf("%s dropped %.1f%% of items", name, rates[name]*100))
+		}
+
+		if ready && h.Queues[name].Closed() {
+			problems = append(problems, fmt.Sprintf("%s is closed", name))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return errors.New("conq: " + strings.Join(problems, ", "))
+}
+
+func (h *Health) dropRates(stats map[string]Stats) map[string]float64 {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	window := h.Window
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	if h.base == nil {
+		h.base, h.baseTime = stats, time.Now()
+	}
+
+	rates := make(map[string]float64, len(stats))
+	for name, s := range stats {
+		base := h.base[name]
+		dropped := s.Dropped - base.Dropped
+		offered := s.Enqueued - base.Enqueued + s.Dropped - base.Dropped
+
+		if offered > 0 {
+			rates[name] = float64(dropped) / float64(offered)
+		}
+	}
+
+	if time.Since(h.baseTime) >= window {
+		h.base, h.baseTime = stats, time.Now()
+	}
+
+	return rates
+}
+
+func withTime(item interface{}, t time.Time) interface{} {
+	if e, ok := item.(*envelope); ok {
+		e.enqueued = t
+
+		return e
+	}
+
+	return &envelope{enqueued: t, item: item}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealth_Healthz(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should pass healthy queues":      shouldPassHealthy,
+		"should report long queues":       shouldReportLong,
+		"should report old items":         shouldReportOld,
+		"should report dropped items":     shouldReportDropped,
+		"should not report closed queues": shouldNotReportClosed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestHealth_Readyz(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should report closed queues": shouldReportClosed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestHealth_ServeHTTP(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should serve probe status": shouldServeProbeStatus,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldPassHealthy(t *testing.T, name string) {
+	queue := &conq.Queue{TrackAge: true}
+	health := &conq.Health{MaxAge: time.Minute, MaxDropRate: 0.1, MaxLen: 2, Queues: map[string]*conq.Queue{"jobs": queue}}
+
+	queue.Enqueue(1)
+
+	if err := health.Healthz(); err != nil {
+		t.Fail()
+		t.Logf("%s: reported healthy queue: %v", name, err)
+	}
+}
+
+func shouldReportLong(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	health := &conq.Health{MaxLen: 1, Queues: map[string]*conq.Queue{"jobs": queue}}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+
+	if err := health.Healthz(); err == nil || err.Error() != "conq: jobs has 2 items" {
+		t.Fail()
+		t.Logf("%s: did not report long queue: %v", name, err)
+	}
+}
+
+func shouldReportOld(t *testing.T, name string) {
+	queue := &conq.Queue{TrackAge: true}
+	health := &conq.Health{MaxAge: 5 * time.Millisecond, Queues: map[string]*conq.Queue{"jobs": queue}}
+
+	queue.Enqueue(1)
+	time.Sleep(10 * time.Millisecond)
+	queue.Enqueue(2)
+
+	if err := health.Healthz(); err == nil || !strings.HasPrefix(err.Error(), "conq: jobs has waited") {
+		t.Fail()
+		t.Logf("%s: did not report old item: %v", name, err)
+	}
+
+	queue.Dequeue()
+
+	if err := health.Healthz(); err != nil || queue.Stats().Oldest > 5*time.Millisecond {
+		t.Fail()
+		t.Logf("%s: reported new item: %v", name, err)
+	}
+}
+
+func shouldReportDropped(t *testing.T, name string) {
+	group := &conq.Group{MaxLen: 1}
+	queue := &conq.Queue{}
+	group.Add(queue)
+	health := &conq.Health{MaxDropRate: 0.4, Queues: map[string]*conq.Queue{"jobs": queue}}
+
+	health.Healthz()
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+
+	if err := health.Healthz(); err == nil || err.Error() != "conq: jobs dropped 50.0% of items" || queue.Stats().Dropped != 1 {
+		t.Fail()
+		t.Logf("%s: did not report dropped items: %v", name, err)
+	}
+}
+
+func shouldNotReportClosed(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	health := &conq.Health{Queues: map[string]*conq.Queue{"jobs": queue}}
+
+	queue.Close()
+
+	if err := health.Healthz(); err != nil {
+		t.Fail()
+		t.Logf("%s: reported closed queue: %v", name, err)
+	}
+}
+
+func shouldReportClosed(t *testing.T, name string) {
+	a, b := &conq.Queue{}, &conq.Queue{}
+	health := &conq.Health{MaxLen: 1, Queues: map[string]*conq.Queue{"a": a, "b": b}}
+
+	a.Close()
+	b.Enqueue(1)
+	b.Enqueue(2)
+
+	if err := health.Readyz(); err == nil || err.Error() != "conq: a is closed, b has 2 items" {
+		t.Fail()
+		t.Logf("%s: did not report closed queue: %v", name, err)
+	}
+}
+
+func shouldServeProbeStatus(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	health := &conq.Health{Queues: map[string]*conq.Queue{"jobs": queue}}
+
+	queue.Close()
+
+	live := httptest.NewRecorder()
+	health.ServeHTTP(live, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	ready := httptest.NewRecorder()
+	health.ServeHTTP(ready, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if live.Code != http.StatusOK || live.Body.String() != "ok\n" ||
+		ready.Code != http.StatusServiceUnavailable || ready.Body.String() != "conq: jobs is closed\n" {
+		t.Fail()
+		t.Logf("%s: did not serve probe status %d %q", name, ready.Code, ready.Body.String())
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"errors"
+)
+
+/*
+ErrInterrupted is returned by DequeueBlocking and DequeueUntil instead of an
+item, and by the Run of a Processor, Dispatcher, or Window and the Read of a
+Reader, when the queue is interrupted while they wait.
+*/
+var ErrInterrupted = errors.New("conq: queue interrupted")
+
+/*
+Interrupt wakes every call to DequeueBlocking or DequeueUntil that is waiting
+for an item, and every Processor, Dispatcher, Window, or Reader of the queue
+that is waiting for one, so coordinators can make workers re-evaluate their
+state without closing the queue. Each waiting call returns ErrInterrupted once,
+unless it finds an item first, and calls that start waiting after Interrupt are
+not interrupted. Waits that sleep on a Clock other than the system clock or a
+Sim are interrupted at their next poll cycle. The queue and its items are left
+as they are.
+*/
+func (q *Queue) Interrupt() {
+	q.lock()
+
+	if q.interrupted != nil {
+		close(q.interrupted)
+		q.interrupted = nil
+	}
+
+	q.unlock()
+}
+
+func (q *Queue) interruption() <-chan struct{} {
+	if q.interrupted == nil {
+		q.interrupted = make(chan struct{})
+	}
+
+	return q.interrupted
+}
+
+func isInterrupted(interrupted <-chan struct{}) bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+		return false
+	}
+}
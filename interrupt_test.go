@@ -0,0 +1,113 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+func TestQueue_Interrupt(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should interrupt every waiting dequeue": shouldInterruptWaitingDequeues,
+		"should not interrupt later dequeues":    shouldNotInterruptLaterDequeues,
+		"should not close queue":                 shouldNotCloseInterruptedQueue,
+		"should wake before the interval":        shouldWakeBeforeInterval,
+		"should interrupt processor":             shouldInterruptProcessor,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldInterruptWaitingDequeues(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	got := make([]interface{}, 2)
+
+	sim.Go(func() { got[0] = queue.DequeueBlocking(0, 100*time.Millisecond) })
+	sim.Go(func() { got[1] = queue.DequeueUntil(sim.Now().Add(time.Hour), time.Second) })
+	sim.Go(func() {
+		sim.Sleep(time.Second)
+		queue.Interrupt()
+	})
+
+	if err := sim.Run(); err != nil || got[0] != conq.ErrInterrupted || got[1] != conq.ErrInterrupted {
+		t.Fail()
+		t.Logf("%s: dequeued %v instead of interrupts: %v", name, got, err)
+	}
+}
+
+func shouldNotInterruptLaterDequeues(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	queue.Interrupt()
+
+	got := interface{}(0)
+	sim.Go(func() { got = queue.DequeueBlocking(time.Second, 100*time.Millisecond) })
+
+	if err := sim.Run(); err != nil || got != nil {
+		t.Fail()
+		t.Logf("%s: dequeued %v instead of timing out: %v", name, got, err)
+	}
+}
+
+func shouldNotCloseInterruptedQueue(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	queue.Enqueue(1)
+	queue.Interrupt()
+
+	if err := queue.TryEnqueue(2); err != nil || queue.DequeueBlocking(0, 0) != 1 || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: changed the queue: %v", name, err)
+	}
+}
+
+func shouldWakeBeforeInterval(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	result := make(chan interface{})
+
+	go func() { result <- queue.DequeueBlocking(0, time.Hour) }()
+
+	time.Sleep(10 * time.Millisecond)
+	queue.Interrupt()
+
+	select {
+	case got := <-result:
+		if got != conq.ErrInterrupted {
+			t.Fail()
+			t.Logf("%s: dequeued %v instead of an interrupt", name, got)
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+		t.Logf("%s: did not wake before the interval", name)
+	}
+}
+
+func shouldInterruptProcessor(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	processor := &conq.Processor{Source: queue, Key: func(interface{}) string { return "" }, Handle: func(interface{}) {}}
+	result := make(chan error)
+
+	go func() { result <- processor.Run(context.Background()) }()
+
+	time.Sleep(10 * time.Millisecond)
+	queue.Interrupt()
+
+	select {
+	case err := <-result:
+		if err != conq.ErrInterrupted {
+			t.Fail()
+			t.Logf("%s: returned %v instead of an interrupt", name, err)
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+		t.Logf("%s: did not interrupt processor", name)
+	}
+}
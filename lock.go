@@ -0,0 +1,63 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"sync"
+)
+
+/*
+NopLocker is a sync.Locker that does nothing. It can be the Locker of a queue
+that is only used by one goroutine, to remove the cost of locking.
+*/
+type NopLocker struct{}
+
+/*
+Lock does nothing.
+*/
+func (NopLocker) Lock() {}
+
+/*
+Unlock does nothing.
+*/
+func (NopLocker) Unlock() {}
+
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+func (q *Queue) lock() {
+	if q.Locker != nil {
+		q.Locker.Lock()
+	} else {
+		q.mut.Lock()
+	}
+}
+
+func (q *Queue) unlock() {
+	if q.Locker != nil {
+		q.Locker.Unlock()
+	} else {
+		q.mut.Unlock()
+	}
+}
+
+func (q *Queue) rlock() {
+	if l, ok := q.Locker.(rwLocker); ok {
+		l.RLock()
+	} else {
+		q.lock()
+	}
+}
+
+func (q *Queue) runlock() {
+	if l, ok := q.Locker.(rwLocker); ok {
+		l.RUnlock()
+	} else {
+		q.unlock()
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"sync"
+	"testing"
+)
+
+func TestQueue_Locker(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have concurrent items with rw lock": shouldHaveItemsRWLocker,
+		"should have items with nop lock":           shouldHaveItemsNopLocker,
+		"should publish writes with rw lock":        shouldPublishRWLocker,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldHaveItemsRWLocker(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, Locker: &sync.RWMutex{}}
+	isZero := func(item interface{}) bool { return item == 0 }
+	var wg sync.WaitGroup
+
+	wg.Add(200)
+	for i := 0; i < 100; i++ {
+		go func(i int) {
+			queue.Enqueue(i)
+			wg.Done()
+		}(i)
+
+		go func() {
+			queue.Len()
+			queue.Contains(isZero)
+			queue.Sample(2)
+			queue.Stats()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if queue.Len() != 100 || queue.Position(isZero) < 0 {
+		t.Fail()
+		t.Logf("%s: did not have correct items", name)
+	}
+}
+
+func shouldHaveItemsNopLocker(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, Locker: conq.NopLocker{}}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+
+	if queue.Dequeue() != 1 || queue.Dequeue() != 2 || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not have correct items", name)
+	}
+}
+
+func shouldPublishRWLocker(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 4, Locker: &sync.RWMutex{}}
+
+	checkPublished(t, name, func(item interface{}) { queue.Enqueue(item) }, queue.Dequeue)
+}
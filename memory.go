@@ -0,0 +1,43 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"errors"
+	"runtime/metrics"
+	"time"
+)
+
+const heapReadInterval = 10 * time.Millisecond
+
+/*
+ErrMemoryLimit is returned by TryEnqueue when the heap is larger than the
+MaxHeapBytes of the queue, and the item is not enqueued.
+*/
+var ErrMemoryLimit = errors.New("conq: memory limit exceeded")
+
+func (q *Queue) overMemory() bool {
+	if q.MaxHeapBytes == 0 {
+		return false
+	}
+
+	if now := time.Now(); now.Sub(q.heapRead) >= heapReadInterval {
+		q.heap = heapBytes()
+		q.heapRead = now
+	}
+
+	return q.heap > q.MaxHeapBytes
+}
+
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+
+	return sample[0].Value.Uint64()
+}
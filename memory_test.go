@@ -0,0 +1,39 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"testing"
+)
+
+func TestQueue_MaxHeapBytes(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should reject items over heap limit":  shouldRejectOverHeap,
+		"should accept items under heap limit": shouldAcceptUnderHeap,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldRejectOverHeap(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, MaxHeapBytes: 1}
+
+	if err := queue.TryEnqueue(1); err != conq.ErrMemoryLimit || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not reject item: %v", name, err)
+	}
+}
+
+func shouldAcceptUnderHeap(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, MaxHeapBytes: 1 << 40}
+
+	if err := queue.TryEnqueue(1); err != nil || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: did not accept item: %v", name, err)
+	}
+}
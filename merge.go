@@ -0,0 +1,152 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"time"
+)
+
+/*
+Merge dequeues the items of several queues in the order they were enqueued, for
+consumers that process events from several sources in one global order. Each
+dequeue takes the item at the head of the queues that was enqueued first. The
+queues must have TrackAge set, so the time each item was enqueued is recorded,
+and items enqueued at the same time are taken from the queue that comes first
+in Queues, so the merge is stable. Items that were enqueued before TrackAge was
+set count as enqueued at the zero time. The queues can still be used on their
+own, and a poison item at the head of a queue is dequeued as ErrClosed.
+*/
+type Merge struct {
+	Clock  Clock    // tells time and sleeps for DequeueBlocking, defaults to the system clock
+	Queues []*Queue // queues to merge
+}
+
+/*
+Dequeue removes and returns the item that was enqueued first from the heads of
+the queues. If no queue has items, no item is returned and the interface{} can
+be asserted against nil. Dequeue locks each queue in turn, but never more than
+one at once.
+*/
+func (m *Merge) Dequeue() interface{} {
+	for {
+		var oldest *Queue
+		var at time.Time
+		var token uint64
+
+		for _, q := range m.Queues {
+			t, tok, ok := q.headTime()
+			if ok && (oldest == nil || t.Before(at)) {
+				oldest, at, token = q, t, tok
+			}
+		}
+
+		if oldest == nil {
+			return nil
+		}
+
+		if item, ok := oldest.dequeueIfHead(token); ok {
+			return item
+		}
+	}
+}
+
+/*
+DequeueBlocking will attempt to retrieve the item that was enqueued first from
+the queues and block until one of the queues has an item. The timeout and
+interval work the same as they do for Queue.DequeueBlocking, and they are
+measured by the Clock of the merge. A change to any of the queues wakes waiting
+calls.
+*/
+func (m *Merge) DequeueBlocking(timeout time.Duration, interval time.Duration) interface{} {
+	clock := clockOr(m.Clock)
+	start := clock.Now()
+
+	changed := make([]<-chan struct{}, len(m.Queues))
+
+	for {
+		for i, q := range m.Queues {
+			q.lock()
+			changed[i] = q.wait()
+			q.unlock()
+		}
+
+		if item := m.Dequeue(); item != nil {
+			return item
+		}
+
+		waited := clock.Now().Sub(start)
+		if timeout > 0 && waited >= timeout {
+			return nil
+		}
+
+		sleep := interval
+		if timeout > 0 && (sleep <= 0 || sleep > timeout-waited) {
+			sleep = timeout - waited
+		}
+
+		pauseAny(clock, sleep, changed)
+	}
+}
+
+/*
+DequeueUntil will attempt to retrieve the item that was enqueued first and
+block until the deadline instead of for a timeout. It works the same as
+Queue.DequeueUntil, and the deadline is measured by the Clock of the merge.
+*/
+func (m *Merge) DequeueUntil(deadline time.Time, interval time.Duration) interface{} {
+	if deadline.IsZero() {
+		return m.DequeueBlocking(0, interval)
+	}
+
+	timeout := deadline.Sub(clockOr(m.Clock).Now())
+	if timeout <= 0 {
+		return m.Dequeue()
+	}
+
+	return m.DequeueBlocking(timeout, interval)
+}
+
+/*
+Len returns how many items are enqueued in all of the queues.
+*/
+func (m *Merge) Len() int {
+	n := 0
+	for _, q := range m.Queues {
+		n += q.Len()
+	}
+
+	return n
+}
+
+func (q *Queue) headTime() (time.Time, uint64, bool) {
+	q.rlock()
+	defer q.runlock()
+
+	if q.len == 0 {
+		return time.Time{}, 0, false
+	}
+
+	var t time.Time
+	if e, ok := q.head.items[q.rx].(*envelope); ok {
+		t = e.enqueued
+	}
+
+	return t, q.dequeued + q.removed, true
+}
+
+func (q *Queue) dequeueIfHead(token uint64) (interface{}, bool) {
+	q.lock()
+	defer q.unlock()
+
+	if q.len == 0 || q.dequeued+q.removed != token {
+		return nil, false
+	}
+
+	q.observeAge()
+	val, _ := q.dequeue()
+	q.notify()
+
+	return val, true
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMerge_Dequeue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should dequeue in enqueue order":  shouldMergeInEnqueueOrder,
+		"should break ties by queue order": shouldMergeTiesByQueueOrder,
+		"should return nil when empty":     shouldMergeEmpty,
+		"should dequeue poison as closed":  shouldMergePoison,
+		"should not lose concurrent items": shouldMergeConcurrently,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestMerge_DequeueBlocking(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should wait for item":   shouldMergeBlocking,
+		"should time out in sim": shouldMergeTimeOut,
+		"should wake on enqueue": shouldMergeWake,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestMerge_Len(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should sum lengths": shouldMergeLen,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func drainMerge(m *conq.Merge) []interface{} {
+	var items []interface{}
+	for item := m.Dequeue(); item != nil; item = m.Dequeue() {
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func shouldMergeInEnqueueOrder(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	a := &conq.Queue{Clock: sim, TrackAge: true}
+	b := &conq.Queue{Clock: sim, TrackAge: true}
+
+	for i, q := range []*conq.Queue{b, a, a, b, a} {
+		q.Enqueue(i)
+		sim.Sleep(time.Second)
+	}
+
+	got := drainMerge(&conq.Merge{Queues: []*conq.Queue{a, b}})
+
+	if !reflect.DeepEqual(got, []interface{}{0, 1, 2, 3, 4}) {
+		t.Fail()
+		t.Logf("%s: dequeued %v instead of enqueue order", name, got)
+	}
+}
+
+func shouldMergeTiesByQueueOrder(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	a := &conq.Queue{Clock: sim, TrackAge: true}
+	b := &conq.Queue{Clock: sim, TrackAge: true}
+
+	b.Enqueue("b")
+	a.Enqueue("a")
+
+	got := drainMerge(&conq.Merge{Queues: []*conq.Queue{a, b}})
+
+	if !reflect.DeepEqual(got, []interface{}{"a", "b"}) {
+		t.Fail()
+		t.Logf("%s: dequeued %v instead of queue order", name, got)
+	}
+}
+
+func shouldMergeEmpty(t *testing.T, name string) {
+	m := &conq.Merge{Queues: []*conq.Queue{{TrackAge: true}, {TrackAge: true}}}
+
+	if item := m.Dequeue(); item != nil {
+		t.Fail()
+		t.Logf("%s: dequeued %v from empty queues", name, item)
+	}
+}
+
+func shouldMergePoison(t *testing.T, name string) {
+	a := &conq.Queue{TrackAge: true}
+	a.EnqueuePoison(1)
+
+	if item := (&conq.Merge{Queues: []*conq.Queue{a}}).Dequeue(); item != conq.ErrClosed {
+		t.Fail()
+		t.Logf("%s: dequeued %v instead of ErrClosed", name, item)
+	}
+}
+
+func shouldMergeConcurrently(t *testing.T, name string) {
+	a := &conq.Queue{TrackAge: true}
+	b := &conq.Queue{TrackAge: true}
+	m := &conq.Merge{Queues: []*conq.Queue{a, b}}
+	n := 1000
+
+	for i := 0; i < n; i++ {
+		a.Enqueue(i)
+		b.Enqueue(n + i)
+	}
+
+	var mut sync.Mutex
+	seen := make(map[interface{}]bool)
+
+	var wg sync.WaitGroup
+	for c := 0; c < 4; c++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for item := m.Dequeue(); item != nil; item = m.Dequeue() {
+				mut.Lock()
+				seen[item] = true
+				mut.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(seen) != 2*n || m.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: dequeued %d distinct items instead of %d", name, len(seen), 2*n)
+	}
+}
+
+func shouldMergeBlocking(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	a := &conq.Queue{Clock: sim, TrackAge: true}
+	m := &conq.Merge{Clock: sim, Queues: []*conq.Queue{a}}
+
+	var got interface{}
+	sim.Go(func() { got = m.DequeueBlocking(time.Minute, time.Second) })
+	sim.Go(func() {
+		sim.Sleep(30 * time.Second)
+		a.Enqueue(1)
+	})
+
+	if err := sim.Run(); err != nil || got != 1 {
+		t.Fail()
+		t.Logf("%s: dequeued %v with error %v", name, got, err)
+	}
+}
+
+func shouldMergeTimeOut(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	m := &conq.Merge{Clock: sim, Queues: []*conq.Queue{{Clock: sim, TrackAge: true}}}
+
+	got := interface{}(0)
+	sim.Go(func() { got = m.DequeueBlocking(time.Minute, time.Second) })
+
+	if err := sim.Run(); err != nil || got != nil {
+		t.Fail()
+		t.Logf("%s: dequeued %v with error %v instead of timing out", name, got, err)
+	}
+}
+
+func shouldMergeLen(t *testing.T, name string) {
+	a := &conq.Queue{TrackAge: true}
+	b := &conq.Queue{TrackAge: true}
+	a.Enqueue(1)
+	b.Enqueue(2)
+	b.Enqueue(3)
+
+	if n := (&conq.Merge{Queues: []*conq.Queue{a, b}}).Len(); n != 3 {
+		t.Fail()
+		t.Logf("%s: returned len %d instead of 3", name, n)
+	}
+}
+
+func shouldMergeWake(t *testing.T, name string) {
+	for _, interval := range []time.Duration{0, time.Hour} {
+		sim := &conq.Sim{}
+		b := &conq.Queue{Clock: sim, TrackAge: true}
+		m := &conq.Merge{Clock: sim, Queues: []*conq.Queue{{Clock: sim, TrackAge: true}, b}}
+		start := sim.Now()
+
+		var got interface{}
+		var waited time.Duration
+		sim.Go(func() {
+			got = m.DequeueBlocking(0, interval)
+			waited = sim.Now().Sub(start)
+		})
+		sim.Go(func() {
+			sim.Sleep(time.Second)
+			b.Enqueue(1)
+		})
+
+		if err := sim.Run(); err != nil || got != 1 || waited != time.Second {
+			t.Fail()
+			t.Logf("%s: dequeued %v after %v with interval %v: %v", name, got, waited, interval, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+ErrNotFollower is returned by Promote when the queue is not a follower of the
+mirror.
+*/
+var ErrNotFollower = errors.New("conq: queue is not a follower")
+
+/*
+Mirror asynchronously copies every change to a leader queue to its Followers,
+so a follower can be promoted to take over from the leader as a warm standby.
+Enqueued items are enqueued into the followers, dequeued items are dequeued
+from them, and removed or cancelled items are removed from them, in the order
+the changes were made to the leader.
+
+Changes are recorded while the leader is locked, and Run applies them to the
+followers in the background, so the followers lag behind the leader. Followers
+should only be read, like with Len or Snapshot, until they are promoted, and
+they must be empty when they are added. Followers get the items of the leader
+without their handles, budgets, or traces.
+*/
+type Mirror struct {
+	Followers []*Queue // queues that copy the leader
+	applying  sync.Mutex
+	changed   chan struct{}
+	leader    *Queue
+	mut       sync.Mutex
+	ops       []mirrorOp
+}
+
+type mirrorOp struct {
+	dequeue bool
+	item    interface{}
+	removed []int
+}
+
+/*
+Attach makes the queue the leader of the mirror. The items already in the
+leader are copied to the followers first. A queue can only be the leader of one
+mirror.
+*/
+func (m *Mirror) Attach(leader *Queue) {
+	leader.lock()
+	defer leader.unlock()
+
+	m.mut.Lock()
+	m.leader = leader
+	m.mut.Unlock()
+
+	for c, start := leader.head, leader.rx; c != nil; c, start = c.next, 0 {
+		for _, val := range c.items[start:leader.end(c)] {
+			m.record(mirrorOp{item: untrace(val)})
+		}
+	}
+
+	leader.mirror = m
+}
+
+/*
+Run applies the changes of the leader to the followers until the context is
+done, and it returns the error of the context.
+*/
+func (m *Mirror) Run(ctx context.Context) error {
+	for {
+		m.mut.Lock()
+		changed := m.wait()
+		m.mut.Unlock()
+
+		m.apply()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+			break
+		}
+	}
+}
+
+/*
+Lag returns how many changes of the leader have not been applied to the
+followers yet.
+*/
+func (m *Mirror) Lag() int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	return len(m.ops)
+}
+
+/*
+Promote makes a follower the leader of the mirror, for when the leader can no
+longer be used. Changes to the old leader stop being mirrored, the changes that
+were already recorded are applied to the followers, and the remaining followers
+mirror the promoted follower from then on. If the queue is not a follower,
+Promote returns ErrNotFollower.
+*/
+func (m *Mirror) Promote(follower *Queue) error {
+	m.mut.Lock()
+	i := 0
+	for i < len(m.Followers) && m.Followers[i] != follower {
+		i += 1
+	}
+	leader := m.leader
+	m.mut.Unlock()
+
+	if i == len(m.Followers) {
+		return ErrNotFollower
+	}
+
+	if leader != nil {
+		leader.lock()
+		leader.mirror = nil
+		leader.unlock()
+	}
+
+	m.apply()
+
+	follower.lock()
+	defer follower.unlock()
+
+	m.mut.Lock()
+	m.Followers = append(m.Followers[:i:i], m.Followers[i+1:]...)
+	m.leader = follower
+	m.mut.Unlock()
+
+	follower.mirror = m
+
+	return nil
+}
+
+func (m *Mirror) record(op mirrorOp) {
+	m.mut.Lock()
+	m.ops = append(m.ops, op)
+	m.notify()
+	m.mut.Unlock()
+}
+
+func (m *Mirror) apply() {
+	m.applying.Lock()
+	defer m.applying.Unlock()
+
+	m.mut.Lock()
+	ops, followers := m.ops, m.Followers
+	m.ops = nil
+	m.mut.Unlock()
+
+	for _, follower := range followers {
+		follower.lock()
+
+		for _, op := range ops {
+			follower.applyOp(op)
+		}
+
+		follower.notify()
+		follower.unlock()
+	}
+}
+
+func (m *Mirror) notify() {
+	if m.changed != nil {
+		close(m.changed)
+		m.changed = nil
+	}
+}
+
+func (m *Mirror) wait() <-chan struct{} {
+	if m.changed == nil {
+		m.changed = make(chan struct{})
+	}
+
+	return m.changed
+}
+
+func (q *Queue) applyOp(op mirrorOp) {
+	switch {
+	case op.dequeue:
+		q.dequeue()
+	case op.removed != nil:
+		i, j := 0, 0
+
+		q.filter(func(interface{}) bool {
+			keep := j == len(op.removed) || op.removed[j] != i
+			if !keep {
+				j += 1
+			}
+
+			i += 1
+
+			return keep
+		})
+	default:
+		q.enqueue(op.item)
+	}
+}
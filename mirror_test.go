@@ -0,0 +1,112 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMirror_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should mirror changes in background": shouldMirrorInBackground,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestMirror_Promote(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should promote follower with changes": shouldPromoteFollower,
+		"should mirror promoted follower":      shouldMirrorPromoted,
+		"should not promote other queue":       shouldNotPromoteOther,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldMirrorInBackground(t *testing.T, name string) {
+	leader, follower := &conq.Queue{Capacity: 2}, &conq.Queue{}
+	mirror := &conq.Mirror{Followers: []*conq.Queue{follower}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mirror.Attach(leader)
+	go mirror.Run(ctx)
+
+	for i := 0; i < 10; i++ {
+		leader.Enqueue(i)
+	}
+
+	leader.Dequeue()
+
+	for deadline := time.Now().Add(time.Second); follower.Len() != 9 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	if !reflect.DeepEqual(follower.Snapshot(), leader.Snapshot()) {
+		t.Fail()
+		t.Logf("%s: did not mirror changes", name)
+	}
+}
+
+func shouldPromoteFollower(t *testing.T, name string) {
+	leader, follower := &conq.Queue{Capacity: 2}, &conq.Queue{}
+	mirror := &conq.Mirror{Followers: []*conq.Queue{follower}}
+
+	leader.Enqueue(1)
+	mirror.Attach(leader)
+
+	for i := 2; i < 8; i++ {
+		leader.Enqueue(i)
+	}
+
+	handle, _ := leader.EnqueueHandle(8)
+	leader.Enqueue(9)
+	leader.Dequeue()
+	leader.Remove(4)
+	leader.Remove(6)
+	handle.Cancel()
+
+	if err := mirror.Promote(follower); err != nil || mirror.Lag() != 0 ||
+		!reflect.DeepEqual(follower.Snapshot(), []interface{}{2, 3, 5, 7, 9}) {
+		t.Fail()
+		t.Logf("%s: did not promote follower %v: %v", name, follower.Snapshot(), err)
+	}
+}
+
+func shouldMirrorPromoted(t *testing.T, name string) {
+	leader, a, b := &conq.Queue{}, &conq.Queue{}, &conq.Queue{}
+	mirror := &conq.Mirror{Followers: []*conq.Queue{a, b}}
+
+	mirror.Attach(leader)
+	leader.Enqueue(1)
+	mirror.Promote(a)
+	leader.Enqueue(2)
+	a.Enqueue(3)
+	mirror.Promote(b)
+
+	if !reflect.DeepEqual(b.Snapshot(), []interface{}{1, 3}) || len(mirror.Followers) != 0 {
+		t.Fail()
+		t.Logf("%s: did not mirror promoted follower %v", name, b.Snapshot())
+	}
+}
+
+func shouldNotPromoteOther(t *testing.T, name string) {
+	mirror := &conq.Mirror{Followers: []*conq.Queue{{}}}
+	mirror.Attach(&conq.Queue{})
+
+	if err := mirror.Promote(&conq.Queue{}); err != conq.ErrNotFollower {
+		t.Fail()
+		t.Logf("%s: promoted other queue: %v", name, err)
+	}
+}
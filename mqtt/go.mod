@@ -0,0 +1,16 @@
+module github.com/sebuckler/conq/mqtt
+
+go 1.25.0
+
+require (
+	github.com/eclipse/paho.mqtt.golang v1.5.1
+	github.com/sebuckler/conq v0.0.0
+)
+
+require (
+	github.com/gorilla/websocket v1.5.3 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
+)
+
+replace github.com/sebuckler/conq => ../
@@ -0,0 +1,114 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+/*
+Package mqtt enqueues messages from an MQTT broker into conq queues, so edge
+gateways can batch device telemetry from many topics into one queue. It is a
+separate module, so the conq package itself does not depend on an MQTT client.
+
+A Source subscribes to topics with a Client, which is satisfied by mqtt.Client
+of the Eclipse Paho client, and enqueues each message as a Message with its
+topic and payload.
+
+Example code:
+
+	opts := mqtt.NewClientOptions().AddBroker("tcp://localhost:1883").SetAutoAckDisabled(true)
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	source := &conqmqtt.Source{Client: client, QoS: 1, Topics: []string{"devices/+/telemetry"}}
+	err := source.Run(ctx, queue)
+*/
+package mqtt
+
+import (
+	"context"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/sebuckler/conq"
+)
+
+/*
+Client is the part of an MQTT client that is used by a Source.
+*/
+type Client interface {
+	Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token
+	Unsubscribe(topics ...string) mqtt.Token
+}
+
+/*
+Message is the item a Source enqueues for each MQTT message by default.
+*/
+type Message struct {
+	Payload []byte // payload of the message
+	QoS     byte   // quality of service the message was delivered with
+	Topic   string // topic the message was published to
+}
+
+/*
+Source subscribes to MQTT topics and enqueues the messages into a conq queue.
+Decode turns each message into the item that is enqueued, and it defaults to a
+Message with the topic and payload of the message.
+
+Messages of QoS 1 and 2 are acknowledged once they are enqueued, and they are
+not acknowledged if TryEnqueue returns an error, like when the conq queue is
+over its memory limit, so a broker with a persistent session delivers them again
+after the client reconnects. This needs a client with automatic acknowledgements
+disabled, otherwise messages are acknowledged before they are enqueued. Messages
+of QoS 0 are never acknowledged, and they are dropped if they cannot be
+enqueued.
+
+Because conq queues have no acknowledgements, a message is only delivered at
+least once until it is enqueued. Once it is acknowledged, it is lost if the
+process stops before the item is dequeued.
+*/
+type Source struct {
+	Client Client                         // client the topics are subscribed with
+	Decode func(mqtt.Message) interface{} // turns messages into items, defaults to a Message
+	QoS    byte                           // most quality of service the messages are delivered with
+	Topics []string                       // topic filters to subscribe to
+}
+
+/*
+Run subscribes to the topics and enqueues the messages into the queue until the
+context is done, and it returns the error of the context after it unsubscribes.
+If a subscription fails, Run unsubscribes from the topics it subscribed to and
+returns the error.
+*/
+func (s *Source) Run(ctx context.Context, queue *conq.Queue) error {
+	handler := func(_ mqtt.Client, msg mqtt.Message) {
+		var item interface{} = Message{Payload: msg.Payload(), QoS: msg.Qos(), Topic: msg.Topic()}
+		if s.Decode != nil {
+			item = s.Decode(msg)
+		}
+
+		if queue.TryEnqueue(item) == nil {
+			msg.Ack()
+		}
+	}
+
+	for i, topic := range s.Topics {
+		if err := wait(ctx, s.Client.Subscribe(topic, s.QoS, handler)); err != nil {
+			if i > 0 {
+				s.Client.Unsubscribe(s.Topics[:i]...)
+			}
+
+			return err
+		}
+	}
+
+	<-ctx.Done()
+	s.Client.Unsubscribe(s.Topics...)
+
+	return ctx.Err()
+}
+
+func wait(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-token.Done():
+		return token.Error()
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package mqtt_test
+
+import (
+	"context"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/sebuckler/conq"
+	conqmqtt "github.com/sebuckler/conq/mqtt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeToken struct {
+	done chan struct{}
+	err  error
+}
+
+func newToken(err error) *fakeToken {
+	token := &fakeToken{done: make(chan struct{}), err: err}
+	close(token.done)
+
+	return token
+}
+
+func (t *fakeToken) Wait() bool                     { return true }
+func (t *fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (t *fakeToken) Done() <-chan struct{}          { return t.done }
+func (t *fakeToken) Error() error                   { return t.err }
+
+type fakeMessage struct {
+	acked   bool
+	payload string
+	qos     byte
+	topic   string
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return m.qos }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return []byte(m.payload) }
+func (m *fakeMessage) Ack()              { m.acked = true }
+
+type fakeClient struct {
+	fail         string
+	handlers     map[string]mqtt.MessageHandler
+	mut          sync.Mutex
+	subscribed   chan struct{}
+	unsubscribed []string
+}
+
+func (c *fakeClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if topic == c.fail {
+		return newToken(errors.New("not authorized"))
+	}
+
+	if c.handlers == nil {
+		c.handlers = make(map[string]mqtt.MessageHandler)
+	}
+
+	c.handlers[topic] = callback
+
+	if c.subscribed != nil {
+		c.subscribed <- struct{}{}
+	}
+
+	return newToken(nil)
+}
+
+func (c *fakeClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.unsubscribed = append(c.unsubscribed, topics...)
+
+	return newToken(nil)
+}
+
+func (c *fakeClient) deliver(msg *fakeMessage) {
+	c.mut.Lock()
+	handler := c.handlers[msg.topic]
+	c.mut.Unlock()
+
+	handler(nil, msg)
+}
+
+func TestSource_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should enqueue and acknowledge messages":      shouldEnqueueAckMessages,
+		"should not acknowledge messages not enqueued": shouldNotAckNotEnqueued,
+		"should unsubscribe when subscription fails":   shouldUnsubscribeOnFailure,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func runSource(client *fakeClient, source *conqmqtt.Source, queue *conq.Queue, msgs ...*fakeMessage) error {
+	client.subscribed = make(chan struct{}, len(source.Topics))
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+
+	go func() {
+		result <- source.Run(ctx, queue)
+	}()
+
+	for range source.Topics {
+		<-client.subscribed
+	}
+
+	for _, msg := range msgs {
+		client.deliver(msg)
+	}
+
+	cancel()
+
+	return <-result
+}
+
+func shouldEnqueueAckMessages(t *testing.T, name string) {
+	client := &fakeClient{}
+	source := &conqmqtt.Source{Client: client, QoS: 1, Topics: []string{"a", "b"}}
+	queue := &conq.Queue{}
+	a, b := &fakeMessage{payload: "1", qos: 1, topic: "a"}, &fakeMessage{payload: "2", topic: "b"}
+
+	err := runSource(client, source, queue, a, b)
+
+	want := []interface{}{
+		conqmqtt.Message{Payload: []byte("1"), QoS: 1, Topic: "a"},
+		conqmqtt.Message{Payload: []byte("2"), Topic: "b"},
+	}
+
+	if err != context.Canceled || !a.acked || !reflect.DeepEqual(queue.Snapshot(), want) || !reflect.DeepEqual(client.unsubscribed, []string{"a", "b"}) {
+		t.Fail()
+		t.Logf("%s: did not enqueue and acknowledge messages %v: %v", name, queue.Snapshot(), err)
+	}
+}
+
+func shouldNotAckNotEnqueued(t *testing.T, name string) {
+	client := &fakeClient{}
+	source := &conqmqtt.Source{Client: client, QoS: 2, Topics: []string{"a"}}
+	group := &conq.Group{MaxLen: 1}
+	queue := &conq.Queue{}
+	group.Add(queue)
+	a, b := &fakeMessage{payload: "1", qos: 2, topic: "a"}, &fakeMessage{payload: "2", qos: 2, topic: "a"}
+
+	runSource(client, source, queue, a, b)
+
+	if queue.Len() != 1 || !a.acked || b.acked {
+		t.Fail()
+		t.Logf("%s: acknowledged message not enqueued", name)
+	}
+}
+
+func shouldUnsubscribeOnFailure(t *testing.T, name string) {
+	client := &fakeClient{fail: "b"}
+	source := &conqmqtt.Source{Client: client, Topics: []string{"a", "b", "c"}}
+
+	if err := source.Run(context.Background(), &conq.Queue{}); err == nil || !reflect.DeepEqual(client.unsubscribed, []string{"a"}) {
+		t.Fail()
+		t.Logf("%s: did not unsubscribe %v: %v", name, client.unsubscribed, err)
+	}
+}
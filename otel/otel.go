@@ -0,0 +1,135 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+/*
+Package otel registers OpenTelemetry metric instruments for conq queues, so
+queue metrics flow through an existing OpenTelemetry pipeline. It is a separate
+module, so the conq package itself does not depend on OpenTelemetry.
+
+Example code:
+
+	meter := otel.GetMeterProvider().Meter("myapp")
+	registration, err := conqotel.Register(meter, "jobs", queue)
+	if err != nil {
+		return err
+	}
+	defer registration.Unregister()
+*/
+package otel
+
+import (
+	"context"
+	"errors"
+	"github.com/sebuckler/conq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+/*
+ErrReservedLabel is returned by Register when a label of the queue has the key
+queue, which is the attribute with the name of the queue, or le, which
+Prometheus reserves for the bounds of histogram buckets.
+*/
+var ErrReservedLabel = errors.New("conq/otel: label key is reserved")
+
+/*
+Register registers instruments with the meter that observe the Stats of the
+queue each time metrics are collected. Every observation has a queue attribute
+with the name of the queue and an attribute for each of the Labels of the
+queue. The instruments are:
+
+	conq.queue.depth        gauge of how many items are enqueued
+	conq.queue.enqueued     counter of how many items were enqueued
+	conq.queue.dequeued     counter of how many items were dequeued
+	conq.queue.removed      counter of items removed without being dequeued
+	conq.queue.dropped      counter of items that were rejected or evicted
+	conq.queue.time.count   counter of dequeued items with a tracked time in queue
+	conq.queue.time.sum     counter of seconds that dequeued items were enqueued
+
+OpenTelemetry has no asynchronous histogram, so only the count and sum of the
+QueueTime histogram of the Stats are observed, which give the mean time in
+queue over any period. The queue must have TrackAge set for the time in queue
+to be tracked.
+
+If a label of the queue has a reserved key, Register returns ErrReservedLabel.
+
+The Stats are read once per collection for all of the instruments. Unregister
+the returned registration to stop observing the queue.
+*/
+func Register(meter metric.Meter, name string, queue *conq.Queue) (metric.Registration, error) {
+	kvs := []attribute.KeyValue{attribute.String("queue", name)}
+	for k, v := range queue.Labels {
+		if k == "queue" || k == "le" {
+			return nil, ErrReservedLabel
+		}
+
+		kvs = append(kvs, attribute.String(k, v))
+	}
+
+	depth, err := meter.Int64ObservableGauge("conq.queue.depth",
+		metric.WithDescription("Number of items enqueued."),
+		metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+
+	enqueued, err := meter.Int64ObservableCounter("conq.queue.enqueued",
+		metric.WithDescription("Number of items enqueued."),
+		metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+
+	dequeued, err := meter.Int64ObservableCounter("conq.queue.dequeued",
+		metric.WithDescription("Number of items dequeued."),
+		metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := meter.Int64ObservableCounter("conq.queue.removed",
+		metric.WithDescription("Number of items removed without being dequeued."),
+		metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+
+	dropped, err := meter.Int64ObservableCounter("conq.queue.dropped",
+		metric.WithDescription("Number of items rejected by enqueue or evicted."),
+		metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+
+	timeCount, err := meter.Int64ObservableCounter("conq.queue.time.count",
+		metric.WithDescription("Number of dequeued items with a tracked time in queue."),
+		metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+
+	timeSum, err := meter.Float64ObservableCounter("conq.queue.time.sum",
+		metric.WithDescription("Total time dequeued items were enqueued."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := metric.WithAttributes(kvs...)
+
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := queue.Stats()
+		queueTime := stats.QueueTime
+
+		o.ObserveInt64(depth, int64(stats.Len), attrs)
+		o.ObserveInt64(enqueued, int64(stats.Enqueued), attrs)
+		o.ObserveInt64(dequeued, int64(stats.Dequeued), attrs)
+		o.ObserveInt64(removed, int64(stats.Removed), attrs)
+		o.ObserveInt64(dropped, int64(stats.Dropped), attrs)
+		o.ObserveInt64(timeCount, int64(queueTime.Count), attrs)
+		o.ObserveFloat64(timeSum, queueTime.Sum.Seconds(), attrs)
+
+		return nil
+	}, depth, enqueued, dequeued, removed, dropped, timeCount, timeSum)
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package otel_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	conqotel "github.com/sebuckler/conq/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"testing"
+	"time"
+)
+
+func TestRegister(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should observe queue stats":       shouldObserveStats,
+		"should stop observing unregister": shouldStopObserving,
+		"should observe queue labels":      shouldObserveLabels,
+		"should observe time in queue":     shouldObserveQueueTime,
+		"should reject reserved labels":    shouldRejectReservedLabels,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldObserveStats(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	if _, err := conqotel.Register(meter, "jobs", queue); err != nil {
+		t.Fatalf("%s: could not register: %v", name, err)
+	}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	queue.Enqueue(3)
+	queue.Dequeue()
+	queue.Remove(3)
+	queue.Close()
+	queue.Enqueue(4)
+
+	actual := collect(t, reader)
+
+	if actual["conq.queue.depth"] != 1 || actual["conq.queue.enqueued"] != 3 ||
+		actual["conq.queue.dequeued"] != 1 || actual["conq.queue.removed"] != 1 ||
+		actual["conq.queue.dropped"] != 1 {
+		t.Fail()
+		t.Logf("%s: did not observe stats %v", name, actual)
+	}
+}
+
+func shouldStopObserving(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	registration, err := conqotel.Register(meter, "jobs", queue)
+	if err != nil {
+		t.Fatalf("%s: could not register: %v", name, err)
+	}
+
+	if err := registration.Unregister(); err != nil || len(collect(t, reader)) != 0 {
+		t.Fail()
+		t.Logf("%s: did not stop observing: %v", name, err)
+	}
+}
+
+func shouldObserveLabels(t *testing.T, name string) {
+	queue := &conq.Queue{Labels: map[string]string{"tenant": "acme"}}
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	if _, err := conqotel.Register(meter, "jobs", queue); err != nil {
+		t.Fatalf("%s: could not register: %v", name, err)
+	}
+
+	var data metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatalf("%s: could not collect: %v", name, err)
+	}
+
+	labeled := 0
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if gauge, ok := m.Data.(metricdata.Gauge[int64]); ok {
+				for _, point := range gauge.DataPoints {
+					if v, _ := point.Attributes.Value("tenant"); v.AsString() == "acme" {
+						labeled += 1
+					}
+				}
+			}
+		}
+	}
+
+	if labeled != 1 {
+		t.Fail()
+		t.Logf("%s: observed %d labeled depths", name, labeled)
+	}
+}
+
+func shouldObserveQueueTime(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, TrackAge: true}
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	if _, err := conqotel.Register(meter, "jobs", queue); err != nil {
+		t.Fatalf("%s: could not register: %v", name, err)
+	}
+
+	queue.Enqueue(1)
+	sim.Sleep(50 * time.Millisecond)
+	queue.Dequeue()
+
+	var data metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatalf("%s: could not collect: %v", name, err)
+	}
+
+	var count int64
+	var sum float64
+
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch agg := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, point := range agg.DataPoints {
+					if m.Name == "conq.queue.time.count" {
+						count = point.Value
+					}
+				}
+			case metricdata.Sum[float64]:
+				for _, point := range agg.DataPoints {
+					sum = point.Value
+				}
+			}
+		}
+	}
+
+	if count != 1 || sum != 0.05 {
+		t.Fail()
+		t.Logf("%s: observed %d items in %vs", name, count, sum)
+	}
+}
+
+func shouldRejectReservedLabels(t *testing.T, name string) {
+	meter := sdkmetric.NewMeterProvider().Meter("test")
+
+	for _, key := range []string{"queue", "le"} {
+		queue := &conq.Queue{Labels: map[string]string{key: "a"}}
+
+		if _, err := conqotel.Register(meter, "jobs", queue); err != conqotel.ErrReservedLabel {
+			t.Fail()
+			t.Logf("%s: did not reject label %s: %v", name, key, err)
+		}
+	}
+}
+
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	var data metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &data); err != nil {
+		t.Fatalf("could not collect: %v", err)
+	}
+
+	values := make(map[string]int64)
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch agg := m.Data.(type) {
+			case metricdata.Gauge[int64]:
+				for _, point := range agg.DataPoints {
+					if v, _ := point.Attributes.Value("queue"); v.AsString() == "jobs" {
+						values[m.Name] = point.Value
+					}
+				}
+			case metricdata.Sum[int64]:
+				for _, point := range agg.DataPoints {
+					if v, _ := point.Attributes.Value("queue"); v.AsString() == "jobs" {
+						values[m.Name] = point.Value
+					}
+				}
+			}
+		}
+	}
+
+	return values
+}
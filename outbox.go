@@ -0,0 +1,102 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+/*
+Outbox polls an outbox table of a database and enqueues its rows into a queue,
+for the transactional outbox pattern, where work is written to the database in
+the same transaction as the change that caused it.
+
+Each poll begins a transaction on DB and calls Fetch to select the rows that
+are not dispatched yet, like with SELECT ... FOR UPDATE SKIP LOCKED, and to
+turn them into items. The items are enqueued in order, and Mark is called with
+the items that were enqueued to mark their rows as dispatched in the same
+transaction before it is committed. If an item is not enqueued, like when the
+queue is over its memory limit, it and the items after it are left for the
+next poll.
+
+A poll that enqueues items is followed by the next poll immediately, so a
+backlog is drained quickly, and otherwise the Outbox waits for the Interval.
+Items are enqueued before the transaction is committed, so an item is enqueued
+again by a later poll if Mark or the commit fails. Use a Processor to handle
+items with side effects only once.
+*/
+type Outbox struct {
+	DB       *sql.DB                                                          // database of the outbox table
+	Fetch    func(ctx context.Context, tx *sql.Tx) ([]interface{}, error)     // selects rows not dispatched as items
+	Interval time.Duration                                                    // time between polls that find no items, defaults to 1s
+	Mark     func(ctx context.Context, tx *sql.Tx, items []interface{}) error // marks the rows of items as dispatched
+	OnError  func(err error)                                                  // called with errors of polls, ignored when nil
+}
+
+/*
+Run polls the outbox and enqueues its rows into the queue until the context is
+done, and it returns the error of the context. A poll that fails is rolled back
+and its error is passed to OnError, and Run polls again after the Interval.
+*/
+func (o *Outbox) Run(ctx context.Context, queue *Queue) error {
+	interval := o.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			break
+		}
+
+		n, err := o.poll(ctx, queue)
+		if err != nil && ctx.Err() == nil && o.OnError != nil {
+			o.OnError(err)
+		}
+
+		if err == nil && n > 0 {
+			timer.Reset(0)
+		} else {
+			timer.Reset(interval)
+		}
+	}
+}
+
+func (o *Outbox) poll(ctx context.Context, queue *Queue) (int, error) {
+	tx, err := o.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	items, err := o.Fetch(ctx, tx)
+	if err != nil || len(items) == 0 {
+		return 0, err
+	}
+
+	n := 0
+	for n < len(items) && queue.TryEnqueue(items[n]) == nil {
+		n += 1
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	if err := o.Mark(ctx, tx, items[:n]); err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
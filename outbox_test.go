@@ -0,0 +1,196 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/sebuckler/conq"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeDB struct {
+	commits    int
+	dispatched map[interface{}]bool
+	failMark   bool
+	marked     []interface{}
+	mut        sync.Mutex
+	rollbacks  int
+	rows       []interface{}
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return c, nil }
+
+func (c *fakeConn) Commit() error {
+	c.db.mut.Lock()
+	defer c.db.mut.Unlock()
+
+	c.db.commits += 1
+
+	for _, row := range c.db.marked {
+		c.db.dispatched[row] = true
+	}
+
+	c.db.marked = nil
+
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.mut.Lock()
+	defer c.db.mut.Unlock()
+
+	c.db.rollbacks += 1
+	c.db.marked = nil
+
+	return nil
+}
+
+func (db *fakeDB) outbox() *conq.Outbox {
+	db.dispatched = make(map[interface{}]bool)
+
+	return &conq.Outbox{
+		DB: sql.OpenDB(db),
+		Fetch: func(ctx context.Context, tx *sql.Tx) ([]interface{}, error) {
+			db.mut.Lock()
+			defer db.mut.Unlock()
+
+			var items []interface{}
+			for _, row := range db.rows {
+				if !db.dispatched[row] {
+					items = append(items, row)
+				}
+			}
+
+			return items, nil
+		},
+		Interval: time.Millisecond,
+		Mark: func(ctx context.Context, tx *sql.Tx, items []interface{}) error {
+			db.mut.Lock()
+			defer db.mut.Unlock()
+
+			if db.failMark {
+				return errors.New("deadlock")
+			}
+
+			db.marked = append(db.marked, items...)
+
+			return nil
+		},
+	}
+}
+
+func (db *fakeDB) stats() (int, int, int) {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+
+	return db.commits, db.rollbacks, len(db.dispatched)
+}
+
+func TestOutbox_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should enqueue and mark rows":  shouldEnqueueMarkRows,
+		"should leave rejected rows":    shouldLeaveRejectedRows,
+		"should roll back failed polls": shouldRollBackFailedPolls,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func runOutbox(outbox *conq.Outbox, queue *conq.Queue, until func() bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+
+	go func() {
+		result <- outbox.Run(ctx, queue)
+	}()
+
+	for deadline := time.Now().Add(time.Second); !until() && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	return <-result
+}
+
+func shouldEnqueueMarkRows(t *testing.T, name string) {
+	db := &fakeDB{rows: []interface{}{1, 2, 3}}
+	queue := &conq.Queue{}
+
+	err := runOutbox(db.outbox(), queue, func() bool {
+		_, _, dispatched := db.stats()
+
+		return dispatched == 3
+	})
+
+	if commits, _, dispatched := db.stats(); err != context.Canceled || commits != 1 || dispatched != 3 || !reflect.DeepEqual(queue.Snapshot(), db.rows) {
+		t.Fail()
+		t.Logf("%s: did not enqueue and mark rows %v: %v", name, queue.Snapshot(), err)
+	}
+}
+
+func shouldLeaveRejectedRows(t *testing.T, name string) {
+	db := &fakeDB{rows: []interface{}{1, 2, 3}}
+	group := &conq.Group{MaxLen: 2}
+	queue := &conq.Queue{}
+	group.Add(queue)
+	var items []interface{}
+
+	err := runOutbox(db.outbox(), queue, func() bool {
+		if _, _, dispatched := db.stats(); dispatched == 2 && len(items) == 0 {
+			items = append(items, queue.Dequeue(), queue.Dequeue())
+		}
+
+		_, _, dispatched := db.stats()
+
+		return dispatched == 3
+	})
+
+	items = append(items, queue.Snapshot()...)
+
+	if err != context.Canceled || !reflect.DeepEqual(items, db.rows) {
+		t.Fail()
+		t.Logf("%s: did not leave rejected rows %v: %v", name, items, err)
+	}
+}
+
+func shouldRollBackFailedPolls(t *testing.T, name string) {
+	db := &fakeDB{failMark: true, rows: []interface{}{1}}
+	outbox := db.outbox()
+	queue := &conq.Queue{}
+	errs := make(chan error, 100)
+	outbox.OnError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	runOutbox(outbox, queue, func() bool {
+		return len(errs) >= 2
+	})
+
+	if commits, rollbacks, dispatched := db.stats(); commits != 0 || rollbacks < 2 || dispatched != 0 || queue.Len() < 2 {
+		t.Fail()
+		t.Logf("%s: did not roll back failed polls %d %d", name, commits, rollbacks)
+	}
+}
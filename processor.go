@@ -0,0 +1,150 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+DedupStore records the idempotency keys that a Processor has handled. Claim
+claims a key until a time, and it reports whether the key was free, which is
+when it was never claimed or its last claim has expired. A zero until claims
+the key forever. Claim must be safe to call from several goroutines, and a key
+must only be claimed once, even if it is claimed concurrently.
+*/
+type DedupStore interface {
+	Claim(key string, until time.Time) bool
+}
+
+/*
+LRUStore is an in-memory DedupStore that remembers the Size most recently
+claimed keys. Once it is full, the least recently claimed key is forgotten, so
+Size should be larger than the number of keys that can be duplicated within a
+window. Claims expire by the time of the Clock.
+*/
+type LRUStore struct {
+	Clock Clock // tells time for the expiry of claims, defaults to the system clock
+	Size  int   // most keys remembered, defaults to 10000
+	keys  map[string]*list.Element
+	mut   sync.Mutex
+	order list.List
+}
+
+type lruEntry struct {
+	key   string
+	until time.Time
+}
+
+/*
+Processor dequeues items from a Source queue and calls Handle with each of
+them, at most once per idempotency key within a Window, for handlers with side
+effects that are not safe to repeat. Key returns the idempotency key of an
+item, and items with a key that was already handled within the window are
+passed to OnDuplicate instead. A key is claimed before Handle is called, so an
+item whose handler fails is not handled again by a duplicate. The keys are
+claimed in Store, which defaults to an LRUStore with the Clock of the Source,
+and the Window is measured by that Clock.
+
+Queue has no acknowledgements, so an item is gone from the queue once it is
+dequeued. Processor makes duplicates safe, but it does not redeliver items.
+*/
+type Processor struct {
+	Handle      func(item interface{})   // handles each item once per key
+	Key         func(interface{}) string // idempotency key of an item
+	OnDuplicate func(item interface{})   // called with items that are not handled, ignored when nil
+	Source      *Queue                   // queue the items are dequeued from
+	Store       DedupStore               // claimed keys, defaults to an LRUStore
+	Window      time.Duration            // how long a key is claimed, forever when 0
+	once        sync.Once
+}
+
+/*
+Run dequeues and handles items until the context is done or a poison item is
+dequeued, and it returns the error of the context or ErrClosed. If the Source
+is interrupted while Run waits for an item, Run returns ErrInterrupted. Run can
+be called by several goroutines to handle items concurrently.
+*/
+func (p *Processor) Run(ctx context.Context) error {
+	p.once.Do(func() {
+		if p.Store == nil {
+			p.Store = &LRUStore{Clock: p.Source.Clock}
+		}
+	})
+
+	clock := clockOr(p.Source.Clock)
+
+	for {
+		item, smp, err := p.Source.dequeueSample(ctx)
+		if err != nil {
+			return err
+		}
+
+		if item == ErrClosed {
+			return ErrClosed
+		}
+
+		var until time.Time
+		if p.Window > 0 {
+			until = clock.Now().Add(p.Window)
+		}
+
+		start := clock.Now()
+
+		if p.Store.Claim(p.Key(item), until) {
+			p.Handle(item)
+			smp.handled(clock.Now().Sub(start), "handled")
+		} else {
+			if p.OnDuplicate != nil {
+				p.OnDuplicate(item)
+			}
+
+			smp.handled(clock.Now().Sub(start), "duplicate")
+		}
+	}
+}
+
+/*
+Claim claims a key until a time, and it reports whether the key was free.
+*/
+func (s *LRUStore) Claim(key string, until time.Time) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.keys == nil {
+		s.keys = make(map[string]*list.Element)
+	}
+
+	if e, ok := s.keys[key]; ok {
+		entry := e.Value.(*lruEntry)
+
+		if entry.until.IsZero() || clockOr(s.Clock).Now().Before(entry.until) {
+			return false
+		}
+
+		entry.until = until
+		s.order.MoveToFront(e)
+
+		return true
+	}
+
+	s.keys[key] = s.order.PushFront(&lruEntry{key: key, until: until})
+
+	size := s.Size
+	if size <= 0 {
+		size = 10000
+	}
+
+	for s.order.Len() > size {
+		e := s.order.Back()
+		s.order.Remove(e)
+		delete(s.keys, e.Value.(*lruEntry).key)
+	}
+
+	return true
+}
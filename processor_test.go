@@ -0,0 +1,167 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessor_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should handle each key once":              shouldHandleKeyOnce,
+		"should handle key again after window":     shouldHandleKeyAfterWindow,
+		"should handle each key once concurrently": shouldHandleKeyOnceConcurrently,
+		"should measure window by source clock":    shouldMeasureWindowBySim,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestLRUStore_Claim(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should forget least recent key": shouldForgetLeastRecentKey,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldHandleKeyOnce(t *testing.T, name string) {
+	var handled, duplicates []interface{}
+	processor := &conq.Processor{
+		Handle:      func(item interface{}) { handled = append(handled, item) },
+		Key:         keyOf,
+		OnDuplicate: func(item interface{}) { duplicates = append(duplicates, item) },
+		Source:      &conq.Queue{},
+	}
+
+	for _, item := range []int{1, 2, 11, 3, 21} {
+		processor.Source.Enqueue(item)
+	}
+
+	processor.Source.EnqueuePoison(1)
+
+	if err := processor.Run(context.Background()); err != conq.ErrClosed ||
+		!reflect.DeepEqual(handled, []interface{}{1, 2, 3}) ||
+		!reflect.DeepEqual(duplicates, []interface{}{11, 21}) {
+		t.Fail()
+		t.Logf("%s: did not handle keys once: %v %v", name, handled, duplicates)
+	}
+}
+
+func shouldHandleKeyAfterWindow(t *testing.T, name string) {
+	var handled []interface{}
+	processor := &conq.Processor{
+		Handle: func(item interface{}) { handled = append(handled, item) },
+		Key:    keyOf,
+		Source: &conq.Queue{},
+		Window: 10 * time.Millisecond,
+	}
+
+	processor.Source.Enqueue(1)
+	processor.Source.Enqueue(11)
+	processor.Source.EnqueuePoison(1)
+	processor.Run(context.Background())
+
+	time.Sleep(20 * time.Millisecond)
+	processor.Source.Enqueue(21)
+	processor.Source.EnqueuePoison(1)
+	processor.Run(context.Background())
+
+	if !reflect.DeepEqual(handled, []interface{}{1, 21}) {
+		t.Fail()
+		t.Logf("%s: did not handle key after window: %v", name, handled)
+	}
+}
+
+func shouldHandleKeyOnceConcurrently(t *testing.T, name string) {
+	var mut sync.Mutex
+	counts := map[string]int{}
+	processor := &conq.Processor{
+		Handle: func(item interface{}) {
+			mut.Lock()
+			counts[keyOf(item)] += 1
+			mut.Unlock()
+		},
+		Key:    keyOf,
+		Source: &conq.Queue{},
+	}
+	var wg sync.WaitGroup
+
+	for i := 0; i < 1000; i++ {
+		processor.Source.Enqueue(i)
+	}
+
+	processor.Source.EnqueuePoison(4)
+	wg.Add(4)
+
+	for i := 0; i < 4; i++ {
+		go func() {
+			processor.Run(context.Background())
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+
+	if len(counts) != 10 {
+		t.Fail()
+		t.Logf("%s: handled %d keys", name, len(counts))
+	}
+
+	for key, count := range counts {
+		if count != 1 {
+			t.Fail()
+			t.Logf("%s: handled key %s %d times", name, key, count)
+		}
+	}
+}
+
+func shouldMeasureWindowBySim(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	var handled, duplicates []interface{}
+	processor := &conq.Processor{
+		Handle:      func(item interface{}) { handled = append(handled, item) },
+		Key:         keyOf,
+		OnDuplicate: func(item interface{}) { duplicates = append(duplicates, item) },
+		Source:      &conq.Queue{Clock: sim},
+		Window:      time.Minute,
+	}
+
+	var err error
+	sim.Go(func() { err = processor.Run(context.Background()) })
+	sim.Go(func() {
+		processor.Source.Enqueue(1)
+		sim.Sleep(30 * time.Second)
+		processor.Source.Enqueue(11)
+		sim.Sleep(time.Minute)
+		processor.Source.Enqueue(21)
+		processor.Source.EnqueuePoison(1)
+	})
+
+	if simErr := sim.Run(); simErr != nil || err != conq.ErrClosed ||
+		!reflect.DeepEqual(handled, []interface{}{1, 21}) || !reflect.DeepEqual(duplicates, []interface{}{11}) {
+		t.Fail()
+		t.Logf("%s: did not measure window by clock: %v %v: %v %v", name, handled, duplicates, simErr, err)
+	}
+}
+
+func shouldForgetLeastRecentKey(t *testing.T, name string) {
+	store := &conq.LRUStore{Size: 2}
+
+	if !store.Claim("a", time.Time{}) || !store.Claim("b", time.Time{}) || store.Claim("a", time.Time{}) ||
+		!store.Claim("c", time.Time{}) || !store.Claim("a", time.Time{}) || store.Claim("c", time.Time{}) {
+		t.Fail()
+		t.Logf("%s: did not forget least recent key", name)
+	}
+}
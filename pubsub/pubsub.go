@@ -0,0 +1,305 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+/*
+Package pubsub moves messages between Google Cloud Pub/Sub and conq queues in
+both directions, so a conq queue can be the local buffer of a cloud consumer.
+It is a separate module, so the conq package itself does not depend on the
+Pub/Sub client.
+
+A Source receives messages from a Pub/Sub subscription and enqueues them into
+a conq queue, and a Sink dequeues items from a conq queue and publishes them to
+a Pub/Sub topic in batches. A Source uses a Subscriber, which is satisfied by
+*pubsub.Subscriber, and a Sink uses a Publisher, which is satisfied by
+*pubsub.Publisher. Failed calls are retried after a backoff that doubles after
+each failure in a row, from Backoff up to MaxBackoff.
+
+Example code:
+
+	client, err := pubsub.NewClient(ctx, "project")
+	if err != nil {
+		return err
+	}
+	source := &conqpubsub.Source{Subscriber: client.Subscriber("jobs")}
+	err = source.Run(ctx, queue)
+*/
+package pubsub
+
+import (
+	"cloud.google.com/go/pubsub/v2"
+	"context"
+	"errors"
+	"github.com/sebuckler/conq"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ErrUnencodable is returned by Sink.Run when an item is not []byte or a string,
+and the Sink has no Encode func.
+*/
+var ErrUnencodable = errors.New("conq/pubsub: item cannot be encoded")
+
+/*
+Subscriber is the part of a Pub/Sub subscriber that is used by a Source.
+*/
+type Subscriber interface {
+	Receive(ctx context.Context, f func(context.Context, *pubsub.Message)) error
+}
+
+/*
+Publisher is the part of a Pub/Sub publisher that is used by a Sink.
+*/
+type Publisher interface {
+	Publish(ctx context.Context, msg *pubsub.Message) *pubsub.PublishResult
+}
+
+/*
+Source receives messages from a Pub/Sub subscription and enqueues them into a
+conq queue. A message is acknowledged once it is enqueued. If TryEnqueue
+returns an error, like when the conq queue is over its memory limit, the Source
+waits for the Backoff before the message is rejected so Pub/Sub delivers it
+again, so a full queue does not make Pub/Sub redeliver messages in a hot loop.
+Decode turns each message into the item that is enqueued, and it defaults to
+the data of the message.
+
+The Subscriber delivers messages concurrently, so they are not enqueued in the
+order they were published, and the number of messages that are received but
+not enqueued yet is limited by the ReceiveSettings of the Subscriber. Because
+conq queues have no acknowledgements, a message is only delivered at least
+once until it is enqueued. Once it is acknowledged, it is lost if the process
+stops before the item is dequeued.
+*/
+type Source struct {
+	Backoff    time.Duration                     // first wait after a failed receive or before a rejection, defaults to 1s
+	Decode     func(*pubsub.Message) interface{} // turns messages into items, defaults to the data
+	MaxBackoff time.Duration                     // longest wait after failed receives, defaults to 1m
+	Subscriber Subscriber                        // subscriber the messages are received with
+}
+
+/*
+Sink dequeues items from a conq queue and publishes them to a Pub/Sub topic in
+batches of up to BatchSize. A batch is published as soon as there is an item,
+with as many more items as are already enqueued, and the Publisher bundles the
+messages of a batch into as few requests as it can. Items that Pub/Sub fails to
+accept are published again after the backoff, so items are not lost when
+Pub/Sub is unavailable. Encode turns each item into the message that is
+published, and it defaults to a message with the item as its data for []byte
+and string items.
+*/
+type Sink struct {
+	Backoff    time.Duration                                   // first wait after a failed publish, defaults to 1s
+	BatchSize  int                                             // most messages per batch, defaults to 100
+	Encode     func(item interface{}) (*pubsub.Message, error) // turns items into messages, defaults to the data
+	MaxBackoff time.Duration                                   // longest wait after failed publishes, defaults to 1m
+	Publisher  Publisher                                       // publisher the messages are published with
+	closed     bool
+	pending    []interface{}
+}
+
+type backoff struct {
+	first time.Duration
+	max   time.Duration
+	next  time.Duration
+}
+
+/*
+Run receives messages and enqueues them into the queue until the context is
+done or the queue is closed, and it returns the error of the context or
+conq.ErrClosed. If the Subscriber stops receiving with an error, Run receives
+again after the backoff.
+*/
+func (s *Source) Run(ctx context.Context, queue *conq.Queue) error {
+	wait := newBackoff(s.Backoff, s.MaxBackoff)
+
+	for {
+		receiving, stop := context.WithCancel(ctx)
+		var closed, received atomic.Bool
+		err := s.Subscriber.Receive(receiving, func(ctx context.Context, msg *pubsub.Message) {
+			received.Store(true)
+
+			var item interface{} = msg.Data
+			if s.Decode != nil {
+				item = s.Decode(msg)
+			}
+
+			switch err := queue.TryEnqueue(item); err {
+			case nil:
+				msg.Ack()
+
+				return
+			case conq.ErrClosed:
+				closed.Store(true)
+				stop()
+			default:
+				newBackoff(s.Backoff, s.MaxBackoff).sleep(ctx)
+			}
+
+			msg.Nack()
+		})
+		stop()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if closed.Load() {
+			return conq.ErrClosed
+		}
+
+		if err == nil || received.Load() {
+			wait.reset()
+		}
+
+		if err := wait.sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+Run dequeues items and publishes them until the context is done or a poison
+item is dequeued, and it returns the error of the context or conq.ErrClosed. If
+an item cannot be encoded, Run drops it and returns the error. Items that were
+dequeued but not published when Run returns are published first by the next
+call to Run, which also returns conq.ErrClosed once they are published if the
+poison item was already dequeued. Run must not be called concurrently.
+*/
+func (s *Sink) Run(ctx context.Context, queue *conq.Queue) error {
+	wait := newBackoff(s.Backoff, s.MaxBackoff)
+	size := s.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+
+	for {
+		if !s.closed && len(s.pending) == 0 {
+			if err := queue.WaitLen(ctx, 1); err != nil {
+				return err
+			}
+		}
+
+		for !s.closed && len(s.pending) < size {
+			item := queue.Dequeue()
+			if item == nil {
+				break
+			}
+
+			if item == conq.ErrClosed {
+				s.closed = true
+
+				break
+			}
+
+			s.pending = append(s.pending, item)
+		}
+
+		if err := s.publish(ctx, wait); err != nil {
+			return err
+		}
+
+		if s.closed {
+			for len(s.pending) > 0 {
+				if err := s.publish(ctx, wait); err != nil {
+					return err
+				}
+			}
+
+			s.closed = false
+
+			return conq.ErrClosed
+		}
+	}
+}
+
+func (s *Sink) publish(ctx context.Context, wait *backoff) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	msgs := make([]*pubsub.Message, len(s.pending))
+	for i, item := range s.pending {
+		msg, err := s.encode(item)
+		if err != nil {
+			s.pending = append(s.pending[:i:i], s.pending[i+1:]...)
+
+			return err
+		}
+
+		msgs[i] = msg
+	}
+
+	results := make([]*pubsub.PublishResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = s.Publisher.Publish(ctx, msg)
+	}
+
+	var failed []interface{}
+	for i, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			failed = append(failed, s.pending[i])
+		}
+	}
+
+	s.pending = failed
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if len(failed) > 0 {
+		return wait.sleep(ctx)
+	}
+
+	wait.reset()
+
+	return nil
+}
+
+func (s *Sink) encode(item interface{}) (*pubsub.Message, error) {
+	if s.Encode != nil {
+		return s.Encode(item)
+	}
+
+	switch item := item.(type) {
+	case []byte:
+		return &pubsub.Message{Data: item}, nil
+	case string:
+		return &pubsub.Message{Data: []byte(item)}, nil
+	default:
+		return nil, ErrUnencodable
+	}
+}
+
+func newBackoff(first time.Duration, max time.Duration) *backoff {
+	if first <= 0 {
+		first = time.Second
+	}
+
+	if max <= 0 {
+		max = time.Minute
+	}
+
+	return &backoff{first: first, max: max, next: first}
+}
+
+func (b *backoff) sleep(ctx context.Context) error {
+	timer := time.NewTimer(b.next)
+	defer timer.Stop()
+
+	if b.next *= 2; b.next > b.max {
+		b.next = b.max
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (b *backoff) reset() {
+	b.next = b.first
+}
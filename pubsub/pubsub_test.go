@@ -0,0 +1,313 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	"cloud.google.com/go/pubsub/v2"
+	"cloud.google.com/go/pubsub/v2/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/v2/pstest"
+	"context"
+	"errors"
+	"github.com/sebuckler/conq"
+	conqpubsub "github.com/sebuckler/conq/pubsub"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSubscriber struct {
+	failures   int
+	mut        sync.Mutex
+	subscriber *pubsub.Subscriber
+}
+
+func (s *fakeSubscriber) Receive(ctx context.Context, f func(context.Context, *pubsub.Message)) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.failures > 0 {
+		s.failures -= 1
+
+		return errors.New("pubsub unavailable")
+	}
+
+	return s.subscriber.Receive(ctx, f)
+}
+
+type fakePublisher struct {
+	failing   *pubsub.Publisher
+	failures  int
+	mut       sync.Mutex
+	publisher *pubsub.Publisher
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, msg *pubsub.Message) *pubsub.PublishResult {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	if p.failures > 0 {
+		p.failures -= 1
+
+		return p.failing.Publish(ctx, msg)
+	}
+
+	return p.publisher.Publish(ctx, msg)
+}
+
+func TestSource_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should enqueue and acknowledge messages": shouldEnqueueAckMessages,
+		"should receive again after error":        shouldReceiveAgain,
+		"should back off before rejecting":        shouldBackOffBeforeNack,
+		"should return when queue closed":         shouldReturnSourceClosed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestSink_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should publish items":              shouldPublishItems,
+		"should publish failed items again": shouldPublishFailedAgain,
+		"should drop unencodable item":      shouldDropUnencodable,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func newClient(t *testing.T) (*pstest.Server, *pubsub.Client, func()) {
+	ctx := context.Background()
+	srv := pstest.NewServer()
+
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := pubsub.NewClient(ctx, "project", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := &pubsubpb.Topic{Name: "projects/project/topics/jobs"}
+	if _, err := client.TopicAdminClient.CreateTopic(ctx, topic); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := &pubsubpb.Subscription{Name: "projects/project/subscriptions/jobs", Topic: topic.Name}
+	if _, err := client.SubscriptionAdminClient.CreateSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, client, func() {
+		client.Close()
+		conn.Close()
+		srv.Close()
+	}
+}
+
+func waitLen(queue *conq.Queue, n int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queue.WaitLen(ctx, n)
+}
+
+func sortedStrings(items []interface{}) []string {
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = string(item.([]byte))
+	}
+
+	sort.Strings(s)
+
+	return s
+}
+
+func shouldEnqueueAckMessages(t *testing.T, name string) {
+	srv, client, done := newClient(t)
+	defer done()
+
+	for _, data := range []string{"a", "b", "c"} {
+		srv.Publish("projects/project/topics/jobs", []byte(data), nil)
+	}
+
+	source := &conqpubsub.Source{Subscriber: client.Subscriber("jobs")}
+	queue := &conq.Queue{}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+
+	go func() {
+		result <- source.Run(ctx, queue)
+	}()
+
+	waitLen(queue, 3)
+	cancel()
+
+	if err := <-result; err != context.Canceled || !reflect.DeepEqual(sortedStrings(queue.Snapshot()), []string{"a", "b", "c"}) {
+		t.Fail()
+		t.Logf("%s: did not enqueue messages %v: %v", name, queue.Snapshot(), err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		acks := 0
+		for _, msg := range srv.Messages() {
+			acks += msg.Acks
+		}
+
+		if acks == 3 {
+			return
+		}
+	}
+
+	t.Fail()
+	t.Logf("%s: did not acknowledge messages", name)
+}
+
+func shouldReceiveAgain(t *testing.T, name string) {
+	srv, client, done := newClient(t)
+	defer done()
+
+	srv.Publish("projects/project/topics/jobs", []byte("a"), nil)
+
+	subscriber := &fakeSubscriber{failures: 2, subscriber: client.Subscriber("jobs")}
+	source := &conqpubsub.Source{Backoff: time.Millisecond, Subscriber: subscriber}
+	queue := &conq.Queue{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go source.Run(ctx, queue)
+	waitLen(queue, 1)
+
+	if !reflect.DeepEqual(queue.Snapshot(), []interface{}{[]byte("a")}) {
+		t.Fail()
+		t.Logf("%s: did not receive again after error %v", name, queue.Snapshot())
+	}
+}
+
+func shouldBackOffBeforeNack(t *testing.T, name string) {
+	srv, client, done := newClient(t)
+	defer done()
+
+	id := srv.Publish("projects/project/topics/jobs", []byte("a"), nil)
+
+	source := &conqpubsub.Source{Backoff: time.Minute, Subscriber: client.Subscriber("jobs")}
+	queue := &conq.Queue{Admission: &conq.ConcurrencyLimit{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	source.Run(ctx, queue)
+
+	if msg := srv.Message(id); msg == nil || msg.Deliveries != 1 || msg.Acks != 0 {
+		t.Fail()
+		t.Logf("%s: did not back off before rejecting %+v", name, msg)
+	}
+}
+
+func shouldReturnSourceClosed(t *testing.T, name string) {
+	srv, client, done := newClient(t)
+	defer done()
+
+	srv.Publish("projects/project/topics/jobs", []byte("a"), nil)
+
+	source := &conqpubsub.Source{Subscriber: client.Subscriber("jobs")}
+	queue := &conq.Queue{}
+	queue.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := source.Run(ctx, queue); err != conq.ErrClosed {
+		t.Fail()
+		t.Logf("%s: did not return when queue closed: %v", name, err)
+	}
+}
+
+func shouldPublishItems(t *testing.T, name string) {
+	srv, client, done := newClient(t)
+	defer done()
+
+	publisher := client.Publisher("jobs")
+	defer publisher.Stop()
+
+	sink := &conqpubsub.Sink{BatchSize: 2, Publisher: publisher}
+	queue := &conq.Queue{}
+
+	for _, item := range []string{"a", "b", "c"} {
+		queue.Enqueue(item)
+	}
+
+	queue.EnqueuePoison(1)
+
+	if err := sink.Run(context.Background(), queue); err != conq.ErrClosed || !reflect.DeepEqual(published(srv), []string{"a", "b", "c"}) {
+		t.Fail()
+		t.Logf("%s: did not publish items %v: %v", name, published(srv), err)
+	}
+}
+
+func shouldPublishFailedAgain(t *testing.T, name string) {
+	srv, client, done := newClient(t)
+	defer done()
+
+	publisher := &fakePublisher{failing: client.Publisher("missing"), failures: 1, publisher: client.Publisher("jobs")}
+	defer publisher.failing.Stop()
+	defer publisher.publisher.Stop()
+
+	sink := &conqpubsub.Sink{Backoff: time.Millisecond, Publisher: publisher}
+	queue := &conq.Queue{}
+
+	queue.Enqueue("a")
+	queue.Enqueue([]byte("b"))
+	queue.EnqueuePoison(1)
+
+	if err := sink.Run(context.Background(), queue); err != conq.ErrClosed || !reflect.DeepEqual(published(srv), []string{"a", "b"}) {
+		t.Fail()
+		t.Logf("%s: did not publish failed item again %v: %v", name, published(srv), err)
+	}
+}
+
+func shouldDropUnencodable(t *testing.T, name string) {
+	srv, client, done := newClient(t)
+	defer done()
+
+	publisher := client.Publisher("jobs")
+	defer publisher.Stop()
+
+	sink := &conqpubsub.Sink{Publisher: publisher}
+	queue := &conq.Queue{}
+
+	queue.Enqueue(1)
+	queue.Enqueue("a")
+	queue.EnqueuePoison(1)
+
+	err := sink.Run(context.Background(), queue)
+	if err == conqpubsub.ErrUnencodable {
+		err = sink.Run(context.Background(), queue)
+	}
+
+	if err != conq.ErrClosed || !reflect.DeepEqual(published(srv), []string{"a"}) {
+		t.Fail()
+		t.Logf("%s: did not drop unencodable item %v: %v", name, published(srv), err)
+	}
+}
+
+func published(srv *pstest.Server) []string {
+	var data []string
+	for _, msg := range srv.Messages() {
+		data = append(data, string(msg.Data))
+	}
+
+	sort.Strings(data)
+
+	return data
+}
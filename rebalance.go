@@ -0,0 +1,111 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+/*
+Partition returns the partition of an item when the dispatcher routes items by
+Key, or -1 when it does not. Consumers can use it to tell which of their
+partitions an item belongs to.
+*/
+func (d *Dispatcher) Partition(item interface{}) int {
+	if d.Key == nil {
+		return -1
+	}
+
+	return d.partition(item)
+}
+
+func (d *Dispatcher) partition(item interface{}) int {
+	return int(hashString(d.Key(item)) % uint32(d.partitions()))
+}
+
+func (d *Dispatcher) partitions() int {
+	if d.Partitions <= 0 {
+		return 256
+	}
+
+	return d.Partitions
+}
+
+func (d *Dispatcher) assignment() []*consumer {
+	if d.Key == nil {
+		return nil
+	}
+
+	owners := make([]*consumer, d.partitions())
+	for p := range owners {
+		owners[p] = d.owner(p)
+	}
+
+	return owners
+}
+
+func (d *Dispatcher) rebalance(before []*consumer, after []*consumer) {
+	if d.Key == nil {
+		d.mut.Lock()
+		d.notify()
+		d.mut.Unlock()
+
+		return
+	}
+
+	revoked, revokedOrder := movedPartitions(before, after)
+	assigned, assignedOrder := movedPartitions(after, before)
+
+	if d.OnRevoke != nil {
+		for _, c := range revokedOrder {
+			d.OnRevoke(c.name, revoked[c])
+		}
+	}
+
+	d.mut.Lock()
+
+	for busy := true; busy; {
+		busy = false
+
+		for _, c := range revokedOrder {
+			busy = busy || c.busy
+		}
+
+		if busy {
+			changed := d.wait()
+			d.mut.Unlock()
+			<-changed
+			d.mut.Lock()
+		}
+	}
+
+	d.mut.Unlock()
+
+	if d.OnAssign != nil {
+		for _, c := range assignedOrder {
+			d.OnAssign(c.name, assigned[c])
+		}
+	}
+
+	d.mut.Lock()
+	d.rebalancing = false
+	d.notify()
+	d.mut.Unlock()
+}
+
+func movedPartitions(from []*consumer, to []*consumer) (map[*consumer][]int, []*consumer) {
+	moved := make(map[*consumer][]int)
+	var order []*consumer
+
+	for p, c := range from {
+		if c == nil || to != nil && to[p] == c {
+			continue
+		}
+
+		if _, ok := moved[c]; !ok {
+			order = append(order, c)
+		}
+
+		moved[c] = append(moved[c], p)
+	}
+
+	return moved, order
+}
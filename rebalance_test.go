@@ -0,0 +1,194 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcher_Rebalance(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should assign partitions to first consumer":  shouldAssignFirstConsumer,
+		"should move partitions to added consumer":    shouldMovePartitionsAdded,
+		"should move partitions of removed consumer":  shouldMovePartitionsRemoved,
+		"should wait for busy consumer before assign": shouldWaitBusyBeforeAssign,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestDispatcher_Partition(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have partition of key":      shouldHavePartitionOfKey,
+		"should not have partition of item": shouldNotHavePartitionNoKey,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+type rebalanceLog struct {
+	mut      sync.Mutex
+	assigned map[string][]int
+	revoked  map[string][]int
+}
+
+func newRebalanceDispatcher(partitions int) (*conq.Dispatcher, *rebalanceLog) {
+	log := &rebalanceLog{assigned: map[string][]int{}, revoked: map[string][]int{}}
+	dispatcher := &conq.Dispatcher{
+		Key:        keyOf,
+		Partitions: partitions,
+		Source:     &conq.Queue{},
+		OnAssign: func(consumer string, partitions []int) {
+			log.mut.Lock()
+			log.assigned[consumer] = append(log.assigned[consumer], partitions...)
+			log.mut.Unlock()
+		},
+		OnRevoke: func(consumer string, partitions []int) {
+			log.mut.Lock()
+			log.revoked[consumer] = append(log.revoked[consumer], partitions...)
+			log.mut.Unlock()
+		},
+	}
+
+	return dispatcher, log
+}
+
+func (l *rebalanceLog) reset() {
+	l.mut.Lock()
+	l.assigned = map[string][]int{}
+	l.revoked = map[string][]int{}
+	l.mut.Unlock()
+}
+
+func shouldAssignFirstConsumer(t *testing.T, name string) {
+	dispatcher, log := newRebalanceDispatcher(8)
+	dispatcher.AddQueue("a", &conq.Queue{})
+
+	if !reflect.DeepEqual(log.assigned, map[string][]int{"a": {0, 1, 2, 3, 4, 5, 6, 7}}) || len(log.revoked) != 0 {
+		t.Fail()
+		t.Logf("%s: did not assign partitions %v %v", name, log.assigned, log.revoked)
+	}
+}
+
+func shouldMovePartitionsAdded(t *testing.T, name string) {
+	dispatcher, log := newRebalanceDispatcher(64)
+	dispatcher.AddQueue("a", &conq.Queue{})
+	log.reset()
+	dispatcher.AddQueue("b", &conq.Queue{})
+
+	if len(log.assigned["b"]) == 0 || !reflect.DeepEqual(log.assigned["b"], log.revoked["a"]) || len(log.assigned) != 1 || len(log.revoked) != 1 {
+		t.Fail()
+		t.Logf("%s: did not move partitions %v %v", name, log.assigned, log.revoked)
+	}
+}
+
+func shouldMovePartitionsRemoved(t *testing.T, name string) {
+	dispatcher, log := newRebalanceDispatcher(64)
+	owners := map[int]string{}
+	dispatcher.OnAssign = func(consumer string, partitions []int) {
+		for _, p := range partitions {
+			owners[p] = consumer
+		}
+	}
+
+	for _, consumer := range []string{"a", "b", "c"} {
+		dispatcher.AddQueue(consumer, &conq.Queue{})
+	}
+
+	var removed []int
+	for p := 0; p < 64; p++ {
+		if owners[p] == "a" {
+			removed = append(removed, p)
+		}
+	}
+
+	var moved []int
+	dispatcher.OnAssign = func(consumer string, partitions []int) {
+		moved = append(moved, partitions...)
+	}
+
+	log.reset()
+	dispatcher.Remove("a")
+	sort.Ints(moved)
+
+	if len(removed) == 0 || !reflect.DeepEqual(moved, removed) || !reflect.DeepEqual(log.revoked, map[string][]int{"a": removed}) {
+		t.Fail()
+		t.Logf("%s: did not move partitions %v of removed consumer: %v", name, removed, moved)
+	}
+}
+
+func shouldWaitBusyBeforeAssign(t *testing.T, name string) {
+	dispatcher, log := newRebalanceDispatcher(64)
+	started, release := make(chan struct{}), make(chan struct{})
+	dispatcher.AddFunc("a", func(item interface{}) {
+		close(started)
+		<-release
+	})
+	log.reset()
+
+	dispatcher.Source.Enqueue(1)
+	dispatcher.Source.EnqueuePoison(1)
+	done := make(chan struct{})
+
+	go func() {
+		dispatcher.Run(context.Background())
+		close(done)
+	}()
+
+	<-started
+
+	added := make(chan struct{})
+	go func() {
+		dispatcher.AddQueue("b", &conq.Queue{})
+		close(added)
+	}()
+
+	select {
+	case <-added:
+		t.Fail()
+		t.Logf("%s: assigned partitions before busy consumer returned", name)
+	case <-time.After(10 * time.Millisecond):
+		break
+	}
+
+	close(release)
+	<-added
+	<-done
+
+	if log.mut.Lock(); len(log.assigned["b"]) == 0 {
+		t.Fail()
+		t.Logf("%s: did not assign partitions", name)
+	}
+
+	log.mut.Unlock()
+}
+
+func shouldHavePartitionOfKey(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{Key: keyOf, Partitions: 4}
+
+	if p := dispatcher.Partition(3); p < 0 || p >= 4 || p != dispatcher.Partition(13) {
+		t.Fail()
+		t.Logf("%s: did not have partition %d", name, p)
+	}
+}
+
+func shouldNotHavePartitionNoKey(t *testing.T, name string) {
+	dispatcher := &conq.Dispatcher{}
+
+	if p := dispatcher.Partition(3); p != -1 {
+		t.Fail()
+		t.Logf("%s: had partition %d", name, p)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Replay enqueues items into a queue at most rate items per second, for
+reinjecting a backlog that was drained or recovered, like the items of a
+Snapshot, without overwhelming the consumers. The items are enqueued in order
+and evenly spaced, and ErrClosed items are enqueued as poison items. A rate of 0
+or less enqueues the items without waiting. The rate is measured by the Clock of
+the queue. Replay returns how many items were enqueued, and the error of the
+context or the first error of TryEnqueue, in which case the remaining items are
+not enqueued.
+*/
+func Replay(ctx context.Context, items []interface{}, into *Queue, rate float64) (int, error) {
+	clock := clockOr(into.Clock)
+	start := clock.Now()
+
+	for n, item := range items {
+		if rate > 0 {
+			due := start.Add(time.Duration(float64(n) / rate * float64(time.Second)))
+
+			if wait := due.Sub(clock.Now()); wait > 0 {
+				pauseAny(clock, wait, []<-chan struct{}{ctx.Done()})
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		if item == ErrClosed {
+			into.EnqueuePoison(1)
+		} else if err := into.TryEnqueue(item); err != nil {
+			return n, err
+		}
+	}
+
+	return len(items), nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should enqueue items in order": shouldReplayInOrder,
+		"should limit rate":             shouldReplayAtRate,
+		"should enqueue poison items":   shouldReplayPoison,
+		"should stop when context done": shouldStopReplayOnDone,
+		"should stop at enqueue error":  shouldStopReplayOnError,
+		"should wait on queue clock":    shouldReplayOnClock,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldReplayInOrder(t *testing.T, name string) {
+	queue := &conq.Queue{}
+
+	n, err := conq.Replay(context.Background(), []interface{}{1, 2, 3}, queue, 0)
+
+	if n != 3 || err != nil || !reflect.DeepEqual(queue.Snapshot(), []interface{}{1, 2, 3}) {
+		t.Fail()
+		t.Logf("%s: replayed %d items %v with error %v", name, n, queue.Snapshot(), err)
+	}
+}
+
+func shouldReplayAtRate(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	start := time.Now()
+
+	n, err := conq.Replay(context.Background(), []interface{}{1, 2, 3, 4, 5}, queue, 100)
+	elapsed := time.Since(start)
+
+	if n != 5 || err != nil || elapsed < 40*time.Millisecond {
+		t.Fail()
+		t.Logf("%s: replayed %d items in %v with error %v", name, n, elapsed, err)
+	}
+}
+
+func shouldReplayPoison(t *testing.T, name string) {
+	queue := &conq.Queue{}
+
+	conq.Replay(context.Background(), []interface{}{1, conq.ErrClosed}, queue, 0)
+
+	if queue.Dequeue() != 1 || queue.Dequeue() != conq.ErrClosed {
+		t.Fail()
+		t.Logf("%s: did not replay poison item", name)
+	}
+}
+
+func shouldStopReplayOnDone(t *testing.T, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	queue := &conq.Queue{}
+
+	n, err := conq.Replay(ctx, []interface{}{1, 2, 3}, queue, 1)
+
+	if n != 1 || err != context.DeadlineExceeded || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: replayed %d items with error %v", name, n, err)
+	}
+}
+
+func shouldStopReplayOnError(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Close()
+
+	n, err := conq.Replay(context.Background(), []interface{}{1, 2}, queue, 0)
+
+	if n != 0 || err != conq.ErrClosed {
+		t.Fail()
+		t.Logf("%s: replayed %d items with error %v", name, n, err)
+	}
+}
+
+func shouldReplayOnClock(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	start := sim.Now()
+
+	var n, halfway int
+	var err error
+	sim.Go(func() { n, err = conq.Replay(context.Background(), []interface{}{1, 2, 3}, queue, 1) })
+	sim.Go(func() {
+		sim.Sleep(1500 * time.Millisecond)
+		halfway = queue.Len()
+	})
+
+	if simErr := sim.Run(); simErr != nil || n != 3 || err != nil || halfway != 2 || sim.Now().Sub(start) != 2*time.Second {
+		t.Fail()
+		t.Logf("%s: replayed %d with %d halfway after %v: %v %v", name, n, halfway, sim.Now().Sub(start), err, simErr)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	respMaxArgs = 64 << 10
+	respMaxBulk = 1 << 20
+)
+
+/*
+RESPServer is a minimal server for the Redis protocol, so Redis queue clients
+and redis-cli can use queues of an embedded conq instance, like in tests. Each
+Redis list key is a queue in Queues, and a queue is created for a key that is
+not in Queues yet.
+
+LPUSH enqueues values, and BRPOP and RPOP dequeue them, so the queues have the
+same FIFO order as a Redis list used as a queue. LLEN returns the Len of a
+queue, and PING is answered with PONG. Values are enqueued as strings, and
+items that are not strings or []byte are sent in the format of fmt.Sprint. A
+poison item is popped as an error reply, so clients can stop when the queue is
+closed.
+
+Commands are limited to 64K arguments of up to 1MB each, and a connection that
+sends a larger command is closed.
+*/
+type RESPServer struct {
+	Queues map[string]*Queue // queues by key, created when missing
+	mut    sync.Mutex
+}
+
+/*
+Serve accepts connections on the listener and answers their commands until the
+context is done, and it returns the error of the context. If the listener fails
+to accept a connection, Serve returns its error. Serve closes the listener and
+the connections before it returns.
+*/
+func (s *RESPServer) Serve(ctx context.Context, listener net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return err
+		}
+
+		conns.Add(1)
+
+		go func() {
+			defer conns.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *RESPServer) serveConn(ctx context.Context, conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+			conn.Close()
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		if len(args) > 0 {
+			s.command(ctx, w, args)
+		}
+
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (s *RESPServer) command(ctx context.Context, w *bufio.Writer, args []string) {
+	switch name := strings.ToUpper(args[0]); {
+	case name == "PING":
+		w.WriteString("+PONG\r\n")
+	case name == "LPUSH" && len(args) >= 3:
+		q := s.queue(args[1])
+
+		for _, val := range args[2:] {
+			if err := q.TryEnqueue(val); err != nil {
+				writeError(w, err.Error())
+
+				return
+			}
+		}
+
+		writeInt(w, q.Len())
+	case name == "LLEN" && len(args) == 2:
+		writeInt(w, s.queue(args[1]).Len())
+	case name == "RPOP" && len(args) == 2:
+		switch val := s.queue(args[1]).Dequeue(); val {
+		case nil:
+			w.WriteString("$-1\r\n")
+		case ErrClosed:
+			writeError(w, ErrClosed.Error())
+		default:
+			writeBulk(w, val)
+		}
+	case name == "BRPOP" && len(args) >= 3:
+		timeout, err := strconv.ParseFloat(args[len(args)-1], 64)
+		if err != nil || timeout < 0 {
+			writeError(w, "timeout is not a float or out of range")
+
+			return
+		}
+
+		key, val, ok := s.brpop(ctx, args[1:len(args)-1], time.Duration(timeout*float64(time.Second)))
+		if !ok {
+			w.WriteString("*-1\r\n")
+
+			return
+		}
+
+		if val == ErrClosed {
+			writeError(w, ErrClosed.Error())
+
+			return
+		}
+
+		w.WriteString("*2\r\n")
+		writeBulk(w, key)
+		writeBulk(w, val)
+	case name == "LPUSH" || name == "LLEN" || name == "RPOP" || name == "BRPOP":
+		writeError(w, fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(name)))
+	default:
+		writeError(w, fmt.Sprintf("unknown command '%s'", args[0]))
+	}
+}
+
+func (s *RESPServer) queue(key string) *Queue {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.Queues == nil {
+		s.Queues = make(map[string]*Queue)
+	}
+
+	q, ok := s.Queues[key]
+	if !ok {
+		q = &Queue{}
+		s.Queues[key] = q
+	}
+
+	return q
+}
+
+func (s *RESPServer) brpop(ctx context.Context, keys []string, timeout time.Duration) (string, interface{}, bool) {
+	queues := make([]*Queue, len(keys))
+	for i, key := range keys {
+		queues[i] = s.queue(key)
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cases := make([]reflect.SelectCase, len(queues)+1)
+	cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+
+	for {
+		for i, q := range queues {
+			q.lock()
+
+			if val, ok := q.next(); ok {
+				q.notify()
+				q.unlock()
+
+				return keys[i], val, true
+			}
+
+			cases[i+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(q.wait())}
+			q.unlock()
+		}
+
+		if chosen, _, _ := reflect.Select(cases); chosen == 0 {
+			return "", nil, false
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > respMaxArgs {
+		return nil, errors.New("conq: invalid RESP array")
+	}
+
+	var args []string
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if !strings.HasPrefix(line, "$") || err != nil || size < 0 || size > respMaxBulk {
+			return nil, errors.New("conq: invalid RESP bulk string")
+		}
+
+		var arg strings.Builder
+		if _, err := io.CopyN(&arg, r, int64(size)); err != nil {
+			return nil, err
+		}
+
+		if _, err := r.Discard(2); err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	w.WriteString("-ERR " + msg + "\r\n")
+}
+
+func writeInt(w *bufio.Writer, n int) {
+	w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func writeBulk(w *bufio.Writer, val interface{}) {
+	var s string
+
+	switch val := val.(type) {
+	case string:
+		s = val
+	case []byte:
+		s = string(val)
+	default:
+		s = fmt.Sprint(val)
+	}
+
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
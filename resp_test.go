@@ -0,0 +1,197 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"bufio"
+	"context"
+	"github.com/sebuckler/conq"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRESPServer_Serve(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should push and pop in order":     shouldPushPopRESP,
+		"should block until pushed":        shouldBlockRESP,
+		"should time out blocking pop":     shouldTimeOutRESP,
+		"should pop from queues of server": shouldPopQueueRESP,
+		"should answer inline commands":    shouldAnswerInlineRESP,
+		"should fail unknown commands":     shouldFailUnknownRESP,
+		"should fail pops of closed queue": shouldFailClosedRESP,
+		"should close on large commands":   shouldCloseLargeRESP,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startRESP(t *testing.T, server *conq.RESPServer) (*respClient, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		server.Serve(ctx, listener)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}, func() {
+		cancel()
+		<-done
+	}
+}
+
+func (c *respClient) send(args ...string) {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+
+	c.conn.Write([]byte(b.String()))
+}
+
+func (c *respClient) read(lines int) string {
+	var b strings.Builder
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	for i := 0; i < lines; i++ {
+		line, _ := c.r.ReadString('\n')
+		b.WriteString(line)
+	}
+
+	return b.String()
+}
+
+func shouldPushPopRESP(t *testing.T, name string) {
+	client, stop := startRESP(t, &conq.RESPServer{})
+	defer stop()
+
+	client.send("LPUSH", "jobs", "a", "b")
+	client.send("LLEN", "jobs")
+	client.send("BRPOP", "jobs", "0")
+	client.send("RPOP", "jobs")
+	client.send("RPOP", "jobs")
+
+	if reply := client.read(10); reply != ":2\r\n:2\r\n*2\r\n$4\r\njobs\r\n$1\r\na\r\n$1\r\nb\r\n$-1\r\n" {
+		t.Fail()
+		t.Logf("%s: did not push and pop in order %q", name, reply)
+	}
+}
+
+func shouldBlockRESP(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	client, stop := startRESP(t, &conq.RESPServer{Queues: map[string]*conq.Queue{"b": queue}})
+	defer stop()
+
+	client.send("BRPOP", "a", "b", "1")
+	time.Sleep(10 * time.Millisecond)
+	queue.Enqueue("c")
+
+	if reply := client.read(5); reply != "*2\r\n$1\r\nb\r\n$1\r\nc\r\n" {
+		t.Fail()
+		t.Logf("%s: did not block until pushed %q", name, reply)
+	}
+}
+
+func shouldTimeOutRESP(t *testing.T, name string) {
+	client, stop := startRESP(t, &conq.RESPServer{})
+	defer stop()
+
+	client.send("BRPOP", "jobs", "0.01")
+
+	if reply := client.read(1); reply != "*-1\r\n" {
+		t.Fail()
+		t.Logf("%s: did not time out %q", name, reply)
+	}
+}
+
+func shouldPopQueueRESP(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	client, stop := startRESP(t, &conq.RESPServer{Queues: map[string]*conq.Queue{"jobs": queue}})
+	defer stop()
+
+	queue.Enqueue(42)
+	client.send("RPOP", "jobs")
+
+	if reply := client.read(2); reply != "$2\r\n42\r\n" {
+		t.Fail()
+		t.Logf("%s: did not pop from queue %q", name, reply)
+	}
+}
+
+func shouldAnswerInlineRESP(t *testing.T, name string) {
+	client, stop := startRESP(t, &conq.RESPServer{})
+	defer stop()
+
+	client.conn.Write([]byte("PING\r\nLLEN jobs\r\n"))
+
+	if reply := client.read(2); reply != "+PONG\r\n:0\r\n" {
+		t.Fail()
+		t.Logf("%s: did not answer inline commands %q", name, reply)
+	}
+}
+
+func shouldFailUnknownRESP(t *testing.T, name string) {
+	client, stop := startRESP(t, &conq.RESPServer{})
+	defer stop()
+
+	client.send("SET", "a", "b")
+	client.send("LLEN")
+
+	if reply := client.read(2); reply != "-ERR unknown command 'SET'\r\n-ERR wrong number of arguments for 'llen' command\r\n" {
+		t.Fail()
+		t.Logf("%s: did not fail unknown commands %q", name, reply)
+	}
+}
+
+func shouldFailClosedRESP(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	client, stop := startRESP(t, &conq.RESPServer{Queues: map[string]*conq.Queue{"jobs": queue}})
+	defer stop()
+
+	queue.EnqueuePoison(2)
+	client.send("RPOP", "jobs")
+	client.send("BRPOP", "jobs", "0")
+
+	if reply := client.read(2); reply != "-ERR conq: queue closed\r\n-ERR conq: queue closed\r\n" {
+		t.Fail()
+		t.Logf("%s: did not fail pops of closed queue %q", name, reply)
+	}
+}
+
+func shouldCloseLargeRESP(t *testing.T, name string) {
+	client, stop := startRESP(t, &conq.RESPServer{})
+	defer stop()
+
+	client.conn.Write([]byte("*1\r\n$1048577\r\n"))
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	if _, err := client.r.ReadString('\n'); err != io.EOF {
+		t.Fail()
+		t.Logf("%s: did not close on large command: %v", name, err)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+ItemTrace is the trace of one sampled item. Outcome is "queued" while the item
+is in the queue, "dequeued" or "removed" once it leaves the queue, and it is
+then "handled", "duplicate", or "dispatched" if a Processor or Dispatcher of
+the queue handled it, in which case Handled is how long the handler took.
+*/
+type ItemTrace struct {
+	Dequeued time.Time     // when the item was dequeued or removed, zero while it is queued
+	Enqueued time.Time     // when the item was enqueued
+	Handled  time.Duration // how long the handler took, 0 when no handler was timed
+	Item     interface{}   // sampled item
+	Outcome  string        // what happened to the item last
+}
+
+/*
+Sampler records an ItemTrace of every Nth item enqueued into a queue, for
+investigating latency without the cost of tracing every item. It keeps the
+traces of the Size items sampled last in memory, and older traces are
+forgotten. A Sampler can be shared by several queues.
+*/
+type Sampler struct {
+	Every  int // enqueued items per sampled item, defaults to 100
+	Size   int // most traces kept, defaults to 1000
+	count  uint64
+	mut    sync.Mutex
+	next   int
+	traces []*sample
+}
+
+type sample struct {
+	sampler *Sampler
+	trace   ItemTrace
+}
+
+/*
+Traces returns a copy of the kept traces, from the oldest sampled item to the
+newest.
+*/
+func (s *Sampler) Traces() []ItemTrace {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	traces := make([]ItemTrace, 0, len(s.traces))
+	for i := range s.traces {
+		traces = append(traces, s.traces[(s.next+i)%len(s.traces)].trace)
+	}
+
+	return traces
+}
+
+func (s *Sampler) start(item interface{}, at time.Time) *sample {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	every := s.Every
+	if every <= 0 {
+		every = 100
+	}
+
+	s.count += 1
+	if s.count%uint64(every) != 0 {
+		return nil
+	}
+
+	size := s.Size
+	if size <= 0 {
+		size = 1000
+	}
+
+	smp := &sample{sampler: s, trace: ItemTrace{Enqueued: at, Item: item, Outcome: "queued"}}
+
+	if len(s.traces) < size {
+		s.traces = append(s.traces, smp)
+	} else {
+		s.traces[s.next] = smp
+		s.next = (s.next + 1) % len(s.traces)
+	}
+
+	return smp
+}
+
+func (smp *sample) left(at time.Time, outcome string) {
+	if smp == nil {
+		return
+	}
+
+	smp.sampler.mut.Lock()
+	smp.trace.Dequeued = at
+	smp.trace.Outcome = outcome
+	smp.sampler.mut.Unlock()
+}
+
+func (smp *sample) handled(d time.Duration, outcome string) {
+	if smp == nil {
+		return
+	}
+
+	smp.sampler.mut.Lock()
+	smp.trace.Handled = d
+	smp.trace.Outcome = outcome
+	smp.sampler.mut.Unlock()
+}
+
+func withSample(item interface{}, smp *sample) interface{} {
+	if e, ok := item.(*envelope); ok {
+		e.sample = smp
+
+		return e
+	}
+
+	return &envelope{item: item, sample: smp}
+}
+
+func sampleOf(val interface{}) *sample {
+	if e, ok := val.(*envelope); ok {
+		return e.sample
+	}
+
+	return nil
+}
+
+func (q *Queue) dequeueSample(ctx context.Context) (interface{}, *sample, error) {
+	return q.dequeueBy(ctx, time.Time{})
+}
+
+func (q *Queue) dequeueBy(ctx context.Context, deadline time.Time) (interface{}, *sample, error) {
+	clock := clockOr(q.Clock)
+	q.lock()
+	defer q.unlock()
+
+	var taker chan interface{}
+	var interrupted <-chan struct{}
+
+	for q.pending() == 0 {
+		if taker == nil {
+			taker = make(chan interface{}, 1)
+			interrupted = q.interruption()
+		}
+
+		var wait time.Duration
+		if !deadline.IsZero() {
+			if wait = deadline.Sub(clock.Now()); wait <= 0 {
+				return nil, nil, context.DeadlineExceeded
+			}
+		}
+
+		changed := q.wait()
+		q.take(taker)
+		q.unlock()
+		pauseAny(clock, wait, []<-chan struct{}{ctx.Done(), changed, interrupted})
+		q.lock()
+
+		if val, ok := q.untake(taker); ok {
+			return val, nil, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		if q.pending() == 0 && isInterrupted(interrupted) {
+			return nil, nil, ErrInterrupted
+		}
+	}
+
+	var smp *sample
+	if len(q.control) == 0 {
+		smp = sampleOf(q.head.items[q.rx])
+	}
+
+	val, _ := q.next()
+	q.notify()
+
+	return val, smp, nil
+}
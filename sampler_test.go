@@ -0,0 +1,128 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+func TestSampler_Traces(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should sample every nth item":      shouldSampleEveryNth,
+		"should keep newest traces":         shouldKeepNewestTraces,
+		"should record dequeue time":        shouldRecordDequeueTime,
+		"should record removed items":       shouldRecordRemovedSample,
+		"should record processor handling":  shouldRecordProcessorHandling,
+		"should record dispatcher handling": shouldRecordDispatcherHandling,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldSampleEveryNth(t *testing.T, name string) {
+	sampler := &conq.Sampler{Every: 3}
+	queue := &conq.Queue{Sampler: sampler}
+
+	for i := 1; i <= 7; i++ {
+		queue.Enqueue(i)
+	}
+
+	traces := sampler.Traces()
+
+	if len(traces) != 2 || traces[0].Item != 3 || traces[1].Item != 6 || traces[0].Outcome != "queued" || queue.Dequeue() != 1 {
+		t.Fail()
+		t.Logf("%s: recorded traces %+v", name, traces)
+	}
+}
+
+func shouldKeepNewestTraces(t *testing.T, name string) {
+	sampler := &conq.Sampler{Every: 1, Size: 2}
+	queue := &conq.Queue{Sampler: sampler}
+
+	for i := 1; i <= 5; i++ {
+		queue.Enqueue(i)
+	}
+
+	traces := sampler.Traces()
+
+	if len(traces) != 2 || traces[0].Item != 4 || traces[1].Item != 5 {
+		t.Fail()
+		t.Logf("%s: kept traces %+v", name, traces)
+	}
+}
+
+func shouldRecordDequeueTime(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	sampler := &conq.Sampler{Every: 1}
+	queue := &conq.Queue{Clock: sim, Sampler: sampler}
+
+	queue.Enqueue(1)
+	sim.Sleep(time.Second)
+	queue.Dequeue()
+
+	trace := sampler.Traces()[0]
+
+	if trace.Outcome != "dequeued" || trace.Dequeued.Sub(trace.Enqueued) != time.Second {
+		t.Fail()
+		t.Logf("%s: recorded trace %+v", name, trace)
+	}
+}
+
+func shouldRecordRemovedSample(t *testing.T, name string) {
+	sampler := &conq.Sampler{Every: 1}
+	queue := &conq.Queue{Sampler: sampler}
+
+	queue.Enqueue(1)
+	queue.Remove(1)
+
+	if trace := sampler.Traces()[0]; trace.Outcome != "removed" || trace.Dequeued.IsZero() {
+		t.Fail()
+		t.Logf("%s: recorded trace %+v", name, trace)
+	}
+}
+
+func shouldRecordProcessorHandling(t *testing.T, name string) {
+	sampler := &conq.Sampler{Every: 1}
+	queue := &conq.Queue{Sampler: sampler}
+	processor := &conq.Processor{
+		Handle: func(interface{}) { time.Sleep(5 * time.Millisecond) },
+		Key:    func(item interface{}) string { return fmt.Sprint(item) },
+		Source: queue,
+	}
+
+	queue.Enqueue(1)
+	queue.Enqueue(1)
+	queue.EnqueuePoison(1)
+	processor.Run(context.Background())
+
+	traces := sampler.Traces()
+
+	if len(traces) != 2 || traces[0].Outcome != "handled" || traces[0].Handled < 5*time.Millisecond || traces[1].Outcome != "duplicate" {
+		t.Fail()
+		t.Logf("%s: recorded traces %+v", name, traces)
+	}
+}
+
+func shouldRecordDispatcherHandling(t *testing.T, name string) {
+	sampler := &conq.Sampler{Every: 1}
+	queue := &conq.Queue{Sampler: sampler}
+	dispatcher := &conq.Dispatcher{Source: queue}
+	dispatcher.AddFunc("worker", func(interface{}) { time.Sleep(5 * time.Millisecond) })
+
+	queue.Enqueue(1)
+	queue.EnqueuePoison(1)
+	dispatcher.Run(context.Background())
+
+	if trace := sampler.Traces()[0]; trace.Outcome != "handled" || trace.Handled < 5*time.Millisecond {
+		t.Fail()
+		t.Logf("%s: recorded trace %+v", name, trace)
+	}
+}
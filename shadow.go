@@ -0,0 +1,146 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+Shadow copies a Fraction of the items dequeued from a source queue into a
+shadow Queue, for canary workers that test new processing code against live
+traffic. The copies are spread evenly over the dequeued items, so a Fraction
+of 0.1 copies every tenth item. Poison and control items are not copied, and
+the copies are the same values as the dequeued items, so neither the workers
+nor the canaries may change them.
+
+Copies are recorded while the source is locked, and Run enqueues them into the
+shadow queue in the background, so the canaries never slow down the source.
+When MaxLag copies are waiting, or the shadow queue rejects a copy, the copy is
+dropped instead.
+*/
+type Shadow struct {
+	Fraction float64 // fraction of dequeued items that are copied, from 0 to 1
+	MaxLag   int     // most copies waiting to be enqueued, defaults to 1000
+	Queue    *Queue  // queue the copies are enqueued into
+	changed  chan struct{}
+	copied   uint64
+	dropped  uint64
+	mut      sync.Mutex
+	pending  []interface{}
+	seen     uint64
+	source   *Queue
+}
+
+/*
+Attach starts copying the items dequeued from the source queue. A queue can
+only have one shadow, and a shadow can only be attached to one queue.
+*/
+func (s *Shadow) Attach(source *Queue) {
+	source.lock()
+	defer source.unlock()
+
+	s.mut.Lock()
+	s.source = source
+	s.mut.Unlock()
+
+	source.shadow = s
+}
+
+/*
+Detach stops copying the items dequeued from the source queue. The copies that
+are already waiting are still enqueued by Run.
+*/
+func (s *Shadow) Detach() {
+	s.mut.Lock()
+	source := s.source
+	s.source = nil
+	s.mut.Unlock()
+
+	if source != nil {
+		source.lock()
+		source.shadow = nil
+		source.unlock()
+	}
+}
+
+/*
+Run enqueues the copies into the shadow queue until the context is done, and
+it returns the error of the context.
+*/
+func (s *Shadow) Run(ctx context.Context) error {
+	for {
+		s.mut.Lock()
+		changed := s.wait()
+		copies := s.pending
+		s.pending = nil
+		s.mut.Unlock()
+
+		for _, item := range copies {
+			if s.Queue.TryEnqueue(item) != nil {
+				s.mut.Lock()
+				s.dropped += 1
+				s.mut.Unlock()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+			break
+		}
+	}
+}
+
+/*
+Dropped returns how many copies were dropped because MaxLag copies were
+waiting or the shadow queue rejected them.
+*/
+func (s *Shadow) Dropped() uint64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.dropped
+}
+
+func (s *Shadow) record(item interface{}) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.seen += 1
+	if uint64(float64(s.seen)*s.Fraction) <= s.copied {
+		return
+	}
+
+	s.copied += 1
+
+	maxLag := s.MaxLag
+	if maxLag <= 0 {
+		maxLag = 1000
+	}
+
+	if len(s.pending) >= maxLag {
+		s.dropped += 1
+
+		return
+	}
+
+	s.pending = append(s.pending, item)
+
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+func (s *Shadow) wait() <-chan struct{} {
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+
+	return s.changed
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShadow_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should copy fraction of dequeued items": shouldCopyFractionToShadow,
+		"should not copy poison or control":      shouldNotShadowPoisonOrControl,
+		"should drop copies over max lag":        shouldDropShadowOverMaxLag,
+		"should stop copying when detached":      shouldStopShadowOnDetach,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func runShadow(s *conq.Shadow) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func shouldCopyFractionToShadow(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	canary := &conq.Queue{}
+	shadow := &conq.Shadow{Fraction: 0.25, Queue: canary}
+	shadow.Attach(queue)
+	stop := runShadow(shadow)
+
+	for i := 0; i < 8; i++ {
+		queue.Enqueue(i)
+	}
+
+	for queue.Dequeue() != nil {
+		continue
+	}
+
+	canary.WaitLen(context.Background(), 2)
+	stop()
+
+	if got := canary.Snapshot(); !reflect.DeepEqual(got, []interface{}{3, 7}) {
+		t.Fail()
+		t.Logf("%s: copied %v", name, got)
+	}
+}
+
+func shouldNotShadowPoisonOrControl(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	canary := &conq.Queue{}
+	shadow := &conq.Shadow{Fraction: 1, Queue: canary}
+	shadow.Attach(queue)
+	stop := runShadow(shadow)
+
+	queue.EnqueueControl("flush")
+	queue.Enqueue(1)
+	queue.EnqueuePoison(1)
+
+	for queue.Len() > 0 {
+		queue.Dequeue()
+	}
+
+	canary.WaitLen(context.Background(), 1)
+	stop()
+
+	if got := canary.Snapshot(); !reflect.DeepEqual(got, []interface{}{1}) {
+		t.Fail()
+		t.Logf("%s: copied %v", name, got)
+	}
+}
+
+func shouldDropShadowOverMaxLag(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	canary := &conq.Queue{}
+	shadow := &conq.Shadow{Fraction: 1, MaxLag: 2, Queue: canary}
+	shadow.Attach(queue)
+
+	for i := 0; i < 5; i++ {
+		queue.Enqueue(i)
+		queue.Dequeue()
+	}
+
+	stop := runShadow(shadow)
+	canary.WaitLen(context.Background(), 2)
+	stop()
+
+	if got := canary.Snapshot(); !reflect.DeepEqual(got, []interface{}{0, 1}) || shadow.Dropped() != 3 {
+		t.Fail()
+		t.Logf("%s: copied %v and dropped %d", name, got, shadow.Dropped())
+	}
+}
+
+func shouldStopShadowOnDetach(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	canary := &conq.Queue{}
+	shadow := &conq.Shadow{Fraction: 1, Queue: canary}
+	shadow.Attach(queue)
+	stop := runShadow(shadow)
+
+	queue.Enqueue(1)
+	queue.Dequeue()
+	shadow.Detach()
+	queue.Enqueue(2)
+	queue.Dequeue()
+
+	canary.WaitLen(context.Background(), 1)
+	time.Sleep(10 * time.Millisecond)
+	stop()
+
+	if got := canary.Snapshot(); !reflect.DeepEqual(got, []interface{}{1}) {
+		t.Fail()
+		t.Logf("%s: copied %v", name, got)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+/*
+Close closes the queue to enqueues, so Enqueue and EnqueueHandle return
+ErrClosed instead of enqueuing items. The items already in the queue can still
+be dequeued, and EnqueuePoison still adds poison items, so consumers can drain
+the queue and then be stopped. Close locks the queue, and closing a queue again
+has no effect.
+*/
+func (q *Queue) Close() {
+	q.lock()
+
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+
+	q.unlock()
+}
+
+/*
+Closed reports whether the queue was closed with Close. Closed locks the queue.
+*/
+func (q *Queue) Closed() bool {
+	q.rlock()
+	defer q.runlock()
+
+	return q.closed
+}
+
+/*
+NotifyShutdown is the shutdown sequence of a service that uses queues. It
+blocks until the process receives SIGINT or SIGTERM, closes the queues to
+enqueues, and waits for consumers to drain them. It returns nil once every
+queue is empty, or context.DeadlineExceeded if the queues are not drained
+within the timeout. A second signal stops the wait early, and NotifyShutdown
+then returns context.Canceled. If the context is done before a signal is
+received, NotifyShutdown returns its error without closing the queues. A
+timeout of 0 waits until the queues are drained.
+*/
+func NotifyShutdown(ctx context.Context, timeout time.Duration, queues ...*Queue) error {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-signals:
+		break
+	}
+
+	for _, q := range queues {
+		q.Close()
+	}
+
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	defer cancel()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			break
+		case <-signals:
+			cancel()
+		}
+	}()
+
+	for _, q := range queues {
+		if err := q.waitBelow(ctx, 1, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"os"
+	"os/signal"
+	"testing"
+	"time"
+)
+
+func TestQueue_Close(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should reject enqueues when closed":    shouldRejectWhenClosed,
+		"should dequeue and poison when closed": shouldDequeuePoisonWhenClosed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestNotifyShutdown(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should close and drain on signal":   shouldCloseDrainOnSignal,
+		"should stop draining after timeout": shouldStopDrainingAfterTimeout,
+		"should return when context is done": shouldReturnWhenContextDone,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldRejectWhenClosed(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Close()
+	handle, err := queue.EnqueueHandle(2)
+
+	if queue.TryEnqueue(1) != conq.ErrClosed || handle != nil || err != conq.ErrClosed || !queue.Closed() || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not reject enqueues", name)
+	}
+}
+
+func shouldDequeuePoisonWhenClosed(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	queue.Close()
+	queue.EnqueuePoison(1)
+
+	if queue.Dequeue() != 1 || queue.Dequeue() != conq.ErrClosed {
+		t.Fail()
+		t.Logf("%s: did not dequeue items of closed queue", name)
+	}
+}
+
+func signalUntilClosed(queue *conq.Queue) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	p, _ := os.FindProcess(os.Getpid())
+
+	for deadline := time.Now().Add(time.Second); !queue.Closed() && time.Now().Before(deadline); {
+		p.Signal(os.Interrupt)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func shouldCloseDrainOnSignal(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	result := make(chan error)
+
+	go func() {
+		result <- conq.NotifyShutdown(context.Background(), time.Second, queue)
+	}()
+
+	signalUntilClosed(queue)
+
+	go func() {
+		for queue.Dequeue() != nil {
+		}
+	}()
+
+	if err := <-result; err != nil || queue.TryEnqueue(3) != conq.ErrClosed {
+		t.Fail()
+		t.Logf("%s: did not close and drain queue: %v", name, err)
+	}
+}
+
+func shouldStopDrainingAfterTimeout(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue(1)
+	result := make(chan error)
+
+	go func() {
+		result <- conq.NotifyShutdown(context.Background(), 10*time.Millisecond, queue)
+	}()
+
+	signalUntilClosed(queue)
+
+	if err := <-result; err != context.DeadlineExceeded || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: did not stop draining: %v", name, err)
+	}
+}
+
+func shouldReturnWhenContextDone(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := conq.NotifyShutdown(ctx, 0, queue); err != context.Canceled || queue.Closed() {
+		t.Fail()
+		t.Logf("%s: did not return when context is done: %v", name, err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"container/heap"
+	"sort"
+)
+
+/*
+SizedItem is an item in a queue together with its bytes, as returned by Size.
+*/
+type SizedItem struct {
+	Bytes int         // bytes of the item
+	Item  interface{} // item in the queue
+}
+
+type sizedHeap []sizedEntry
+
+type sizedEntry struct {
+	index int
+	item  SizedItem
+}
+
+/*
+TopK returns the k largest items in the queue with their bytes, from the
+largest to the smallest, and items of the same size in the order they would be
+dequeued. It is for finding the few large payloads that use most of the memory
+of a queue. TopK returns nil if the queue has no Size. Like Range, TopK only
+locks the queue long enough to share the internal chunks of the queue, and it
+sizes the items after the queue is unlocked.
+*/
+func (q *Queue) TopK(k int) []SizedItem {
+	if k <= 0 || q.Size == nil {
+		return nil
+	}
+
+	h := make(sizedHeap, 0, k)
+	i := 0
+
+	q.Range(func(item interface{}) bool {
+		if item == ErrClosed {
+			return true
+		}
+
+		entry := sizedEntry{index: i, item: SizedItem{Bytes: q.Size(item), Item: item}}
+		i += 1
+
+		if len(h) < k {
+			heap.Push(&h, entry)
+		} else if h.less(h[0], entry) {
+			h[0] = entry
+			heap.Fix(&h, 0)
+		}
+
+		return true
+	})
+
+	sort.Slice(h, func(a, b int) bool { return h.less(h[b], h[a]) })
+
+	items := make([]SizedItem, len(h))
+	for j, entry := range h {
+		items[j] = entry.item
+	}
+
+	return items
+}
+
+func (h sizedHeap) Len() int {
+	return len(h)
+}
+
+func (h sizedHeap) Less(a, b int) bool {
+	return h.less(h[a], h[b])
+}
+
+func (h sizedHeap) Swap(a, b int) {
+	h[a], h[b] = h[b], h[a]
+}
+
+func (h *sizedHeap) Push(x interface{}) {
+	*h = append(*h, x.(sizedEntry))
+}
+
+func (h *sizedHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return entry
+}
+
+func (h sizedHeap) less(a, b sizedEntry) bool {
+	if a.item.Bytes != b.item.Bytes {
+		return a.item.Bytes < b.item.Bytes
+	}
+
+	return a.index > b.index
+}
+
+func (q *Queue) sizeOf(item interface{}) int {
+	if item == ErrClosed {
+		return 0
+	}
+
+	return q.Size(item)
+}
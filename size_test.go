@@ -0,0 +1,104 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+)
+
+func TestQueue_TopK(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should return largest items":       shouldReturnLargestItems,
+		"should keep queue order for ties":  shouldKeepQueueOrderForTies,
+		"should return nil without size":    shouldReturnNilTopKWithoutSize,
+		"should return all of short queues": shouldReturnAllOfShortQueue,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestQueue_Size(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should track bytes": shouldTrackBytes,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func lenOf(item interface{}) int {
+	return len(item.(string))
+}
+
+func shouldReturnLargestItems(t *testing.T, name string) {
+	queue := &conq.Queue{Size: lenOf}
+	for _, item := range []string{"aa", "bbbbb", "c", "dddd", "eee"} {
+		queue.Enqueue(item)
+	}
+
+	queue.EnqueuePoison(1)
+
+	want := []conq.SizedItem{{Bytes: 5, Item: "bbbbb"}, {Bytes: 4, Item: "dddd"}}
+	if got := queue.TopK(2); !reflect.DeepEqual(got, want) {
+		t.Fail()
+		t.Logf("%s: returned %v instead of %v", name, got, want)
+	}
+}
+
+func shouldKeepQueueOrderForTies(t *testing.T, name string) {
+	queue := &conq.Queue{Size: lenOf}
+	for _, item := range []string{"a", "b", "cc", "d"} {
+		queue.Enqueue(item)
+	}
+
+	want := []conq.SizedItem{{Bytes: 2, Item: "cc"}, {Bytes: 1, Item: "a"}, {Bytes: 1, Item: "b"}}
+	if got := queue.TopK(3); !reflect.DeepEqual(got, want) {
+		t.Fail()
+		t.Logf("%s: returned %v instead of %v", name, got, want)
+	}
+}
+
+func shouldReturnNilTopKWithoutSize(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	queue.Enqueue("a")
+
+	if got := queue.TopK(1); got != nil {
+		t.Fail()
+		t.Logf("%s: returned %v without a size", name, got)
+	}
+}
+
+func shouldReturnAllOfShortQueue(t *testing.T, name string) {
+	queue := &conq.Queue{Size: lenOf}
+	queue.Enqueue("a")
+	queue.Enqueue("bb")
+
+	if got := queue.TopK(5); len(got) != 2 || got[0].Item != "bb" {
+		t.Fail()
+		t.Logf("%s: returned %v", name, got)
+	}
+}
+
+func shouldTrackBytes(t *testing.T, name string) {
+	queue := &conq.Queue{Size: lenOf}
+	queue.Enqueue("aaa")
+	queue.Enqueue("bb")
+	queue.Enqueue("c")
+	queue.EnqueuePoison(1)
+	before := queue.Stats().Bytes
+
+	queue.Dequeue()
+	queue.Remove("c")
+
+	if after := queue.Stats().Bytes; before != 6 || after != 2 {
+		t.Fail()
+		t.Logf("%s: tracked %d and %d bytes instead of 6 and 2", name, before, after)
+	}
+}
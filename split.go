@@ -0,0 +1,165 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"time"
+)
+
+/*
+Splitter reads an io.Reader, splits it into records, and enqueues each record
+into a queue as a []byte item, for tailing a file or a pipe into a work queue.
+Split decides where records end, like bufio.ScanWords, and it defaults to
+bufio.ScanLines, which enqueues each line without its line ending.
+
+A Splitter applies backpressure to the reader. While the queue has HighWater
+items or more, it stops reading until consumers dequeue items. If TryEnqueue
+returns an error that may clear, like when the queue is over its memory limit,
+the record is enqueued again once the queue changes or after the Backoff, so
+records are not dropped.
+*/
+type Splitter struct {
+	Backoff   time.Duration   // longest wait before enqueuing a rejected record again, defaults to 100ms
+	HighWater int             // items in the queue that pause reading, unlimited when 0
+	MaxSize   int             // most bytes per record, defaults to bufio.MaxScanTokenSize
+	Split     bufio.SplitFunc // splits the input into records, defaults to bufio.ScanLines
+}
+
+/*
+Run reads records from the reader and enqueues them into the queue until the
+reader returns io.EOF or the context is done. It returns nil at the end of the
+input, the error of the context, or the error of the reader or the Split func,
+like bufio.ErrTooLong for a record longer than MaxSize. It returns the error of
+TryEnqueue when enqueuing the record again cannot succeed, like ErrClosed once
+the queue is closed, ErrNotAdmitted, or ErrCostExceeded and ErrBudgetExceeded
+for a record that costs more than the MaxCost of the queue or is bigger than
+the MaxBytes of its Group. The context is checked between reads, so a read that
+blocks is not interrupted.
+*/
+func (s *Splitter) Run(ctx context.Context, r io.Reader, queue *Queue) error {
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+
+	maxSize := s.MaxSize
+	if maxSize <= 0 {
+		maxSize = bufio.MaxScanTokenSize
+	}
+
+	size := 4096
+	if size > maxSize {
+		size = maxSize
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, size), maxSize)
+
+	if s.Split != nil {
+		scanner.Split(s.Split)
+	}
+
+	for {
+		if s.HighWater > 0 {
+			if err := queue.waitBelow(ctx, s.HighWater, true); err != nil {
+				return err
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+
+		record := make([]byte, len(scanner.Bytes()))
+		copy(record, scanner.Bytes())
+
+		for {
+			queue.lock()
+			changed := queue.wait()
+			queue.unlock()
+
+			err := queue.TryEnqueue(record)
+			if err == nil {
+				break
+			}
+
+			if !queue.retryable(record, err) {
+				return err
+			}
+
+			timer := time.NewTimer(backoff)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return ctx.Err()
+			case <-changed:
+				timer.Stop()
+			case <-timer.C:
+				break
+			}
+		}
+	}
+}
+
+func (q *Queue) waitBelow(ctx context.Context, n int, open bool) error {
+	q.lock()
+
+	for !(open && q.closed) && q.pending() >= n {
+		changed := q.wait()
+		q.unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+			break
+		}
+
+		q.lock()
+	}
+
+	closed := q.closed
+	q.unlock()
+
+	if open && closed {
+		return ErrClosed
+	}
+
+	return ctx.Err()
+}
+
+func (q *Queue) retryable(item interface{}, err error) bool {
+	switch err {
+	case ErrMemoryLimit:
+		return true
+	case ErrCostExceeded:
+		q.rlock()
+		defer q.runlock()
+
+		return q.MaxCost <= 0 || q.Cost == nil || q.Cost(item) <= q.MaxCost
+	case ErrBudgetExceeded:
+		q.rlock()
+		group := q.group
+		q.runlock()
+
+		if group == nil {
+			return true
+		}
+
+		group.mut.Lock()
+		defer group.mut.Unlock()
+
+		return group.MaxBytes <= 0 || group.size(item) <= group.MaxBytes
+	default:
+		return false
+	}
+}
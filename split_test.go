@@ -0,0 +1,165 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"bufio"
+	"context"
+	"github.com/sebuckler/conq"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitter_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should enqueue lines":              shouldEnqueueLines,
+		"should enqueue split records":      shouldEnqueueSplitRecords,
+		"should pause reading at highwater": shouldPauseAtHighWater,
+		"should enqueue rejected again":     shouldEnqueueRejectedAgain,
+		"should fail on long record":        shouldFailOnLongRecord,
+		"should stop when queue closed":     shouldStopSplittingWhenClosed,
+		"should fail on record over cost":   shouldFailOnRecordOverCost,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldEnqueueLines(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	splitter := &conq.Splitter{}
+
+	err := splitter.Run(context.Background(), strings.NewReader("a\r\nb\n\nc"), queue)
+
+	want := []interface{}{[]byte("a"), []byte("b"), []byte(""), []byte("c")}
+	if err != nil || !reflect.DeepEqual(queue.Snapshot(), want) {
+		t.Fail()
+		t.Logf("%s: did not enqueue lines %q: %v", name, queue.Snapshot(), err)
+	}
+}
+
+func shouldEnqueueSplitRecords(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	splitter := &conq.Splitter{Split: bufio.ScanWords}
+
+	err := splitter.Run(context.Background(), strings.NewReader(" a  b\nc "), queue)
+
+	want := []interface{}{[]byte("a"), []byte("b"), []byte("c")}
+	if err != nil || !reflect.DeepEqual(queue.Snapshot(), want) {
+		t.Fail()
+		t.Logf("%s: did not enqueue split records %q: %v", name, queue.Snapshot(), err)
+	}
+}
+
+func shouldPauseAtHighWater(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	splitter := &conq.Splitter{HighWater: 2}
+	result := make(chan error)
+
+	go func() {
+		result <- splitter.Run(context.Background(), strings.NewReader("a\nb\nc\nd\n"), queue)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	queue.WaitLen(ctx, 2)
+	time.Sleep(10 * time.Millisecond)
+
+	if queue.Len() != 2 {
+		t.Fail()
+		t.Logf("%s: did not pause at highwater with %d items", name, queue.Len())
+	}
+
+	var items []interface{}
+	for len(items) < 4 {
+		if item, _ := queue.DequeueBlocking(time.Second, time.Millisecond).([]byte); item != nil {
+			items = append(items, item)
+		}
+	}
+
+	if err := <-result; err != nil || !reflect.DeepEqual(items, []interface{}{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}) {
+		t.Fail()
+		t.Logf("%s: did not resume reading %q: %v", name, items, err)
+	}
+}
+
+func shouldEnqueueRejectedAgain(t *testing.T, name string) {
+	group := &conq.Group{MaxLen: 1}
+	queue := &conq.Queue{}
+	group.Add(queue)
+	splitter := &conq.Splitter{Backoff: time.Millisecond}
+	result := make(chan error)
+
+	go func() {
+		result <- splitter.Run(context.Background(), strings.NewReader("a\nb\n"), queue)
+	}()
+
+	first := queue.DequeueBlocking(time.Second, time.Millisecond)
+	second := queue.DequeueBlocking(time.Second, time.Millisecond)
+
+	if err := <-result; err != nil || !reflect.DeepEqual([]interface{}{first, second}, []interface{}{[]byte("a"), []byte("b")}) {
+		t.Fail()
+		t.Logf("%s: did not enqueue rejected record again %q %q: %v", name, first, second, err)
+	}
+}
+
+func shouldFailOnLongRecord(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	splitter := &conq.Splitter{MaxSize: 4}
+
+	if err := splitter.Run(context.Background(), strings.NewReader("ab\nabcdef\n"), queue); err != bufio.ErrTooLong || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: did not fail on long record: %v", name, err)
+	}
+}
+
+func shouldStopSplittingWhenClosed(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	splitter := &conq.Splitter{HighWater: 1}
+	r, w := io.Pipe()
+	result := make(chan error)
+
+	go func() {
+		result <- splitter.Run(context.Background(), r, queue)
+	}()
+
+	w.Write([]byte("a\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	queue.WaitLen(ctx, 1)
+	go w.Write([]byte("b\n"))
+	time.Sleep(10 * time.Millisecond)
+	queue.Close()
+
+	select {
+	case err := <-result:
+		if err != conq.ErrClosed || queue.Len() != 1 {
+			t.Fail()
+			t.Logf("%s: did not stop when queue closed: %v", name, err)
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+		t.Logf("%s: did not stop when queue closed", name)
+	}
+
+	w.Close()
+}
+
+func shouldFailOnRecordOverCost(t *testing.T, name string) {
+	queue := &conq.Queue{Cost: func(item interface{}) int { return len(item.([]byte)) }, MaxCost: 4}
+	splitter := &conq.Splitter{Backoff: time.Millisecond}
+
+	if err := splitter.Run(context.Background(), strings.NewReader("ab\nabcdef\n"), queue); err != conq.ErrCostExceeded || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: did not fail on record over cost: %v", name, err)
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/sebuckler/conq/sqs
+
+go 1.25.0
+
+require (
+	github.com/aws/aws-sdk-go-v2 v1.47.1
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.52.1
+	github.com/sebuckler/conq v0.0.0
+)
+
+require (
+	github.com/aws/aws-sdk-go-v2/internal/configsources v1.5.4 // indirect
+	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.8.4 // indirect
+	github.com/aws/smithy-go v1.28.1 // indirect
+)
+
+replace github.com/sebuckler/conq => ../
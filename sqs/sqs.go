@@ -0,0 +1,415 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+/*
+Package sqs moves messages between Amazon SQS queues and conq queues in both
+directions, so a conq queue can be the local buffer of a cloud consumer. It is
+a separate module, so the conq package itself does not depend on the AWS SDK.
+
+A Source receives messages from an SQS queue in batches and enqueues them into
+a conq queue, and a Sink dequeues items from a conq queue and sends them to an
+SQS queue in batches. Both use a Client, which is satisfied by *sqs.Client.
+Failed calls are retried after a backoff that doubles after each failure in a
+row, from Backoff up to MaxBackoff.
+
+Example code:
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	source := &conqsqs.Source{Client: sqs.NewFromConfig(cfg), QueueURL: url}
+	err = source.Run(ctx, queue)
+*/
+package sqs
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/sebuckler/conq"
+	"strconv"
+	"time"
+)
+
+const (
+	deleteAttempts = 3
+	maxBatch       = 10
+)
+
+/*
+ErrUnencodable is returned by Sink.Run when an item is not []byte or a string,
+and the Sink has no Encode func.
+*/
+var ErrUnencodable = errors.New("conq/sqs: item cannot be encoded")
+
+/*
+Client is the part of an SQS client that is used by a Source and a Sink.
+*/
+type Client interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error)
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+/*
+Source receives messages from an SQS queue and enqueues them into a conq queue.
+Messages are received with long polling in batches of up to BatchSize, and the
+messages that are enqueued are deleted from the SQS queue in one batch. A
+message that cannot be enqueued, like when the conq queue is over its memory
+limit, is not deleted, so SQS delivers it again after its visibility timeout.
+Once the conq queue is closed, the messages received with it are not deleted,
+and Run returns. Decode turns each message into the item that is enqueued, and it defaults to
+the body of the message.
+
+Deletes that fail are retried after the backoff, except for entries that SQS
+rejects as the fault of the sender, like an expired receipt handle. A message
+that is enqueued but still not deleted will be delivered and enqueued again, so
+it is passed to OnDeleteError with the error of its last delete.
+
+Because conq queues have no acknowledgements, a message is only delivered at
+least once until it is enqueued and deleted. Once it is deleted, it is lost if
+the process stops before the item is dequeued.
+*/
+type Source struct {
+	Backoff       time.Duration                      // first wait after a failed call, defaults to 1s
+	BatchSize     int                                // most messages per receive, from 1 to 10, defaults to 10
+	Client        Client                             // client the messages are received with
+	Decode        func(types.Message) interface{}    // turns messages into items, defaults to the body
+	MaxBackoff    time.Duration                      // longest wait after failed calls, defaults to 1m
+	OnDeleteError func(msg types.Message, err error) // called with enqueued messages that were not deleted, ignored when nil
+	QueueURL      string                             // URL of the SQS queue
+	WaitTime      time.Duration                      // long polling time of each receive, defaults to 20s
+}
+
+/*
+Sink dequeues items from a conq queue and sends them to an SQS queue in batches
+of up to BatchSize. A batch is sent as soon as there is an item, with as many
+more items as are already enqueued. Items that SQS fails to accept are sent
+again after the backoff, so items are not lost when SQS is unavailable, except
+for entries that SQS rejects as the fault of the sender, like a message that is
+too large. Those items would never be accepted, so they are dropped and passed
+to OnSendError with the error of their send. Encode turns each item into the
+body of a message, and it defaults to the item for []byte and string items.
+*/
+type Sink struct {
+	Backoff     time.Duration                          // first wait after a failed call, defaults to 1s
+	BatchSize   int                                    // most messages per send, from 1 to 10, defaults to 10
+	Client      Client                                 // client the messages are sent with
+	Encode      func(item interface{}) (string, error) // turns items into message bodies, defaults to the item
+	MaxBackoff  time.Duration                          // longest wait after failed calls, defaults to 1m
+	OnSendError func(item interface{}, err error)      // called with items SQS rejected as the fault of the sender, ignored when nil
+	QueueURL    string                                 // URL of the SQS queue
+	closed      bool
+	pending     []interface{}
+}
+
+type backoff struct {
+	first time.Duration
+	max   time.Duration
+	next  time.Duration
+}
+
+/*
+Run receives messages and enqueues them into the queue until the context is
+done or the queue is closed, and it returns the error of the context or
+conq.ErrClosed. The messages that were enqueued before the queue was closed are
+deleted before Run returns.
+*/
+func (s *Source) Run(ctx context.Context, queue *conq.Queue) error {
+	wait := newBackoff(s.Backoff, s.MaxBackoff)
+	waitTime := s.WaitTime
+	if waitTime <= 0 {
+		waitTime = 20 * time.Second
+	}
+
+	for {
+		out, err := s.Client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(s.QueueURL),
+			MaxNumberOfMessages: int32(batchSize(s.BatchSize)),
+			WaitTimeSeconds:     int32(waitTime / time.Second),
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			if err := wait.sleep(ctx); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		var enqueued []types.Message
+		closed := false
+		for _, msg := range out.Messages {
+			var item interface{} = aws.ToString(msg.Body)
+			if s.Decode != nil {
+				item = s.Decode(msg)
+			}
+
+			err := queue.TryEnqueue(item)
+			if err == nil {
+				enqueued = append(enqueued, msg)
+			} else if err == conq.ErrClosed {
+				closed = true
+
+				break
+			}
+		}
+
+		if err := s.delete(ctx, enqueued, wait); err != nil {
+			return err
+		}
+
+		if closed {
+			return conq.ErrClosed
+		}
+
+		if len(enqueued) == len(out.Messages) {
+			wait.reset()
+		} else if err := wait.sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+Run dequeues items and sends them until the context is done or a poison item is
+dequeued, and it returns the error of the context or conq.ErrClosed. If an item
+cannot be encoded, Run drops it and returns the error. Items that were dequeued
+but not sent when Run returns are sent first by the next call to Run, which
+also returns conq.ErrClosed once they are sent if the poison item was already
+dequeued. Run must not be called concurrently.
+*/
+func (s *Sink) Run(ctx context.Context, queue *conq.Queue) error {
+	wait := newBackoff(s.Backoff, s.MaxBackoff)
+	size := batchSize(s.BatchSize)
+
+	for {
+		if !s.closed && len(s.pending) == 0 {
+			if err := queue.WaitLen(ctx, 1); err != nil {
+				return err
+			}
+		}
+
+		for !s.closed && len(s.pending) < size {
+			item := queue.Dequeue()
+			if item == nil {
+				break
+			}
+
+			if item == conq.ErrClosed {
+				s.closed = true
+
+				break
+			}
+
+			s.pending = append(s.pending, item)
+		}
+
+		if err := s.send(ctx, wait); err != nil {
+			return err
+		}
+
+		if s.closed {
+			for len(s.pending) > 0 {
+				if err := s.send(ctx, wait); err != nil {
+					return err
+				}
+			}
+
+			s.closed = false
+
+			return conq.ErrClosed
+		}
+	}
+}
+
+func (s *Source) delete(ctx context.Context, msgs []types.Message, wait *backoff) error {
+	for attempt := 1; len(msgs) > 0; attempt += 1 {
+		entries := make([]types.DeleteMessageBatchRequestEntry, len(msgs))
+		for i, msg := range msgs {
+			entries[i] = types.DeleteMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), ReceiptHandle: msg.ReceiptHandle}
+		}
+
+		out, err := s.Client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			Entries:  entries,
+			QueueUrl: aws.String(s.QueueURL),
+		})
+
+		var retry []types.Message
+		var errs []error
+
+		if err != nil {
+			retry = msgs
+
+			for range msgs {
+				errs = append(errs, err)
+			}
+		} else {
+			for _, entry := range out.Failed {
+				i, convErr := strconv.Atoi(aws.ToString(entry.Id))
+				if convErr != nil || i >= len(msgs) {
+					continue
+				}
+
+				if entry.SenderFault {
+					s.undeleted(msgs[i], batchError(entry))
+				} else {
+					retry = append(retry, msgs[i])
+					errs = append(errs, batchError(entry))
+				}
+			}
+		}
+
+		if len(retry) == 0 {
+			return nil
+		}
+
+		if attempt == deleteAttempts {
+			for i, msg := range retry {
+				s.undeleted(msg, errs[i])
+			}
+
+			return nil
+		}
+
+		if err := wait.sleep(ctx); err != nil {
+			for _, msg := range retry {
+				s.undeleted(msg, err)
+			}
+
+			return err
+		}
+
+		msgs = retry
+	}
+
+	return nil
+}
+
+func (s *Source) undeleted(msg types.Message, err error) {
+	if s.OnDeleteError != nil {
+		s.OnDeleteError(msg, err)
+	}
+}
+
+func (s *Sink) send(ctx context.Context, wait *backoff) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	entries := make([]types.SendMessageBatchRequestEntry, len(s.pending))
+	for i, item := range s.pending {
+		body, err := s.encode(item)
+		if err != nil {
+			s.pending = append(s.pending[:i:i], s.pending[i+1:]...)
+
+			return err
+		}
+
+		entries[i] = types.SendMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), MessageBody: aws.String(body)}
+	}
+
+	out, err := s.Client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		Entries:  entries,
+		QueueUrl: aws.String(s.QueueURL),
+	})
+	if err != nil {
+		return wait.sleep(ctx)
+	}
+
+	var failed []interface{}
+	for _, entry := range out.Failed {
+		i, convErr := strconv.Atoi(aws.ToString(entry.Id))
+		if convErr != nil || i >= len(s.pending) {
+			continue
+		}
+
+		if entry.SenderFault {
+			s.unsent(s.pending[i], batchError(entry))
+		} else {
+			failed = append(failed, s.pending[i])
+		}
+	}
+
+	s.pending = failed
+
+	if len(failed) > 0 {
+		return wait.sleep(ctx)
+	}
+
+	wait.reset()
+
+	return nil
+}
+
+func (s *Sink) unsent(item interface{}, err error) {
+	if s.OnSendError != nil {
+		s.OnSendError(item, err)
+	}
+}
+
+func (s *Sink) encode(item interface{}) (string, error) {
+	if s.Encode != nil {
+		return s.Encode(item)
+	}
+
+	switch item := item.(type) {
+	case []byte:
+		return string(item), nil
+	case string:
+		return item, nil
+	default:
+		return "", ErrUnencodable
+	}
+}
+
+func batchError(entry types.BatchResultErrorEntry) error {
+	return errors.New("conq/sqs: " + aws.ToString(entry.Code) + ": " + aws.ToString(entry.Message))
+}
+
+func batchSize(n int) int {
+	if n <= 0 || n > maxBatch {
+		return maxBatch
+	}
+
+	return n
+}
+
+func newBackoff(first time.Duration, max time.Duration) *backoff {
+	if first <= 0 {
+		first = time.Second
+	}
+
+	if max <= 0 {
+		max = time.Minute
+	}
+
+	return &backoff{first: first, max: max, next: first}
+}
+
+func (b *backoff) sleep(ctx context.Context) error {
+	timer := time.NewTimer(b.next)
+	defer timer.Stop()
+
+	if b.next *= 2; b.next > b.max {
+		b.next = b.max
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (b *backoff) reset() {
+	b.next = b.first
+}
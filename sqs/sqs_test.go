@@ -0,0 +1,290 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package sqs_test
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/sebuckler/conq"
+	conqsqs "github.com/sebuckler/conq/sqs"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClient struct {
+	deleteErrs int
+	deleted    []string
+	expired    map[string]bool
+	failSend   []int
+	mut        sync.Mutex
+	received   [][]string
+	rejectSend map[string]bool
+	sent       []string
+	sends      int
+}
+
+func (c *fakeClient) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if len(c.received) == 0 {
+		c.mut.Unlock()
+		<-ctx.Done()
+		c.mut.Lock()
+
+		return nil, ctx.Err()
+	}
+
+	bodies := c.received[0]
+	c.received = c.received[1:]
+
+	if bodies == nil {
+		return nil, errors.New("sqs unavailable")
+	}
+
+	out := &sqs.ReceiveMessageOutput{}
+	for _, body := range bodies {
+		out.Messages = append(out.Messages, types.Message{Body: aws.String(body), ReceiptHandle: aws.String("rh-" + body)})
+	}
+
+	return out, nil
+}
+
+func (c *fakeClient) DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.deleteErrs > 0 {
+		c.deleteErrs -= 1
+
+		return nil, errors.New("sqs unavailable")
+	}
+
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		if handle := aws.ToString(entry.ReceiptHandle); c.expired[handle] {
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("ReceiptHandleIsInvalid"), SenderFault: true})
+		} else {
+			c.deleted = append(c.deleted, handle)
+		}
+	}
+
+	return out, nil
+}
+
+func (c *fakeClient) SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.sends += 1
+	out := &sqs.SendMessageBatchOutput{}
+
+	for i, entry := range params.Entries {
+		if len(c.failSend) > 0 && c.failSend[0] == i {
+			c.failSend = c.failSend[1:]
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: entry.Id})
+
+			continue
+		}
+
+		if body := aws.ToString(entry.MessageBody); c.rejectSend[body] {
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InvalidMessageContents"), SenderFault: true})
+
+			continue
+		}
+
+		c.sent = append(c.sent, aws.ToString(entry.MessageBody))
+	}
+
+	return out, nil
+}
+
+func TestSource_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should enqueue and delete messages":      shouldEnqueueDeleteMessages,
+		"should not delete messages not enqueued": shouldNotDeleteNotEnqueued,
+		"should retry failed deletes":             shouldRetryFailedDeletes,
+		"should report messages not deleted":      shouldReportNotDeleted,
+		"should return when queue is closed":      shouldReturnSourceClosed,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestSink_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should send items in batches":   shouldSendBatches,
+		"should send failed items again": shouldSendFailedAgain,
+		"should report rejected items":   shouldReportRejected,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func runSource(source *conqsqs.Source, queue *conq.Queue) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	source.Run(ctx, queue)
+}
+
+func shouldEnqueueDeleteMessages(t *testing.T, name string) {
+	client := &fakeClient{received: [][]string{{"a", "b"}, nil, {"c"}}}
+	source := &conqsqs.Source{Backoff: time.Millisecond, Client: client}
+	queue := &conq.Queue{}
+
+	runSource(source, queue)
+
+	if !reflect.DeepEqual(queue.Snapshot(), []interface{}{"a", "b", "c"}) || !reflect.DeepEqual(client.deleted, []string{"rh-a", "rh-b", "rh-c"}) {
+		t.Fail()
+		t.Logf("%s: did not enqueue and delete messages %v", name, client.deleted)
+	}
+}
+
+func shouldNotDeleteNotEnqueued(t *testing.T, name string) {
+	client := &fakeClient{received: [][]string{{"a", "b"}}}
+	source := &conqsqs.Source{Backoff: time.Millisecond, Client: client}
+	group := &conq.Group{MaxLen: 1}
+	queue := &conq.Queue{}
+	group.Add(queue)
+
+	runSource(source, queue)
+
+	if queue.Len() != 1 || !reflect.DeepEqual(client.deleted, []string{"rh-a"}) {
+		t.Fail()
+		t.Logf("%s: deleted messages not enqueued %v", name, client.deleted)
+	}
+}
+
+func shouldRetryFailedDeletes(t *testing.T, name string) {
+	client := &fakeClient{deleteErrs: 2, received: [][]string{{"a", "b"}}}
+	var undeleted []string
+	source := &conqsqs.Source{
+		Backoff:       time.Millisecond,
+		Client:        client,
+		OnDeleteError: func(msg types.Message, err error) { undeleted = append(undeleted, aws.ToString(msg.Body)) },
+	}
+	queue := &conq.Queue{}
+
+	runSource(source, queue)
+
+	if queue.Len() != 2 || len(undeleted) != 0 || !reflect.DeepEqual(client.deleted, []string{"rh-a", "rh-b"}) {
+		t.Fail()
+		t.Logf("%s: did not retry deletes %v %v", name, client.deleted, undeleted)
+	}
+}
+
+func shouldReportNotDeleted(t *testing.T, name string) {
+	client := &fakeClient{expired: map[string]bool{"rh-b": true}, received: [][]string{{"a", "b"}}}
+	var undeleted []string
+	var cause error
+	source := &conqsqs.Source{
+		Backoff: time.Millisecond,
+		Client:  client,
+		OnDeleteError: func(msg types.Message, err error) {
+			undeleted = append(undeleted, aws.ToString(msg.Body))
+			cause = err
+		},
+	}
+	queue := &conq.Queue{}
+
+	runSource(source, queue)
+
+	if queue.Len() != 2 || !reflect.DeepEqual(undeleted, []string{"b"}) || !reflect.DeepEqual(client.deleted, []string{"rh-a"}) ||
+		cause == nil || cause.Error() != "conq/sqs: ReceiptHandleIsInvalid: " {
+		t.Fail()
+		t.Logf("%s: did not report messages not deleted %v: %v", name, undeleted, cause)
+	}
+}
+
+func shouldReturnSourceClosed(t *testing.T, name string) {
+	client := &fakeClient{received: [][]string{{"a", "b", "c"}, {"d"}}}
+	queue := &conq.Queue{}
+	source := &conqsqs.Source{
+		Backoff: time.Millisecond,
+		Client:  client,
+		Decode: func(msg types.Message) interface{} {
+			if aws.ToString(msg.Body) == "b" {
+				queue.Close()
+			}
+
+			return aws.ToString(msg.Body)
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := source.Run(ctx, queue); err != conq.ErrClosed || queue.Len() != 1 || !reflect.DeepEqual(client.deleted, []string{"rh-a"}) {
+		t.Fail()
+		t.Logf("%s: did not return when queue was closed %v: %v", name, client.deleted, err)
+	}
+}
+
+func shouldSendBatches(t *testing.T, name string) {
+	client := &fakeClient{}
+	sink := &conqsqs.Sink{BatchSize: 2, Client: client}
+	queue := &conq.Queue{}
+
+	for _, item := range []string{"a", "b", "c"} {
+		queue.Enqueue(item)
+	}
+
+	queue.EnqueuePoison(1)
+
+	if err := sink.Run(context.Background(), queue); err != conq.ErrClosed || client.sends != 2 || !reflect.DeepEqual(client.sent, []string{"a", "b", "c"}) {
+		t.Fail()
+		t.Logf("%s: did not send items in batches %v: %v", name, client.sent, err)
+	}
+}
+
+func shouldSendFailedAgain(t *testing.T, name string) {
+	client := &fakeClient{failSend: []int{1}}
+	sink := &conqsqs.Sink{Backoff: time.Millisecond, Client: client}
+	queue := &conq.Queue{}
+
+	queue.Enqueue("a")
+	queue.Enqueue([]byte("b"))
+	queue.EnqueuePoison(1)
+
+	if err := sink.Run(context.Background(), queue); err != conq.ErrClosed || !reflect.DeepEqual(client.sent, []string{"a", "b"}) {
+		t.Fail()
+		t.Logf("%s: did not send failed item again %v: %v", name, client.sent, err)
+	}
+}
+
+func shouldReportRejected(t *testing.T, name string) {
+	client := &fakeClient{rejectSend: map[string]bool{"b": true}}
+	var rejected []interface{}
+	var cause error
+	sink := &conqsqs.Sink{
+		Backoff: time.Millisecond,
+		Client:  client,
+		OnSendError: func(item interface{}, err error) {
+			rejected = append(rejected, item)
+			cause = err
+		},
+	}
+	queue := &conq.Queue{}
+
+	queue.Enqueue("a")
+	queue.Enqueue("b")
+	queue.Enqueue("c")
+	queue.EnqueuePoison(1)
+
+	if err := sink.Run(context.Background(), queue); err != conq.ErrClosed || client.sends != 1 || !reflect.DeepEqual(client.sent, []string{"a", "c"}) ||
+		!reflect.DeepEqual(rejected, []interface{}{"b"}) || cause == nil || cause.Error() != "conq/sqs: InvalidMessageContents: " {
+		t.Fail()
+		t.Logf("%s: did not report rejected items %v %v: %v", name, client.sent, rejected, cause)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"fmt"
+	"time"
+)
+
+var waitBounds = [...]time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+/*
+Stats is a snapshot of the statistics of a queue at the time it was taken.
+*/
+type Stats struct {
+	Len         int            // number of items enqueued
+	Bytes       int            // bytes of the items enqueued, when sized
+	Cost        int            // cost of the items enqueued, when costed
+	Oldest      time.Duration  // how long the item at the head has been enqueued, when ages are tracked
+	Enqueued    uint64         // total number of items ever enqueued
+	Dequeued    uint64         // total number of items ever dequeued
+	Removed     uint64         // total number of items removed without being dequeued
+	Dropped     uint64         // total number of items rejected by Enqueue or evicted by a Group
+	DequeueWait Histogram      // how long each DequeueBlocking call waited
+	QueueTime   Histogram      // how long dequeued items were enqueued, when ages are tracked
+	Classes     map[string]int // number of items enqueued by class, when classified
+}
+
+/*
+Histogram is a snapshot of a distribution of durations. Each count in Counts is
+the number of durations that were less than or equal to the bound at the same
+index in Bounds, and greater than the previous bound. Counts has one more count
+than Bounds for durations greater than the last bound. Count and Sum are the
+total number of durations and their total.
+*/
+type Histogram struct {
+	Bounds []time.Duration // upper bounds of the buckets
+	Counts []uint64        // count of durations in each bucket
+	Count  uint64          // count of all durations
+	Sum    time.Duration   // sum of all durations
+}
+
+type histogram struct {
+	counts [len(waitBounds) + 1]uint64
+	count  uint64
+	sum    time.Duration
+}
+
+/*
+Stats returns a snapshot of the statistics of the queue. Stats locks the queue
+while it is copying the statistics.
+*/
+func (q *Queue) Stats() Stats {
+	q.rlock()
+	defer q.runlock()
+
+	return q.stats()
+}
+
+/*
+TypeName classifies an item by the name of its type, like "int" or "*main.Job".
+It can be used as the Classify func of a queue to count items by type.
+*/
+func TypeName(item interface{}) string {
+	return fmt.Sprintf("%T", item)
+}
+
+func (q *Queue) stats() Stats {
+	var classes map[string]int
+	if q.Classify != nil {
+		classes = make(map[string]int, len(q.classes))
+
+		for class, n := range q.classes {
+			classes[class] = n
+		}
+	}
+
+	var oldest time.Duration
+	if q.len > 0 {
+		if e, ok := q.head.items[q.rx].(*envelope); ok && !e.enqueued.IsZero() {
+			oldest = clockOr(q.Clock).Now().Sub(e.enqueued)
+		}
+	}
+
+	return Stats{
+		Len:         q.pending(),
+		Bytes:       q.bytes,
+		Cost:        q.cost,
+		Oldest:      oldest,
+		Enqueued:    q.enqueued,
+		Dequeued:    q.dequeued,
+		Removed:     q.removed,
+		Dropped:     q.dropped,
+		DequeueWait: q.waits.snapshot(),
+		QueueTime:   q.ages.snapshot(),
+		Classes:     classes,
+	}
+}
+
+func (q *Queue) observeAge() {
+	if q.len == 0 {
+		return
+	}
+
+	if e, ok := q.head.items[q.rx].(*envelope); ok && !e.enqueued.IsZero() {
+		q.ages.observe(clockOr(q.Clock).Now().Sub(e.enqueued))
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(waitBounds) && d > waitBounds[i] {
+		i += 1
+	}
+
+	h.counts[i] += 1
+	h.count += 1
+	h.sum += d
+}
+
+func (h *histogram) snapshot() Histogram {
+	bounds := make([]time.Duration, len(waitBounds))
+	copy(bounds, waitBounds[:])
+
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts[:])
+
+	return Histogram{Bounds: bounds, Counts: counts, Count: h.count, Sum: h.sum}
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+func TestQueue_Stats(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have len":                     shouldHaveStatsLen,
+		"should have totals":                  shouldHaveStatsTotals,
+		"should have classes":                 shouldHaveStatsClasses,
+		"should not have classes by default":  shouldNotHaveStatsClasses,
+		"should have dequeue wait histogram":  shouldHaveDequeueWaits,
+		"should have dequeue wait on timeout": shouldHaveDequeueWaitOnTimeout,
+		"should have queue time histogram":    shouldHaveQueueTimes,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldHaveStatsLen(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+
+	if stats := queue.Stats(); stats.Len != 2 {
+		t.Fail()
+		t.Logf("%s: did not have correct len %d", name, stats.Len)
+	}
+}
+
+func shouldHaveStatsTotals(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	queue.Enqueue(3)
+	queue.Dequeue()
+	queue.Remove(3)
+
+	if stats := queue.Stats(); stats.Enqueued != 3 || stats.Dequeued != 1 || stats.Removed != 1 {
+		t.Fail()
+		t.Logf("%s: did not have correct totals %+v", name, stats)
+	}
+}
+
+func shouldHaveStatsClasses(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, Classify: conq.TypeName}
+
+	queue.Enqueue(1)
+	queue.Enqueue("a")
+	queue.Enqueue(2)
+	queue.Enqueue("b")
+	queue.Dequeue()
+	queue.Remove("a")
+	classes := queue.Stats().Classes
+
+	if len(classes) != 2 || classes["int"] != 1 || classes["string"] != 1 {
+		t.Fail()
+		t.Logf("%s: did not have correct classes %v", name, classes)
+	}
+}
+
+func shouldNotHaveStatsClasses(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	queue.Enqueue(1)
+
+	if classes := queue.Stats().Classes; classes != nil {
+		t.Fail()
+		t.Logf("%s: had classes %v", name, classes)
+	}
+}
+
+func shouldHaveDequeueWaits(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	queue.Enqueue(1)
+	queue.DequeueBlocking(0, 0)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		queue.Enqueue(2)
+	}()
+
+	queue.DequeueBlocking(time.Second, time.Millisecond)
+	wait := queue.Stats().DequeueWait
+	slow := uint64(0)
+
+	for i, bound := range wait.Bounds {
+		if bound >= 10*time.Millisecond {
+			slow += wait.Counts[i]
+		}
+	}
+
+	slow += wait.Counts[len(wait.Bounds)]
+
+	if wait.Count != 2 || slow != 1 || wait.Sum < 20*time.Millisecond || len(wait.Counts) != len(wait.Bounds)+1 {
+		t.Fail()
+		t.Logf("%s: did not have correct wait histogram %+v", name, wait)
+	}
+}
+
+func shouldHaveDequeueWaitOnTimeout(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3}
+
+	queue.DequeueBlocking(time.Millisecond, 0)
+
+	if wait := queue.Stats().DequeueWait; wait.Count != 1 || wait.Sum < time.Millisecond {
+		t.Fail()
+		t.Logf("%s: did not have correct wait histogram %+v", name, wait)
+	}
+}
+
+func shouldHaveQueueTimes(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, TrackAge: true}
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	sim.Sleep(50 * time.Millisecond)
+	queue.Dequeue()
+	queue.Remove(2)
+
+	queueTime := queue.Stats().QueueTime
+	if queueTime.Count != 1 || queueTime.Sum != 50*time.Millisecond || queueTime.Counts[4] != 1 {
+		t.Fail()
+		t.Logf("%s: did not have queue time histogram %+v", name, queueTime)
+	}
+}
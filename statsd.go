@@ -0,0 +1,166 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const statsdPacketSize = 1432
+
+var tagEscaper = strings.NewReplacer(",", "_", "|", "_", ":", "_", "#", "_", "\n", "_")
+
+/*
+StatsD periodically sends the Stats of queues to a StatsD server over UDP, for
+systems that are not scraped by Prometheus. Every metric name starts with the
+Prefix, and every metric has a queue tag with the name of the queue, a tag for
+each of the Labels of the queue, and the Tags. Tags are sent in the DogStatsD
+format, which is understood by Datadog agents and other StatsD servers that
+support tags. The characters ',', '|', ':', '#', and newlines separate the parts
+of the format, so they are replaced with '_' in queue names and labels.
+
+The Len, Bytes, and Oldest stats of each queue are sent as gauges, with Oldest
+in milliseconds. The Enqueued, Dequeued, Removed, and Dropped totals are sent as
+counters of how many items were counted since the last send. The DequeueWait
+and QueueTime histograms are sent as counters of how many durations and how
+many milliseconds were observed since the last send, and a counter for each
+bucket with an le tag of its upper bound in seconds.
+*/
+type StatsD struct {
+	Addr     string        // host:port of the StatsD server
+	Interval time.Duration // time between sends, defaults to 10s
+	Prefix   string        // prefix for every metric name, like "myapp.conq."
+	Tags     []string      // tags for every metric, like "env:prod"
+}
+
+/*
+Run sends the Stats of the named queues every interval until the context is
+done. It sends one last time before it returns, and it returns the error of the
+context. If the StatsD server address cannot be resolved, Run returns the error
+immediately. Errors sending metrics are ignored, as StatsD is best effort.
+*/
+func (s *StatsD) Run(ctx context.Context, queues map[string]*Queue) error {
+	conn, err := net.Dial("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	interval := s.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := make(map[string]Stats, len(queues))
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.send(conn, queues, last)
+
+			return ctx.Err()
+		case <-ticker.C:
+			s.send(conn, queues, last)
+		}
+	}
+}
+
+func (s *StatsD) send(conn net.Conn, queues map[string]*Queue, last map[string]Stats) {
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		stats := queues[name].Stats()
+		prev := last[name]
+		tags := append(append([]string{"queue:" + tagEscaper.Replace(name)}, labelTags(queues[name].Labels)...), s.Tags...)
+
+		lines = append(lines,
+			s.line("len", strconv.Itoa(stats.Len), "g", tags),
+			s.line("bytes", strconv.Itoa(stats.Bytes), "g", tags),
+			s.line("oldest.ms", formatMillis(stats.Oldest), "g", tags),
+			s.line("enqueued", strconv.FormatUint(stats.Enqueued-prev.Enqueued, 10), "c", tags),
+			s.line("dequeued", strconv.FormatUint(stats.Dequeued-prev.Dequeued, 10), "c", tags),
+			s.line("removed", strconv.FormatUint(stats.Removed-prev.Removed, 10), "c", tags),
+			s.line("dropped", strconv.FormatUint(stats.Dropped-prev.Dropped, 10), "c", tags),
+		)
+		lines = s.histogram(lines, "dequeue_wait", stats.DequeueWait, prev.DequeueWait, tags)
+		lines = s.histogram(lines, "queue_time", stats.QueueTime, prev.QueueTime, tags)
+
+		last[name] = stats
+	}
+
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > statsdPacketSize {
+			conn.Write([]byte(packet.String()))
+			packet.Reset()
+		}
+
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+
+		packet.WriteString(line)
+	}
+
+	if packet.Len() > 0 {
+		conn.Write([]byte(packet.String()))
+	}
+}
+
+func (s *StatsD) histogram(lines []string, name string, h Histogram, prev Histogram, tags []string) []string {
+	lines = append(lines,
+		s.line(name+".count", strconv.FormatUint(h.Count-prev.Count, 10), "c", tags),
+		s.line(name+".ms", formatMillis(h.Sum-prev.Sum), "c", tags),
+	)
+
+	for i, count := range h.Counts {
+		if prev.Counts != nil {
+			count -= prev.Counts[i]
+		}
+
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i].Seconds(), 'f', -1, 64)
+		}
+
+		lines = append(lines, s.line(name+".bucket", strconv.FormatUint(count, 10), "c", append(tags, "le:"+le)))
+	}
+
+	return lines
+}
+
+func (s *StatsD) line(name string, value string, kind string, tags []string) string {
+	return s.Prefix + name + ":" + value + "|" + kind + "|#" + strings.Join(tags, ",")
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
+
+func labelTags(labels map[string]string) []string {
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tags = append(tags, tagEscaper.Replace(k)+":"+tagEscaper.Replace(v))
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
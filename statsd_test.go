@@ -0,0 +1,192 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsD_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should send queue stats":      shouldSendStatsD,
+		"should fail with bad server":  shouldFailStatsDAddr,
+		"should tag queue labels":      shouldTagStatsDLabels,
+		"should escape tag separators": shouldEscapeStatsDTags,
+		"should send every stat":       shouldSendEveryStatsD,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldSendStatsD(t *testing.T, name string) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s: could not listen: %v", name, err)
+	}
+
+	defer server.Close()
+
+	queue := &conq.Queue{Capacity: 3}
+	statsd := &conq.StatsD{
+		Addr:     server.LocalAddr().String(),
+		Interval: time.Millisecond,
+		Prefix:   "app.conq.",
+		Tags:     []string{"env:test"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+
+	go statsd.Run(ctx, map[string]*conq.Queue{"jobs": queue})
+
+	buf := make([]byte, 2048)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := server.ReadFrom(buf)
+	packet := string(buf[:n])
+
+	if err != nil ||
+		!strings.Contains(packet, "app.conq.len:2|g|#queue:jobs,env:test") ||
+		!strings.Contains(packet, "app.conq.dequeue_wait.count:0|c|#queue:jobs,env:test") ||
+		!strings.Contains(packet, "app.conq.dequeue_wait.bucket:0|c|#queue:jobs,env:test,le:+Inf") {
+		t.Fail()
+		t.Logf("%s: did not send stats %q: %v", name, packet, err)
+	}
+}
+
+func shouldFailStatsDAddr(t *testing.T, name string) {
+	statsd := &conq.StatsD{Addr: "not an address"}
+
+	if err := statsd.Run(context.Background(), nil); err == nil {
+		t.Fail()
+		t.Logf("%s: did not fail", name)
+	}
+}
+
+func shouldTagStatsDLabels(t *testing.T, name string) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s: could not listen: %v", name, err)
+	}
+
+	defer server.Close()
+
+	queue := &conq.Queue{Labels: map[string]string{"tenant": "acme", "service": "billing"}}
+	statsd := &conq.StatsD{Addr: server.LocalAddr().String(), Interval: time.Millisecond, Tags: []string{"env:test"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go statsd.Run(ctx, map[string]*conq.Queue{"jobs": queue})
+
+	buf := make([]byte, 2048)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := server.ReadFrom(buf)
+	packet := string(buf[:n])
+
+	if err != nil || !strings.Contains(packet, "len:0|g|#queue:jobs,service:billing,tenant:acme,env:test") {
+		t.Fail()
+		t.Logf("%s: did not tag labels %q: %v", name, packet, err)
+	}
+}
+
+func shouldEscapeStatsDTags(t *testing.T, name string) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s: could not listen: %v", name, err)
+	}
+
+	defer server.Close()
+
+	queue := &conq.Queue{Labels: map[string]string{"team:id": "a,b|c#d"}}
+	statsd := &conq.StatsD{Addr: server.LocalAddr().String(), Interval: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go statsd.Run(ctx, map[string]*conq.Queue{"jobs:1": queue})
+
+	buf := make([]byte, 2048)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := server.ReadFrom(buf)
+	packet := string(buf[:n])
+
+	if err != nil || !strings.Contains(packet, "len:0|g|#queue:jobs_1,team_id:a_b_c_d\n") {
+		t.Fail()
+		t.Logf("%s: did not escape tag separators %q: %v", name, packet, err)
+	}
+}
+
+func shouldSendEveryStatsD(t *testing.T, name string) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s: could not listen: %v", name, err)
+	}
+
+	defer server.Close()
+
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim, Size: func(item interface{}) int { return len(item.(string)) }, TrackAge: true}
+	statsd := &conq.StatsD{Addr: server.LocalAddr().String(), Interval: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue.Enqueue("aa")
+	queue.Enqueue("bbb")
+	queue.Enqueue("c")
+	sim.Sleep(2 * time.Second)
+	queue.Dequeue()
+	queue.Remove("bbb")
+	queue.Close()
+	queue.Enqueue("d")
+
+	go statsd.Run(ctx, map[string]*conq.Queue{"jobs": queue})
+
+	buf := make([]byte, 2048)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := server.ReadFrom(buf)
+	lines := strings.Split(string(buf[:n]), "\n")
+	expected := []string{
+		"len:1|g|#queue:jobs",
+		"bytes:1|g|#queue:jobs",
+		"oldest.ms:2000|g|#queue:jobs",
+		"enqueued:3|c|#queue:jobs",
+		"dequeued:1|c|#queue:jobs",
+		"removed:1|c|#queue:jobs",
+		"dropped:1|c|#queue:jobs",
+		"dequeue_wait.count:0|c|#queue:jobs",
+		"dequeue_wait.ms:0|c|#queue:jobs",
+		"dequeue_wait.bucket:0|c|#queue:jobs,le:0.00001",
+		"dequeue_wait.bucket:0|c|#queue:jobs,le:0.0001",
+		"dequeue_wait.bucket:0|c|#queue:jobs,le:0.001",
+		"dequeue_wait.bucket:0|c|#queue:jobs,le:0.01",
+		"dequeue_wait.bucket:0|c|#queue:jobs,le:0.1",
+		"dequeue_wait.bucket:0|c|#queue:jobs,le:1",
+		"dequeue_wait.bucket:0|c|#queue:jobs,le:10",
+		"dequeue_wait.bucket:0|c|#queue:jobs,le:+Inf",
+		"queue_time.count:1|c|#queue:jobs",
+		"queue_time.ms:2000|c|#queue:jobs",
+		"queue_time.bucket:0|c|#queue:jobs,le:0.00001",
+		"queue_time.bucket:0|c|#queue:jobs,le:0.0001",
+		"queue_time.bucket:0|c|#queue:jobs,le:0.001",
+		"queue_time.bucket:0|c|#queue:jobs,le:0.01",
+		"queue_time.bucket:0|c|#queue:jobs,le:0.1",
+		"queue_time.bucket:0|c|#queue:jobs,le:1",
+		"queue_time.bucket:1|c|#queue:jobs,le:10",
+		"queue_time.bucket:0|c|#queue:jobs,le:+Inf",
+	}
+
+	if err != nil || !reflect.DeepEqual(lines, expected) {
+		t.Fail()
+		t.Logf("%s: did not send every stat %q: %v", name, lines, err)
+	}
+}
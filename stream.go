@@ -0,0 +1,138 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+/*
+ErrNotBytes is returned by Reader.Read when it dequeues an item that is not
+[]byte or a string.
+*/
+var ErrNotBytes = errors.New("conq: item is not bytes")
+
+/*
+Writer is an io.Writer that enqueues the bytes of each Write into a queue, so
+the queue can buffer between components that only speak io interfaces, like a
+logger and a log shipper. Each Write is split into []byte items of up to
+ChunkSize bytes, and the bytes are copied, so callers can reuse their buffers
+once Write returns.
+*/
+type Writer struct {
+	ChunkSize int    // most bytes per item, defaults to 32KiB
+	Queue     *Queue // queue the bytes are enqueued into
+}
+
+/*
+Reader is an io.Reader that streams the bytes of items dequeued from a queue.
+Items must be []byte or strings. Read blocks until there is an item, and it
+returns io.EOF once a poison item is dequeued, like the one added by closing a
+Writer.
+*/
+type Reader struct {
+	Queue  *Queue // queue the bytes are dequeued from
+	buf    []byte
+	closed bool
+}
+
+/*
+NewWriter returns a Writer that enqueues into the queue.
+*/
+func NewWriter(q *Queue) *Writer {
+	return &Writer{Queue: q}
+}
+
+/*
+NewReader returns a Reader that dequeues from the queue.
+*/
+func NewReader(q *Queue) *Reader {
+	return &Reader{Queue: q}
+}
+
+/*
+Write enqueues a copy of p in items of up to ChunkSize bytes, and it returns how
+many bytes were enqueued. If an item is not enqueued, Write returns the error
+of Enqueue, and the bytes of the items that were already enqueued stay in the
+queue.
+*/
+func (w *Writer) Write(p []byte) (int, error) {
+	size := w.ChunkSize
+	if size <= 0 {
+		size = 32 << 10
+	}
+
+	n := 0
+	for n < len(p) {
+		end := n + size
+		if end > len(p) {
+			end = len(p)
+		}
+
+		if err := w.Queue.TryEnqueue(append([]byte(nil), p[n:end]...)); err != nil {
+			return n, err
+		}
+
+		n = end
+	}
+
+	return n, nil
+}
+
+/*
+Close enqueues one poison item, so a Reader of the queue returns io.EOF after
+it has read the bytes that were written before. Close always returns nil.
+*/
+func (w *Writer) Close() error {
+	w.Queue.EnqueuePoison(1)
+
+	return nil
+}
+
+/*
+Read reads bytes from the item that was dequeued last, and it dequeues the next
+item once all of its bytes are read. Read blocks until there is an item, and it
+does not wait for more items once it has read some bytes. If an item is not
+[]byte or a string, Read drops it and returns ErrNotBytes, and if the queue is
+interrupted while Read waits, it returns ErrInterrupted.
+*/
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.closed {
+			return 0, io.EOF
+		}
+
+		if len(p) == 0 {
+			return 0, nil
+		}
+
+		item, err := r.Queue.dequeueContext(context.Background())
+		if err != nil {
+			return 0, err
+		}
+
+		switch item := item.(type) {
+		case []byte:
+			r.buf = item
+		case string:
+			r.buf = []byte(item)
+		default:
+			if item == ErrClosed {
+				r.closed = true
+
+				continue
+			}
+
+			return 0, ErrNotBytes
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
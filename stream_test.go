@@ -0,0 +1,126 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"bytes"
+	"github.com/sebuckler/conq"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWriter_Write(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should enqueue chunks of bytes": shouldEnqueueChunks,
+		"should copy written bytes":      shouldCopyWritten,
+		"should stop at rejected chunk":  shouldStopAtRejected,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func TestReader_Read(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should stream written bytes":    shouldStreamWritten,
+		"should reject items not bytes":  shouldRejectNotBytes,
+		"should block until bytes exist": shouldBlockUntilBytes,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldEnqueueChunks(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	w := &conq.Writer{ChunkSize: 4, Queue: queue}
+
+	n, err := w.Write([]byte("abcdefghij"))
+
+	want := []interface{}{[]byte("abcd"), []byte("efgh"), []byte("ij")}
+	if n != 10 || err != nil || !reflect.DeepEqual(queue.Snapshot(), want) {
+		t.Fail()
+		t.Logf("%s: did not enqueue chunks %q: %v", name, queue.Snapshot(), err)
+	}
+}
+
+func shouldCopyWritten(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	buf := []byte("abc")
+
+	conq.NewWriter(queue).Write(buf)
+	copy(buf, "xyz")
+
+	if item := queue.Dequeue(); !bytes.Equal(item.([]byte), []byte("abc")) {
+		t.Fail()
+		t.Logf("%s: did not copy written bytes %q", name, item)
+	}
+}
+
+func shouldStopAtRejected(t *testing.T, name string) {
+	group := &conq.Group{MaxLen: 2}
+	queue := &conq.Queue{}
+	group.Add(queue)
+	w := &conq.Writer{ChunkSize: 2, Queue: queue}
+
+	if n, err := w.Write([]byte("abcdef")); n != 4 || err != conq.ErrBudgetExceeded {
+		t.Fail()
+		t.Logf("%s: wrote %d bytes: %v", name, n, err)
+	}
+}
+
+func shouldStreamWritten(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	w := &conq.Writer{ChunkSize: 3, Queue: queue}
+
+	w.Write([]byte("hello "))
+	queue.Enqueue("world")
+	w.Close()
+
+	data, err := io.ReadAll(conq.NewReader(queue))
+
+	if string(data) != "hello world" || err != nil {
+		t.Fail()
+		t.Logf("%s: did not stream written bytes %q: %v", name, data, err)
+	}
+}
+
+func shouldRejectNotBytes(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	r := conq.NewReader(queue)
+	buf := make([]byte, 4)
+
+	queue.Enqueue(1)
+	queue.Enqueue("ok")
+
+	_, err := r.Read(buf)
+	n, _ := r.Read(buf)
+
+	if err != conq.ErrNotBytes || string(buf[:n]) != "ok" {
+		t.Fail()
+		t.Logf("%s: did not reject item: %v", name, err)
+	}
+}
+
+func shouldBlockUntilBytes(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	result := make(chan string)
+
+	go func() {
+		buf := make([]byte, 8)
+		n, _ := conq.NewReader(queue).Read(buf)
+		result <- string(buf[:n])
+	}()
+
+	queue.Enqueue([]byte("late"))
+
+	if s := <-result; s != "late" {
+		t.Fail()
+		t.Logf("%s: did not block until bytes %q", name, s)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"runtime/trace"
+	"time"
+)
+
+type envelope struct {
+	enqueued time.Time
+	handle   *Handle
+	item     interface{}
+	sample   *sample
+	task     *trace.Task
+}
+
+func traceItem(item interface{}) interface{} {
+	if !trace.IsEnabled() {
+		return item
+	}
+
+	_, task := trace.NewTask(context.Background(), "conq.Queue.item")
+
+	return &envelope{item: item, task: task}
+}
+
+func traceRegion(enabled bool) *trace.Region {
+	if !enabled || !trace.IsEnabled() {
+		return nil
+	}
+
+	return trace.StartRegion(context.Background(), "conq.Queue.DequeueBlocking")
+}
+
+func settle(val interface{}) {
+	e, ok := val.(*envelope)
+	if !ok {
+		return
+	}
+
+	if e.task != nil {
+		e.task.End()
+	}
+
+	if e.handle != nil {
+		e.handle.settled = true
+	}
+}
+
+func untrace(val interface{}) interface{} {
+	if e, ok := val.(*envelope); ok {
+		return e.item
+	}
+
+	return val
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"bytes"
+	"github.com/sebuckler/conq"
+	"runtime/trace"
+	"testing"
+	"time"
+)
+
+func TestQueue_Trace(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should have items while tracing": shouldHaveTracedItems,
+	}
+
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skipf("could not start trace: %v", err)
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+
+	trace.Stop()
+
+	if buf.Len() == 0 {
+		t.Fail()
+		t.Log("did not write trace")
+	}
+}
+
+func shouldHaveTracedItems(t *testing.T, name string) {
+	queue := &conq.Queue{Capacity: 3, Trace: true}
+	isTwo := func(item interface{}) bool { return item == 2 }
+	var actual []int
+
+	queue.Enqueue(1)
+	queue.Enqueue(2)
+	queue.Enqueue(3)
+
+	if queue.Position(isTwo) != 1 || queue.Remove(3) != 1 {
+		t.Fail()
+		t.Logf("%s: did not find traced items", name)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		queue.Enqueue(4)
+	}()
+
+	actual = append(actual, queue.Dequeue().(int))
+	actual = append(actual, queue.DequeueBlocking(time.Second, 0).(int))
+	actual = append(actual, queue.DequeueBlocking(time.Second, 0).(int))
+
+	if actual[0] != 1 || actual[1] != 2 || actual[2] != 4 || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: did not have correct items %v", name, actual)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+func (q *Queue) transfer(item interface{}) bool {
+	if len(q.takers) == 0 || q.pending() > 0 || item == ErrClosed {
+		return false
+	}
+
+	if q.Trace || q.Sampler != nil || q.group != nil || q.mirror != nil {
+		return false
+	}
+
+	taker := q.takers[0]
+	q.takers[0] = nil
+	q.takers = q.takers[1:]
+
+	if len(q.takers) == 0 {
+		q.takers = nil
+	}
+
+	q.enqueued += 1
+	q.dequeued += 1
+
+	if q.TrackAge {
+		q.ages.observe(0)
+	}
+
+	if q.shadow != nil {
+		q.shadow.record(item)
+	}
+
+	taker <- item
+
+	return true
+}
+
+func (q *Queue) take(taker chan interface{}) {
+	for _, t := range q.takers {
+		if t == taker {
+			return
+		}
+	}
+
+	q.takers = append(q.takers, taker)
+}
+
+func (q *Queue) untake(taker chan interface{}) (interface{}, bool) {
+	for i, t := range q.takers {
+		if t == taker {
+			q.takers = append(q.takers[:i], q.takers[i+1:]...)
+
+			break
+		}
+	}
+
+	if len(q.takers) == 0 {
+		q.takers = nil
+	}
+
+	select {
+	case val := <-taker:
+		return val, true
+	default:
+		return nil, false
+	}
+}
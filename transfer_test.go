@@ -0,0 +1,126 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQueue_EnqueueTransfer(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should hand items to waiting consumer":  shouldTransferToWaitingConsumer,
+		"should keep items of canceled consumer": shouldKeepItemsOfCanceledConsumer,
+		"should hand items to blocked dequeue":   shouldTransferToBlockedDequeue,
+		"should hand items to polling dequeue":   shouldTransferToPollingDequeue,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldTransferToWaitingConsumer(t *testing.T, name string) {
+	handled := make(chan interface{})
+	processor := &conq.Processor{
+		Handle: func(item interface{}) { handled <- item },
+		Key:    func(item interface{}) string { return item.(string) },
+		Source: &conq.Queue{Size: lenOf, TrackAge: true},
+	}
+
+	done := make(chan error)
+	go func() { done <- processor.Run(context.Background()) }()
+
+	for i := 0; i < 100; i++ {
+		item := strconv.Itoa(i)
+		processor.Source.Enqueue(item)
+
+		if got := <-handled; got != item {
+			t.Fail()
+			t.Logf("%s: handled %v instead of %v", name, got, item)
+		}
+	}
+
+	stats := processor.Source.Stats()
+	processor.Source.EnqueuePoison(1)
+
+	if err := <-done; err != conq.ErrClosed || stats.Enqueued != 100 || stats.Dequeued != 100 || stats.Bytes != 0 || stats.Len != 0 {
+		t.Fail()
+		t.Logf("%s: did not hand off items: %+v: %v", name, stats, err)
+	}
+}
+
+func shouldKeepItemsOfCanceledConsumer(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	handled := 0
+
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		processor := &conq.Processor{
+			Handle: func(item interface{}) { handled += 1 },
+			Key:    func(item interface{}) string { return strconv.Itoa(item.(int)) },
+			Source: queue,
+		}
+
+		done := make(chan struct{})
+		go func() {
+			processor.Run(ctx)
+			close(done)
+		}()
+
+		queue.Enqueue(i)
+		cancel()
+		<-done
+	}
+
+	if handled+queue.Len() != 100 {
+		t.Fail()
+		t.Logf("%s: lost %d items", name, 100-handled-queue.Len())
+	}
+}
+
+func shouldTransferToBlockedDequeue(t *testing.T, name string) {
+	queue := &conq.Queue{}
+	result := make(chan interface{})
+
+	go func() { result <- queue.DequeueBlocking(0, time.Hour) }()
+
+	time.Sleep(10 * time.Millisecond)
+	queue.Enqueue(1)
+	n := queue.Len()
+
+	select {
+	case got := <-result:
+		if got != 1 || n != 0 {
+			t.Fail()
+			t.Logf("%s: dequeued %v with %d items in the queue", name, got, n)
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+		t.Logf("%s: did not hand item to blocked dequeue", name)
+	}
+}
+
+func shouldTransferToPollingDequeue(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	queue := &conq.Queue{Clock: sim}
+	var got interface{}
+	n := -1
+
+	sim.Go(func() { got = queue.DequeueBlocking(0, time.Hour) })
+	sim.Go(func() {
+		sim.Sleep(time.Second)
+		queue.Enqueue(1)
+		n = queue.Len()
+	})
+
+	if err := sim.Run(); err != nil || got != 1 || n != 0 {
+		t.Fail()
+		t.Logf("%s: dequeued %v with %d items in the queue: %v", name, got, n, err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+)
+
+const watchBuffer = 64
+
+type watcher struct {
+	ch      chan interface{}
+	consume bool
+	pred    func(item interface{}) bool
+}
+
+/*
+Watch returns a channel that receives the items enqueued from now on for which
+pred returns true, so components can react to control messages that travel
+through a shared queue. If consume is false, the items are still enqueued, and
+every watch of the queue receives them. If consume is true, a matching item is
+received instead of being enqueued, by the first consuming watch that has room
+for it. The channel has a buffer of 64 items, and a watch skips the items that
+arrive while its buffer is full, so a consuming watch that falls behind leaves
+the items in the queue. Items enqueued with EnqueueHandle are never consumed,
+and poison items are never watched.
+
+The channel is closed once the context is done, and the items already in its
+buffer can still be received. pred is called while the queue is locked, so it
+must not call methods of the queue.
+*/
+func (q *Queue) Watch(ctx context.Context, pred func(item interface{}) bool, consume bool) <-chan interface{} {
+	w := &watcher{ch: make(chan interface{}, watchBuffer), consume: consume, pred: pred}
+
+	q.lock()
+	q.watchers = append(q.watchers, w)
+	q.unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		q.lock()
+		defer q.unlock()
+
+		for i, other := range q.watchers {
+			if other == w {
+				q.watchers = append(q.watchers[:i:i], q.watchers[i+1:]...)
+
+				break
+			}
+		}
+
+		close(w.ch)
+	}()
+
+	return w.ch
+}
+
+func (q *Queue) watch(item interface{}, consume bool) bool {
+	consumed := false
+
+	for _, w := range q.watchers {
+		if (w.consume && (consumed || !consume)) || !w.pred(item) {
+			continue
+		}
+
+		select {
+		case w.ch <- item:
+			consumed = consumed || w.consume
+		default:
+			break
+		}
+	}
+
+	return consumed
+}
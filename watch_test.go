@@ -0,0 +1,150 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"testing"
+	"time"
+)
+
+func TestQueue_Watch(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should receive matching items":           shouldWatchMatchingItems,
+		"should consume matching items":           shouldWatchConsume,
+		"should consume once for several watches": shouldWatchConsumeOnce,
+		"should enqueue when buffer is full":      shouldWatchEnqueueWhenFull,
+		"should not consume handles":              shouldWatchNotConsumeHandles,
+		"should close when context is done":       shouldWatchCloseOnDone,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func isControl(item interface{}) bool {
+	s, ok := item.(string)
+
+	return ok && s == "reload"
+}
+
+func shouldWatchMatchingItems(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := &conq.Queue{}
+	ch := queue.Watch(ctx, isControl, false)
+
+	queue.Enqueue(1)
+	queue.Enqueue("reload")
+	queue.Enqueue(2)
+
+	got := <-ch
+
+	if got != "reload" || len(ch) != 0 || queue.Len() != 3 {
+		t.Fail()
+		t.Logf("%s: received %v with %d items left in the queue", name, got, queue.Len())
+	}
+}
+
+func shouldWatchConsume(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := &conq.Queue{}
+	ch := queue.Watch(ctx, isControl, true)
+
+	queue.Enqueue(1)
+	queue.Enqueue("reload")
+
+	got := <-ch
+	stats := queue.Stats()
+
+	if got != "reload" || queue.Len() != 1 || queue.Dequeue() != 1 || stats.Enqueued != 2 || stats.Dequeued != 1 {
+		t.Fail()
+		t.Logf("%s: received %v with stats %+v", name, got, stats)
+	}
+}
+
+func shouldWatchConsumeOnce(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := &conq.Queue{}
+	first := queue.Watch(ctx, isControl, true)
+	second := queue.Watch(ctx, isControl, true)
+	observer := queue.Watch(ctx, isControl, false)
+
+	queue.Enqueue("reload")
+
+	if len(first) != 1 || len(second) != 0 || len(observer) != 1 || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: delivered to %d, %d, and %d watches", name, len(first), len(second), len(observer))
+	}
+}
+
+func shouldWatchEnqueueWhenFull(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := &conq.Queue{}
+	ch := queue.Watch(ctx, isControl, true)
+
+	for i := 0; i < cap(ch)+1; i++ {
+		queue.Enqueue("reload")
+	}
+
+	if len(ch) != cap(ch) || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: buffered %d items and enqueued %d", name, len(ch), queue.Len())
+	}
+}
+
+func shouldWatchNotConsumeHandles(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := &conq.Queue{}
+	ch := queue.Watch(ctx, isControl, true)
+
+	queue.EnqueueHandle("reload")
+
+	if len(ch) != 0 || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: consumed item with handle", name)
+	}
+}
+
+func shouldWatchCloseOnDone(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	queue := &conq.Queue{}
+	ch := queue.Watch(ctx, isControl, true)
+
+	queue.Enqueue("reload")
+	cancel()
+
+	got := <-ch
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fail()
+			t.Logf("%s: received item after the context was done", name)
+		}
+	case <-time.After(time.Second):
+		t.Fail()
+		t.Logf("%s: did not close channel", name)
+	}
+
+	queue.Enqueue("reload")
+
+	if got != "reload" || queue.Len() != 1 {
+		t.Fail()
+		t.Logf("%s: received %v with %d items in the queue", name, got, queue.Len())
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const weightDrift = 1 << 20
+
+/*
+WeightedQueue is a queue that dequeues a random item, where the probability of
+an item being dequeued is proportional to its weight. It is useful for
+probabilistic sampling and for splitting queued work between destinations. The
+weights are kept in a Fenwick tree, so enqueuing and dequeuing are O(log n).
+Once the total weight falls far below the largest total it had, like after a
+heavy item is dequeued, the tree is rebuilt from the weights of the items, so
+rounding errors left by the heavy item do not skew the light ones.
+
+Items with a weight that is not greater than 0 are kept apart in FIFO order,
+and they are only dequeued once no items with a weight remain. Rand is the
+source of randomness, and it defaults to the math/rand package source. Rand is
+only used while the queue is locked, so it does not need to be thread-safe.
+*/
+type WeightedQueue struct {
+	Capacity int        // soft cap for underlying slice of items in queue
+	Clock    Clock      // tells time and sleeps, defaults to the system clock
+	Rand     *rand.Rand // source of randomness, defaults to math/rand
+	changed  chan struct{}
+	items    []interface{}
+	mut      sync.Mutex
+	peak     float64
+	tree     []float64
+	weights  []float64
+	zero     Queue
+}
+
+/*
+Enqueue adds a new item of any type to the queue with a weight. Enqueue locks
+the queue while it is adding the item.
+*/
+func (w *WeightedQueue) Enqueue(item interface{}, weight float64) {
+	w.mut.Lock()
+
+	if weight <= 0 || math.IsNaN(weight) {
+		w.zero.Capacity = w.Capacity
+		w.zero.enqueue(item)
+	} else {
+		w.push(item, weight)
+	}
+
+	if w.changed != nil {
+		close(w.changed)
+		w.changed = nil
+	}
+
+	w.mut.Unlock()
+}
+
+/*
+Dequeue will attempt to retrieve a random item from the queue, chosen with a
+probability proportional to its weight. If the queue is empty no item is
+returned and the interface{} can be asserted against nil. Dequeue locks the
+queue while it is retrieving the item.
+*/
+func (w *WeightedQueue) Dequeue() interface{} {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if w.len() == 0 {
+		return nil
+	}
+
+	return w.dequeue()
+}
+
+/*
+DequeueBlocking will attempt to retrieve a random item from the queue and
+block until there is an item in the queue. The timeout and interval work the
+same as they do for Queue.DequeueBlocking, and Enqueue wakes waiting calls.
+DequeueBlocking locks the queue during each poll, but it unlocks the queue
+between cycles to allow items to be enqueued.
+*/
+func (w *WeightedQueue) DequeueBlocking(timeout time.Duration, interval time.Duration) interface{} {
+	clock := clockOr(w.Clock)
+	start := clock.Now()
+	w.mut.Lock()
+
+	for w.len() == 0 {
+		if w.changed == nil {
+			w.changed = make(chan struct{})
+		}
+
+		changed := w.changed
+		w.mut.Unlock()
+
+		waited := clock.Now().Sub(start)
+		if timeout > 0 && waited >= timeout {
+			return nil
+		}
+
+		sleep := interval
+		if timeout > 0 && (sleep <= 0 || sleep > timeout-waited) {
+			sleep = timeout - waited
+		}
+
+		pause(clock, sleep, changed, nil, nil)
+		w.mut.Lock()
+	}
+
+	val := w.dequeue()
+	w.mut.Unlock()
+
+	return val
+}
+
+/*
+DequeueUntil will attempt to retrieve a random item and block until the
+deadline instead of for a timeout. It works the same as Queue.DequeueUntil, and
+the deadline is measured by the Clock of the queue.
+*/
+func (w *WeightedQueue) DequeueUntil(deadline time.Time, interval time.Duration) interface{} {
+	if deadline.IsZero() {
+		return w.DequeueBlocking(0, interval)
+	}
+
+	timeout := deadline.Sub(clockOr(w.Clock).Now())
+	if timeout <= 0 {
+		return w.Dequeue()
+	}
+
+	return w.DequeueBlocking(timeout, interval)
+}
+
+/*
+Len returns how many items are enqueued. Len locks the queue.
+*/
+func (w *WeightedQueue) Len() int {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	return w.len()
+}
+
+func (w *WeightedQueue) dequeue() interface{} {
+	if len(w.items) == 0 {
+		val, _ := w.zero.dequeue()
+
+		return val
+	}
+
+	i := w.search(w.random() * w.sum(len(w.tree)))
+	val := w.items[i]
+	last := len(w.items) - 1
+
+	if i != last {
+		w.items[i] = w.items[last]
+		w.update(i, w.weights[last])
+	}
+
+	w.update(last, 0)
+	w.items[last] = nil
+	w.items = w.items[:last]
+	w.tree = w.tree[:last]
+	w.weights = w.weights[:last]
+
+	if last == 0 {
+		w.peak = 0
+	} else if w.sum(last) < w.peak/weightDrift {
+		w.rebuild()
+	}
+
+	return val
+}
+
+func (w *WeightedQueue) len() int {
+	return len(w.items) + w.zero.len
+}
+
+func (w *WeightedQueue) push(item interface{}, weight float64) {
+	if w.items == nil {
+		w.items = make([]interface{}, 0, w.Capacity)
+		w.tree = make([]float64, 0, w.Capacity)
+		w.weights = make([]float64, 0, w.Capacity)
+	}
+
+	i := len(w.weights) + 1
+	node := weight + w.sum(i-1) - w.sum(i-i&-i)
+
+	w.items = append(w.items, item)
+	w.tree = append(w.tree, node)
+	w.weights = append(w.weights, weight)
+
+	if total := w.sum(i); total > w.peak {
+		w.peak = total
+	}
+}
+
+func (w *WeightedQueue) random() float64 {
+	if w.Rand == nil {
+		return rand.Float64()
+	}
+
+	return w.Rand.Float64()
+}
+
+func (w *WeightedQueue) rebuild() {
+	copy(w.tree, w.weights)
+
+	for i := 1; i <= len(w.tree); i++ {
+		if j := i + i&-i; j <= len(w.tree) {
+			w.tree[j-1] += w.tree[i-1]
+		}
+	}
+
+	w.peak = w.sum(len(w.tree))
+}
+
+func (w *WeightedQueue) search(target float64) int {
+	n := len(w.tree)
+	pos := 0
+
+	step := 1
+	for step*2 <= n {
+		step *= 2
+	}
+
+	for ; step > 0; step /= 2 {
+		if next := pos + step; next <= n && w.tree[next-1] <= target {
+			pos = next
+			target -= w.tree[next-1]
+		}
+	}
+
+	if pos >= n {
+		pos = n - 1
+	}
+
+	return pos
+}
+
+func (w *WeightedQueue) sum(i int) float64 {
+	var total float64
+
+	for ; i > 0; i -= i & -i {
+		total += w.tree[i-1]
+	}
+
+	return total
+}
+
+func (w *WeightedQueue) update(i int, weight float64) {
+	delta := weight - w.weights[i]
+	w.weights[i] = weight
+
+	for i += 1; i <= len(w.tree); i += i & -i {
+		w.tree[i-1] += delta
+	}
+}
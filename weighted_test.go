@@ -0,0 +1,179 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"github.com/sebuckler/conq"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWeightedQueue_Dequeue(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should favor items with more weight":   shouldFavorWeightedItems,
+		"should have every item once":           shouldHaveEveryWeightedItem,
+		"should be nil when no items queued":    shouldDequeueNilWeighted,
+		"should be nil when blocking times out": shouldDequeueNilWeightedBlocking,
+		"should publish writes before enqueue":  shouldPublishWeighted,
+		"should keep odds after heavy item":     shouldKeepWeightedOdds,
+		"should dequeue zero weights in order":  shouldDequeueZeroWeightedInOrder,
+		"should wake when item enqueued":        shouldWakeWeightedEnqueued,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func shouldFavorWeightedItems(t *testing.T, name string) {
+	queue := &conq.WeightedQueue{Capacity: 2, Rand: rand.New(rand.NewSource(1))}
+	heavy := 0
+
+	for range [1000]int{} {
+		queue.Enqueue("light", 1)
+		queue.Enqueue("heavy", 99)
+
+		if queue.Dequeue() == "heavy" {
+			heavy += 1
+		}
+
+		queue.Dequeue()
+	}
+
+	if heavy < 950 || queue.Len() != 0 {
+		t.Fail()
+		t.Logf("%s: heavy item was only dequeued first %d times", name, heavy)
+	}
+}
+
+func shouldHaveEveryWeightedItem(t *testing.T, name string) {
+	queue := &conq.WeightedQueue{Capacity: 100, Rand: rand.New(rand.NewSource(1))}
+	var actual []int
+
+	for i := 0; i < 100; i++ {
+		queue.Enqueue(i, float64(i%7))
+
+		if i%3 == 0 {
+			actual = append(actual, queue.Dequeue().(int))
+		}
+	}
+
+	for queue.Len() > 0 {
+		actual = append(actual, queue.DequeueBlocking(0, 0).(int))
+	}
+
+	sort.Ints(actual)
+
+	for i, val := range actual {
+		if i != val {
+			t.Fail()
+			t.Logf("%s: did not have every item once %v", name, actual)
+
+			return
+		}
+	}
+}
+
+func shouldDequeueNilWeighted(t *testing.T, name string) {
+	queue := &conq.WeightedQueue{Capacity: 3}
+
+	if queue.Dequeue() != nil {
+		t.Fail()
+		t.Logf("%s: was not nil", name)
+	}
+}
+
+func shouldDequeueNilWeightedBlocking(t *testing.T, name string) {
+	queue := &conq.WeightedQueue{Capacity: 3}
+
+	if queue.DequeueBlocking(time.Microsecond, 0) != nil {
+		t.Fail()
+		t.Logf("%s: was not nil after timeout", name)
+	}
+}
+
+func shouldPublishWeighted(t *testing.T, name string) {
+	queue := &conq.WeightedQueue{}
+
+	checkPublished(t, name, func(item interface{}) { queue.Enqueue(item, 1) }, queue.Dequeue)
+}
+
+func shouldKeepWeightedOdds(t *testing.T, name string) {
+	queue := &conq.WeightedQueue{Rand: rand.New(rand.NewSource(1))}
+	counts := map[interface{}]int{}
+
+	for range [3000]int{} {
+		queue.Enqueue("a", 1e17)
+		queue.Enqueue("b", 1)
+		queue.Enqueue("c", 1)
+		queue.Enqueue("d", 1)
+
+		if first := queue.Dequeue(); first != "a" {
+			t.Fail()
+			t.Logf("%s: dequeued %v before the heavy item", name, first)
+
+			return
+		}
+
+		counts[queue.Dequeue()] += 1
+		queue.Dequeue()
+		queue.Dequeue()
+	}
+
+	for _, item := range []string{"b", "c", "d"} {
+		if counts[item] < 900 || counts[item] > 1100 {
+			t.Fail()
+			t.Logf("%s: light items were dequeued first %v times", name, counts)
+
+			return
+		}
+	}
+}
+
+func shouldDequeueZeroWeightedInOrder(t *testing.T, name string) {
+	queue := &conq.WeightedQueue{Rand: rand.New(rand.NewSource(1))}
+
+	queue.Enqueue(1, 0)
+	queue.Enqueue(2, -1)
+	queue.Enqueue("a", 1)
+	queue.Enqueue(3, 0)
+
+	var actual []interface{}
+	for queue.Len() > 0 {
+		actual = append(actual, queue.Dequeue())
+	}
+
+	if !reflect.DeepEqual(actual, []interface{}{"a", 1, 2, 3}) {
+		t.Fail()
+		t.Logf("%s: dequeued %v", name, actual)
+	}
+}
+
+func shouldWakeWeightedEnqueued(t *testing.T, name string) {
+	for _, interval := range []time.Duration{0, time.Hour} {
+		sim := &conq.Sim{}
+		queue := &conq.WeightedQueue{Clock: sim}
+		start := sim.Now()
+
+		var got interface{}
+		var waited time.Duration
+		sim.Go(func() {
+			got = queue.DequeueBlocking(0, interval)
+			waited = sim.Now().Sub(start)
+		})
+		sim.Go(func() {
+			sim.Sleep(time.Second)
+			queue.Enqueue(1, 1)
+		})
+
+		if err := sim.Run(); err != nil || got != 1 || waited != time.Second {
+			t.Fail()
+			t.Logf("%s: dequeued %v after %v with interval %v: %v", name, got, waited, interval, err)
+		}
+	}
+}
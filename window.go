@@ -0,0 +1,191 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Window aggregates the items of a queue over sliding windows and enqueues one
+aggregated item per window into another queue, for metrics rollups and batched
+notifications. Aggregate is called with the items of each window in the order
+they were dequeued, and it defaults to enqueuing the []interface{} of the items
+itself.
+
+Windows are count based unless Span is set. A count window holds Size items,
+and a new window starts every Slide items, so windows overlap when Slide is
+less than Size and items are skipped when it is greater. A time window holds
+the items dequeued within the last Span, and one ends every Every, where the
+time of an item is when Window dequeued it. Time windows are measured by the
+Clock of the queue the items are dequeued from, and empty ones are skipped.
+*/
+type Window struct {
+	Aggregate func(items []interface{}) interface{} // aggregates the items of a window, defaults to the items
+	Every     time.Duration                         // time between the ends of time windows, defaults to Span
+	Size      int                                   // items per count window, defaults to 100
+	Slide     int                                   // items between the starts of count windows, defaults to Size
+	Span      time.Duration                         // length of time windows, count windows when 0
+}
+
+type windowItem struct {
+	at   time.Time
+	item interface{}
+}
+
+/*
+Run dequeues items from in and enqueues the aggregate of each window into out
+until the context is done or a poison item is dequeued, and it returns the
+error of the context or ErrClosed. When a poison item is dequeued, the items
+dequeued since the last window ended are aggregated into one last window. If
+TryEnqueue returns an error, Run stops and returns it, and if in is interrupted
+while Run waits for an item, Run returns ErrInterrupted.
+*/
+func (w *Window) Run(ctx context.Context, in *Queue, out *Queue) error {
+	if w.Span > 0 {
+		return w.runTime(ctx, in, out)
+	}
+
+	return w.runCount(ctx, in, out)
+}
+
+func (w *Window) runCount(ctx context.Context, in *Queue, out *Queue) error {
+	size := w.Size
+	if size <= 0 {
+		size = 100
+	}
+
+	slide := w.Slide
+	if slide <= 0 {
+		slide = size
+	}
+
+	var items []interface{}
+	fresh, skip := 0, 0
+
+	for {
+		item, err := in.dequeueContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if item == ErrClosed {
+			if fresh > 0 {
+				if err := w.emit(out, items); err != nil {
+					return err
+				}
+			}
+
+			return ErrClosed
+		}
+
+		if skip > 0 {
+			skip -= 1
+
+			continue
+		}
+
+		items = append(items, item)
+		fresh += 1
+
+		if len(items) < size {
+			continue
+		}
+
+		if err := w.emit(out, items); err != nil {
+			return err
+		}
+
+		fresh = 0
+
+		if slide >= size {
+			items = items[:0]
+			skip = slide - size
+		} else {
+			items = append(items[:0], items[slide:]...)
+		}
+	}
+}
+
+func (w *Window) runTime(ctx context.Context, in *Queue, out *Queue) error {
+	every := w.Every
+	if every <= 0 {
+		every = w.Span
+	}
+
+	clock := clockOr(in.Clock)
+	var items []windowItem
+	fresh := false
+	next := clock.Now().Add(every)
+
+	for {
+		item, _, err := in.dequeueBy(ctx, next)
+		now := clock.Now()
+
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err == ErrInterrupted {
+			return err
+		}
+
+		for !now.Before(next) {
+			if window := windowed(items, next.Add(-w.Span), next); len(window) > 0 {
+				if err := w.emit(out, window); err != nil {
+					return err
+				}
+			}
+
+			fresh = false
+			next = next.Add(every)
+		}
+
+		expired := 0
+		for expired < len(items) && items[expired].at.Before(next.Add(-w.Span)) {
+			expired += 1
+		}
+
+		items = append(items[:0], items[expired:]...)
+
+		if err == nil && item == ErrClosed {
+			if window := windowed(items, next.Add(-w.Span), next); fresh && len(window) > 0 {
+				if err := w.emit(out, window); err != nil {
+					return err
+				}
+			}
+
+			return ErrClosed
+		}
+
+		if err == nil {
+			items = append(items, windowItem{at: now, item: item})
+			fresh = true
+		}
+	}
+}
+
+func (w *Window) emit(out *Queue, items []interface{}) error {
+	window := append([]interface{}(nil), items...)
+
+	if w.Aggregate == nil {
+		return out.TryEnqueue(window)
+	}
+
+	return out.TryEnqueue(w.Aggregate(window))
+}
+
+func windowed(items []windowItem, start, end time.Time) []interface{} {
+	var window []interface{}
+
+	for _, item := range items {
+		if !item.at.Before(start) && item.at.Before(end) {
+			window = append(window, item.item)
+		}
+	}
+
+	return window
+}
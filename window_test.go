@@ -0,0 +1,148 @@
+// Copyright 2020 Stephen Buckler. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package conq_test
+
+import (
+	"context"
+	"github.com/sebuckler/conq"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWindow_Run(t *testing.T) {
+	testCases := map[string]func(t *testing.T, name string){
+		"should aggregate tumbling windows":  shouldAggregateTumblingWindows,
+		"should aggregate sliding windows":   shouldAggregateSlidingWindows,
+		"should skip items between windows":  shouldSkipBetweenWindows,
+		"should flush last window on close":  shouldFlushLastWindow,
+		"should aggregate time windows":      shouldAggregateTimeWindows,
+		"should stop when context is done":   shouldStopWindowOnDone,
+		"should measure time by queue clock": shouldMeasureWindowByClock,
+	}
+
+	for name, test := range testCases {
+		test(t, name)
+	}
+}
+
+func sum(items []interface{}) interface{} {
+	total := 0
+	for _, item := range items {
+		total += item.(int)
+	}
+
+	return total
+}
+
+func runWindow(w *conq.Window, items ...interface{}) ([]interface{}, error) {
+	in, out := &conq.Queue{}, &conq.Queue{}
+	for _, item := range items {
+		in.Enqueue(item)
+	}
+
+	in.EnqueuePoison(1)
+	err := w.Run(context.Background(), in, out)
+
+	return out.Snapshot(), err
+}
+
+func shouldAggregateTumblingWindows(t *testing.T, name string) {
+	got, err := runWindow(&conq.Window{Aggregate: sum, Size: 2}, 1, 2, 3, 4)
+
+	if err != conq.ErrClosed || !reflect.DeepEqual(got, []interface{}{3, 7}) {
+		t.Fail()
+		t.Logf("%s: enqueued %v with error %v", name, got, err)
+	}
+}
+
+func shouldAggregateSlidingWindows(t *testing.T, name string) {
+	got, err := runWindow(&conq.Window{Aggregate: sum, Size: 3, Slide: 1}, 1, 2, 3, 4, 5)
+
+	if err != conq.ErrClosed || !reflect.DeepEqual(got, []interface{}{6, 9, 12}) {
+		t.Fail()
+		t.Logf("%s: enqueued %v with error %v", name, got, err)
+	}
+}
+
+func shouldSkipBetweenWindows(t *testing.T, name string) {
+	got, err := runWindow(&conq.Window{Size: 2, Slide: 3}, 1, 2, 3, 4, 5, 6)
+
+	want := []interface{}{[]interface{}{1, 2}, []interface{}{4, 5}}
+	if err != conq.ErrClosed || !reflect.DeepEqual(got, want) {
+		t.Fail()
+		t.Logf("%s: enqueued %v with error %v", name, got, err)
+	}
+}
+
+func shouldFlushLastWindow(t *testing.T, name string) {
+	got, err := runWindow(&conq.Window{Aggregate: sum, Size: 2}, 1, 2, 3)
+
+	if err != conq.ErrClosed || !reflect.DeepEqual(got, []interface{}{3, 3}) {
+		t.Fail()
+		t.Logf("%s: enqueued %v with error %v", name, got, err)
+	}
+}
+
+func shouldAggregateTimeWindows(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in, out := &conq.Queue{}, &conq.Queue{}
+	window := &conq.Window{Aggregate: sum, Span: 50 * time.Millisecond}
+	result := make(chan error)
+
+	go func() { result <- window.Run(ctx, in, out) }()
+
+	in.Enqueue(1)
+	in.Enqueue(2)
+	first := out.DequeueBlocking(time.Second, time.Millisecond)
+
+	in.Enqueue(4)
+	in.EnqueuePoison(1)
+	err := <-result
+	last := out.Dequeue()
+
+	if first != 3 || last != 4 || err != conq.ErrClosed {
+		t.Fail()
+		t.Logf("%s: enqueued %v and %v with error %v", name, first, last, err)
+	}
+}
+
+func shouldStopWindowOnDone(t *testing.T, name string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := (&conq.Window{}).Run(ctx, &conq.Queue{}, &conq.Queue{})
+
+	if err != context.Canceled {
+		t.Fail()
+		t.Logf("%s: returned %v instead of context error", name, err)
+	}
+}
+
+func shouldMeasureWindowByClock(t *testing.T, name string) {
+	sim := &conq.Sim{}
+	in, out := &conq.Queue{Clock: sim}, &conq.Queue{}
+	window := &conq.Window{Aggregate: sum, Every: 30 * time.Second, Span: time.Minute}
+
+	var err error
+	sim.Go(func() { err = window.Run(context.Background(), in, out) })
+	sim.Go(func() {
+		sim.Sleep(10 * time.Second)
+		in.Enqueue(1)
+		sim.Sleep(30 * time.Second)
+		in.Enqueue(2)
+		sim.Sleep(30 * time.Second)
+		in.Enqueue(3)
+		sim.Sleep(10 * time.Second)
+		in.EnqueuePoison(1)
+	})
+
+	if simErr := sim.Run(); simErr != nil || err != conq.ErrClosed || !reflect.DeepEqual(out.Snapshot(), []interface{}{1, 3, 5}) {
+		t.Fail()
+		t.Logf("%s: enqueued %v with error %v %v", name, out.Snapshot(), err, simErr)
+	}
+}